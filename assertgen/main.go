@@ -76,7 +76,7 @@ func main() {
 	defer source.Close()
 
 	// Allowed SPIFFE ID
-	serverID := spiffeid.RequireTrustDomainFromString("example.org")
+	serverID := spiffeid.RequireTrustDomainFromString(os.Getenv("TRUST_DOMAIN"))
 
 	// Create a `tls.Config` to allow mTLS connections, and verify that presented certificate match allowed SPIFFE ID rule
 	tlsConfig := tlsconfig.MTLSClientConfig(source, source, tlsconfig.AuthorizeMemberOf(serverID))
@@ -141,6 +141,14 @@ Main functions:
   - appsch
   	  Appent an assertion with schnorr signature
   	  usage: ./main appsch originaltoken assertionKey assertionValue
+  - lintlsvid
+	  Flag suspicious LSVID chains (loops, iat going backwards, unexpected trust domains,
+	  alg mismatches, oversized chains, missing dpr), with severities. Exits with 1 on errors
+	  usage: ./assertgen lintlsvid <lsvid> [json|text]
+  - renderlsvid
+	  Render LSVID chain with the verification status of each hop, as tree, JSON or DOT graph
+	  usage: ./assertgen renderlsvid <lsvid> [tree|json|dot]
+	  e.g.: ./assertgen renderlsvid <lsvid> dot | dot -Tsvg > lsvid.svg
 `)
 	os.Exit(1)
 
@@ -1004,6 +1012,7 @@ Main functions:
 		}
 
 		fmt.Printf("Decoded LSVID to be validated: %v\n", decLSVID)
+		lsvid.PrintLSVID(decLSVID.Token)
 
 		validateLSVID, err := lsvid.Validate(decLSVID.Token)
 		if err != nil {
@@ -1017,6 +1026,73 @@ Main functions:
 
 		fmt.Printf("Validation successful! :D\n")
 
+	case "lintlsvid":
+		//  ./assertgen lintlsvid <lsvid> [json|text]
+		// Flag suspicious chains. Exits with 1 if any finding is an error
+
+		decLSVID, err := lsvid.Decode(os.Args[2])
+		if err != nil {
+			fmt.Printf("Error decoding LSVID: %v\n", err)
+			os.Exit(1)
+		}
+
+		var opts []lsvid.LintOption
+		if os.Getenv("TRUST_DOMAIN") != "" {
+			opts = append(opts, lsvid.WithLintTrustDomains(spiffeid.RequireTrustDomainFromString(os.Getenv("TRUST_DOMAIN"))))
+		}
+		findings := lsvid.Lint(decLSVID.Token, opts...)
+
+		if len(os.Args) > 3 && os.Args[3] == "text" {
+			for _, finding := range findings {
+				fmt.Println(finding)
+			}
+		} else {
+			findingsJSON, err := json.MarshalIndent(findings, "", "  ")
+			if err != nil {
+				log.Fatalf("Error generating json: %v\n", err)
+			}
+			fmt.Printf("%s\n", findingsJSON)
+		}
+
+		for _, finding := range findings {
+			if finding.Severity == lsvid.SeverityError {
+				os.Exit(1)
+			}
+		}
+
+	case "renderlsvid":
+		//  ./assertgen renderlsvid <lsvid> [tree|json|dot]
+		// Render the LSVID chain, with the verification status of each hop
+
+		decLSVID, err := lsvid.Decode(os.Args[2])
+		if err != nil {
+			fmt.Printf("Error decoding LSVID: %v\n", err)
+			os.Exit(1)
+		}
+
+		format := "tree"
+		if len(os.Args) > 3 {
+			format = os.Args[3]
+		}
+
+		view := lsvid.NewChainView(decLSVID.Token)
+		switch format {
+		case "tree":
+			fmt.Print(view.Tree())
+		case "json":
+			viewJSON, err := view.JSON()
+			if err != nil {
+				fmt.Printf("Error rendering LSVID: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("%s\n", viewJSON)
+		case "dot":
+			fmt.Print(view.DOT())
+		default:
+			fmt.Printf("Unknown format %q, use tree, json or dot\n", format)
+			os.Exit(1)
+		}
+
 
 
 		// continue...
@@ -1055,6 +1131,12 @@ func ParseEnvironment() {
 		log.Printf("Could not resolve a SOCKET_PATH environment variable.")
 		// os.Exit(1)
 	}
+
+	setEnvVariable("TRUST_DOMAIN", os.Getenv("TRUST_DOMAIN"))
+	if os.Getenv("TRUST_DOMAIN") == "" {
+		log.Printf("Could not resolve a TRUST_DOMAIN environment variable. Using example.org")
+		os.Setenv("TRUST_DOMAIN", "example.org")
+	}
 	
 }
 
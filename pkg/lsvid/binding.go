@@ -0,0 +1,137 @@
+package lsvid
+
+import (
+	"bytes"
+	hash256 "crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Request components that can be bound to a hop.
+// A single query parameter is bound with ComponentQueryParam + name, e.g.: "@query-param:deposit".
+const (
+	ComponentMethod     = "@method"
+	ComponentAuthority  = "@authority"
+	ComponentPath       = "@path"
+	ComponentQuery      = "@query"
+	ComponentQueryParam = "@query-param:"
+	ComponentBody       = "@body"
+)
+
+// RequestBinding binds a hop to the request it is sent with, so the delegation
+// covers the actual operation and not only the called workload.
+type RequestBinding struct {
+	Com []string `json:"com"` // bound request components
+	Dig []byte   `json:"dig"` // digest of the component values
+}
+
+// BindRequest computes the binding of the request components, to be set in the
+// hop payload (Payload.Req) before sending the request. The body can only be bound
+// if the LSVID is not sent in it.
+func BindRequest(r *http.Request, components ...string) (*RequestBinding, error) {
+	if len(components) == 0 {
+		return nil, fmt.Errorf("No request component to bind\n")
+	}
+
+	digest, err := requestDigest(r, components)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RequestBinding{
+		Com: components,
+		Dig: digest,
+	}, nil
+}
+
+// VerifyRequestBinding checks that the outermost hop of the LSVID is bound to the received request.
+// The bound components are chosen by the sender, so the verifier gives the
+// components the binding must cover (e.g.: ComponentMethod, ComponentPath and
+// ComponentQueryParam+"deposit"): bindings of a subset are rejected.
+func VerifyRequestBinding(token *Token, r *http.Request, required ...string) error {
+	if len(required) == 0 {
+		return fmt.Errorf("No required request component\n")
+	}
+	if token == nil || token.Payload == nil || token.Payload.Req == nil {
+		return fmt.Errorf("LSVID is not bound to the request\n")
+	}
+	binding := token.Payload.Req
+	for _, component := range required {
+		if !containsString(binding.Com, component) {
+			return fmt.Errorf("LSVID binding does not cover %s\n", component)
+		}
+	}
+
+	digest, err := requestDigest(r, binding.Com)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(digest, binding.Dig) {
+		return fmt.Errorf("Request does not match the LSVID binding (%s)\n", strings.Join(binding.Com, ", "))
+	}
+	return nil
+}
+
+// requestDigest hashes the JSON list of each component name followed by its values,
+// so an absent query parameter differs from an empty one.
+func requestDigest(r *http.Request, components []string) ([]byte, error) {
+	values := make([][]string, 0, len(components))
+	for _, component := range components {
+		value := []string{component}
+
+		switch {
+		case component == ComponentMethod:
+			value = append(value, strings.ToUpper(r.Method))
+		case component == ComponentAuthority:
+			host := r.Host
+			if host == "" {
+				host = r.URL.Host
+			}
+			value = append(value, strings.ToLower(host))
+		case component == ComponentPath:
+			value = append(value, r.URL.EscapedPath())
+		case component == ComponentQuery:
+			value = append(value, r.URL.Query().Encode())
+		case strings.HasPrefix(component, ComponentQueryParam):
+			value = append(value, r.URL.Query()[strings.TrimPrefix(component, ComponentQueryParam)]...)
+		case component == ComponentBody:
+			digest, err := bodyDigest(r)
+			if err != nil {
+				return nil, err
+			}
+			value = append(value, digest)
+		default:
+			return nil, fmt.Errorf("Unsupported request component %q\n", component)
+		}
+
+		values = append(values, value)
+	}
+
+	valuesJSON, err := json.Marshal(values)
+	if err != nil {
+		return nil, fmt.Errorf("Error generating json: %v\n", err)
+	}
+	hash := hash256.Sum256(valuesJSON)
+	return hash[:], nil
+}
+
+// bodyDigest hashes the request body, restoring it for the handlers.
+func bodyDigest(r *http.Request) (string, error) {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = io.ReadAll(r.Body)
+		if err != nil {
+			return "", fmt.Errorf("Unable to read body: %v\n", err)
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	hash := hash256.Sum256(body)
+	return base64.RawURLEncoding.EncodeToString(hash[:]), nil
+}
@@ -0,0 +1,103 @@
+package lsvid
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestBinding(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+
+	components := []string{ComponentMethod, ComponentAuthority, ComponentPath, ComponentQueryParam + "deposit", ComponentBody}
+	newRequest := func(method, url, body string) *http.Request {
+		return httptest.NewRequest(method, url, strings.NewReader(body))
+	}
+
+	// the middle tier binds its hop to the deposit request it sends
+	sent := newRequest(http.MethodPost, "https://target-wl:8443/deposit?deposit=100", `{"account":"1"}`)
+	binding, err := BindRequest(sent, components...)
+	if err != nil {
+		t.Fatalf("BindRequest: %v", err)
+	}
+	lsvid := ca.newChain(t, ca.newPath(t, "/middle-tier", "/target-wl"), func(hop int, payload *Payload) {
+		payload.Req = binding
+	})
+	checkValidate(t, lsvid.Token, true)
+
+	received := newRequest(http.MethodPost, "https://TARGET-WL:8443/deposit?deposit=100&trace=1", `{"account":"1"}`)
+	if err := VerifyRequestBinding(lsvid.Token, received, components...); err != nil {
+		t.Fatalf("VerifyRequestBinding: %v", err)
+	}
+	if body, _ := io.ReadAll(received.Body); string(body) != `{"account":"1"}` {
+		t.Errorf("body not restored, got %q", body)
+	}
+
+	for name, r := range map[string]*http.Request{
+		"method":          newRequest(http.MethodPut, "https://target-wl:8443/deposit?deposit=100", `{"account":"1"}`),
+		"authority":       newRequest(http.MethodPost, "https://other-wl:8443/deposit?deposit=100", `{"account":"1"}`),
+		"path":            newRequest(http.MethodPost, "https://target-wl:8443/withdraw?deposit=100", `{"account":"1"}`),
+		"query parameter": newRequest(http.MethodPost, "https://target-wl:8443/deposit?deposit=1000", `{"account":"1"}`),
+		"empty parameter": newRequest(http.MethodPost, "https://target-wl:8443/deposit?deposit=", `{"account":"1"}`),
+		"body":            newRequest(http.MethodPost, "https://target-wl:8443/deposit?deposit=100", `{"account":"2"}`),
+	} {
+		if err := VerifyRequestBinding(lsvid.Token, r, components...); err == nil {
+			t.Errorf("request with another %s verified", name)
+		}
+	}
+
+	// a binding not covering the required components, chosen by the sender
+	partial, err := BindRequest(sent, ComponentMethod, ComponentPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partialLSVID := ca.newChain(t, ca.newPath(t, "/middle-tier", "/target-wl"), func(hop int, payload *Payload) {
+		payload.Req = partial
+	})
+	tampered := newRequest(http.MethodPost, "https://target-wl:8443/deposit?deposit=1000", `{"account":"2"}`)
+	if err := VerifyRequestBinding(partialLSVID.Token, tampered, ComponentMethod, ComponentPath); err != nil {
+		t.Errorf("VerifyRequestBinding: %v", err)
+	}
+	if err := VerifyRequestBinding(partialLSVID.Token, tampered, components...); err == nil {
+		t.Error("binding without the required components verified")
+	}
+
+	// the binding is signed by the hop
+	lsvid.Token.Payload.Req.Com = components[:4]
+	checkValidate(t, lsvid.Token, false)
+
+	// an absent query parameter differs from an empty one
+	absent, err := BindRequest(newRequest(http.MethodGet, "https://target-wl:8443/balance", ""), ComponentQueryParam+"account")
+	if err != nil {
+		t.Fatal(err)
+	}
+	empty, err := BindRequest(newRequest(http.MethodGet, "https://target-wl:8443/balance?account=", ""), ComponentQueryParam+"account")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(absent.Dig) == string(empty.Dig) {
+		t.Error("absent and empty query parameters bound to the same digest")
+	}
+}
+
+func TestRequestBindingRejected(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "https://target-wl:8443/balance", nil)
+	if _, err := BindRequest(r); err == nil {
+		t.Error("request bound without components")
+	}
+	if _, err := BindRequest(r, "@scheme"); err == nil {
+		t.Error("unsupported component bound")
+	}
+	if err := VerifyRequestBinding(&Token{Payload: &Payload{}}, r, ComponentPath); err == nil {
+		t.Error("LSVID without binding verified")
+	}
+	binding, err := BindRequest(r, ComponentPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyRequestBinding(&Token{Payload: &Payload{Req: binding}}, r); err == nil {
+		t.Error("binding verified without required components")
+	}
+}
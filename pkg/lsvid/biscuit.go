@@ -0,0 +1,387 @@
+package lsvid
+
+import (
+	"crypto"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Block holds Biscuit-compatible Datalog statements attached to an LSVID hop.
+//
+// The blocks of the root token and of the originator hop, that extends it, form
+// the authority block: its facts and rules are trusted. Blocks of later hops
+// attenuate the LSVID: their facts and rules are only seen by their own checks,
+// while all checks must hold for the request to be authorized. Check Authorizer.
+// A block, including the merged authority block, holds at most 100 facts, rules
+// and checks each.
+type Block struct {
+	Facts  []string `json:"facts,omitempty"`  // e.g.: right("account/1", "read")
+	Rules  []string `json:"rules,omitempty"`  // e.g.: can_read($r) <- right($r, "read")
+	Checks []string `json:"checks,omitempty"` // e.g.: check if operation("read")
+}
+
+// ParseBlock parses a Biscuit block source, with statements terminated by ";",
+// as printed by biscuit-go (Block.Code()) or the Biscuit CLI.
+func ParseBlock(src string) (*Block, error) {
+	b := &Block{}
+	for _, statement := range strings.Split(src, ";") {
+		statement = strings.TrimSpace(statement)
+		switch {
+		case statement == "" || strings.HasPrefix(statement, "//"):
+			continue
+		case strings.HasPrefix(statement, "check "):
+			b.Checks = append(b.Checks, statement)
+		case strings.Contains(statement, "<-"):
+			b.Rules = append(b.Rules, statement)
+		default:
+			b.Facts = append(b.Facts, statement)
+		}
+	}
+
+	if _, err := b.compile(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *Block) empty() bool {
+	return len(b.Facts) == 0 && len(b.Rules) == 0 && len(b.Checks) == 0
+}
+
+// String returns the Biscuit source of the block.
+func (b *Block) String() string {
+	var statements []string
+	for _, group := range [][]string{b.Facts, b.Rules, b.Checks} {
+		for _, statement := range group {
+			statements = append(statements, statement+";")
+		}
+	}
+	return strings.Join(statements, "\n")
+}
+
+type compiledBlock struct {
+	facts  factSet
+	rules  []rule
+	checks []query
+}
+
+func (b *Block) compile() (*compiledBlock, error) {
+	if len(b.Facts) > datalogMaxBlockStatements || len(b.Rules) > datalogMaxBlockStatements || len(b.Checks) > datalogMaxBlockStatements {
+		return nil, fmt.Errorf("Block has more than %d facts, rules or checks\n", datalogMaxBlockStatements)
+	}
+	c := &compiledBlock{facts: make(factSet)}
+	for _, src := range b.Facts {
+		fact, err := parseFact(src)
+		if err != nil {
+			return nil, err
+		}
+		c.facts.add(fact)
+	}
+	for _, src := range b.Rules {
+		r, err := parseRule(src)
+		if err != nil {
+			return nil, err
+		}
+		c.rules = append(c.rules, r)
+	}
+	for _, src := range b.Checks {
+		q, err := parseQuery(src)
+		if err != nil {
+			return nil, err
+		}
+		if q.kind != "check" {
+			return nil, fmt.Errorf("Block statement %q is not a check\n", src)
+		}
+		c.checks = append(c.checks, q)
+	}
+	return c, nil
+}
+
+// BiscuitBlocks returns the blocks of the LSVID hops, from the root to the outer
+// most hop: the first one is the authority block, that is empty if neither the
+// root nor the originator hop carries a block. In joined chains, the authority
+// block is taken from the first parent chain, and the parents are visited in
+// order.
+//
+// The sources can be used to mint an equivalent Biscuit token. The LSVID
+// signatures are not carried: Biscuit chains Ed25519 signatures with ephemeral
+// keys, so the token must be minted (and its root key trusted) at the conversion
+// point, after validating the LSVID.
+func BiscuitBlocks(lsvid *Token) []string {
+	authority, blocks := chainBlocks(lsvid)
+	if authority == nil && len(blocks) == 0 {
+		return nil
+	}
+	if authority == nil {
+		authority = &Block{}
+	}
+	sources := []string{authority.String()}
+	for _, b := range blocks {
+		sources = append(sources, b.String())
+	}
+	return sources
+}
+
+// ExtendWithBiscuitBlocks converts the blocks of a Biscuit token, as returned
+// by BiscuitBlocks, to LSVID hops extending a root LSVID. The first hop is the
+// originator hop and carries the authority block. Each later block is carried
+// by a hop addressed to the root subject itself, and the last one by newPayload,
+// so the attenuation blocks keep their own scope.
+//
+// The Biscuit signatures are not carried: the token must be verified against
+// its root key before converting it, and the key must be the one of the root
+// LSVID subject.
+func ExtendWithBiscuitBlocks(root *LSVID, sources []string, newPayload *Payload, key crypto.Signer) (string, error) {
+	if root.Token.Payload == nil || len(root.Token.Parents()) > 0 {
+		return "", fmt.Errorf("Biscuit blocks can only extend a root LSVID\n")
+	}
+	if len(sources) == 0 {
+		return "", fmt.Errorf("Biscuit token has no authority block\n")
+	}
+	var blocks []*Block
+	for _, src := range sources {
+		b, err := ParseBlock(src)
+		if err != nil {
+			return "", err
+		}
+		blocks = append(blocks, b)
+	}
+
+	lsvid := root
+	for _, b := range blocks[:len(blocks)-1] {
+		payload := &Payload{
+			Ver: newPayload.Ver,
+			Alg: newPayload.Alg,
+			Iat: newPayload.Iat,
+			Iss: newPayload.Iss,
+			Aud: root.Token.Payload.Sub,
+		}
+		if !b.empty() {
+			payload.Blk = b
+		}
+		encLSVID, err := Extend(lsvid, payload, key)
+		if err != nil {
+			return "", err
+		}
+		if lsvid, err = Decode(encLSVID); err != nil {
+			return "", err
+		}
+	}
+	if b := blocks[len(blocks)-1]; !b.empty() {
+		newPayload.Blk = b
+	}
+	return Extend(lsvid, newPayload, key)
+}
+
+// chainBlocks returns the authority block, merging the blocks of the root and
+// of the originator hop of the first parent chain, and the blocks of the other
+// hops from the root to the outer most hop.
+func chainBlocks(lsvid *Token) (*Block, []*Block) {
+	// The root and originator hop of the first parent chain
+	authorities := make(map[*Token]bool)
+	for token := lsvid; ; {
+		parents := token.Parents()
+		if len(parents) == 0 {
+			authorities[token] = true
+			break
+		}
+		if len(parents[0].Parents()) == 0 && token.Nested != nil {
+			authorities[token] = true
+		}
+		token = parents[0]
+	}
+
+	var authority *Block
+	var blocks []*Block
+	visited := make(map[*Token]bool)
+
+	var collect func(token *Token)
+	collect = func(token *Token) {
+		if visited[token] {
+			return
+		}
+		visited[token] = true
+		for _, parent := range token.Parents() {
+			collect(parent)
+		}
+		if token.Payload == nil || token.Payload.Blk == nil {
+			return
+		}
+		b := token.Payload.Blk
+		if !authorities[token] {
+			blocks = append(blocks, b)
+			return
+		}
+		if authority == nil {
+			authority = &Block{}
+		}
+		authority.Facts = append(authority.Facts, b.Facts...)
+		authority.Rules = append(authority.Rules, b.Rules...)
+		authority.Checks = append(authority.Checks, b.Checks...)
+	}
+
+	collect(lsvid)
+	return authority, blocks
+}
+
+// Authorizer evaluates the blocks of an LSVID against the request facts
+// (e.g.: operation, resource and time), its own checks and its policies, as a
+// Biscuit authorizer.
+type Authorizer struct {
+	facts    factSet
+	rules    []rule
+	checks   []query
+	policies []query
+}
+
+// NewAuthorizer creates an empty authorizer.
+func NewAuthorizer() *Authorizer {
+	return &Authorizer{
+		facts: make(factSet),
+	}
+}
+
+// AddFact adds a request fact, e.g.: operation("deposit").
+func (a *Authorizer) AddFact(src string) error {
+	fact, err := parseFact(src)
+	if err != nil {
+		return err
+	}
+	a.facts.add(fact)
+	return nil
+}
+
+// AddTime adds the time(t) fact.
+func (a *Authorizer) AddTime(t time.Time) {
+	a.facts.add(predicate{name: "time", terms: []interface{}{date(t.Unix())}})
+}
+
+// AddRule adds a rule, e.g.: is_owner($a) <- user($u), owner($u, $a).
+func (a *Authorizer) AddRule(src string) error {
+	r, err := parseRule(src)
+	if err != nil {
+		return err
+	}
+	a.rules = append(a.rules, r)
+	return nil
+}
+
+// AddCheck adds a check, e.g.: check if time($t), $t < 2030-01-01T00:00:00Z.
+func (a *Authorizer) AddCheck(src string) error {
+	q, err := parseQuery(src)
+	if err != nil {
+		return err
+	}
+	if q.kind != "check" {
+		return fmt.Errorf("%q is not a check\n", src)
+	}
+	a.checks = append(a.checks, q)
+	return nil
+}
+
+// AddPolicy adds an allow or deny policy, e.g.: allow if resource($r), right($r, "read").
+// Policies are evaluated in order and the first matching one decides.
+func (a *Authorizer) AddPolicy(src string) error {
+	q, err := parseQuery(src)
+	if err != nil {
+		return err
+	}
+	if q.kind != "allow" && q.kind != "deny" {
+		return fmt.Errorf("%q is not a policy\n", src)
+	}
+	a.policies = append(a.policies, q)
+	return nil
+}
+
+// Authorize evaluates the LSVID blocks. It does not validate the LSVID: use
+// Validate with WithAuthorizer.
+//
+// The authorizer facts and rules, and the authority block of the root and
+// originator hop, are trusted. The checks of each later block see the trusted
+// facts plus the facts of their own block, so a later hop can not grant rights.
+// All checks must hold and the first matching policy must be an allow.
+// The whole evaluation is bounded in facts, fixpoint iterations and unifications,
+// and fails once a bound is exceeded.
+func (a *Authorizer) Authorize(lsvid *Token) error {
+	authorityBlock, chain := chainBlocks(lsvid)
+	var blocks []*compiledBlock
+	for _, b := range chain {
+		c, err := b.compile()
+		if err != nil {
+			return err
+		}
+		blocks = append(blocks, c)
+	}
+
+	trustedFacts := a.facts.clone()
+	trustedRules := append([]rule(nil), a.rules...)
+	var authority *compiledBlock
+	if authorityBlock != nil {
+		var err error
+		if authority, err = authorityBlock.compile(); err != nil {
+			return err
+		}
+		for _, fact := range authority.facts {
+			trustedFacts.add(fact)
+		}
+		trustedRules = append(trustedRules, authority.rules...)
+	}
+	budget := newDatalogBudget()
+	trusted, err := fixpoint(trustedFacts, trustedRules, budget)
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+	checks := append([]query(nil), a.checks...)
+	if authority != nil {
+		checks = append(checks, authority.checks...)
+	}
+	for _, check := range checks {
+		ok, err := check.matches(trusted, budget)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			failed = append(failed, check.source)
+		}
+	}
+	for _, b := range blocks {
+		facts := trusted.clone()
+		for _, fact := range b.facts {
+			facts.add(fact)
+		}
+		facts, err := fixpoint(facts, b.rules, budget)
+		if err != nil {
+			return err
+		}
+		for _, check := range b.checks {
+			ok, err := check.matches(facts, budget)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				failed = append(failed, check.source)
+			}
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("Authorization checks failed: %s\n", strings.Join(failed, "; "))
+	}
+
+	for _, policy := range a.policies {
+		ok, err := policy.matches(trusted, budget)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if policy.kind == "deny" {
+			return fmt.Errorf("Denied by policy: %s\n", policy.source)
+		}
+		return nil
+	}
+	return fmt.Errorf("No policy matched\n")
+}
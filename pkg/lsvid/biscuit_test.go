@@ -0,0 +1,175 @@
+package lsvid
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+)
+
+func newTestAuthorizer(t *testing.T, resource, operation string) *Authorizer {
+	a := NewAuthorizer()
+	for _, fact := range []string{`resource("` + resource + `")`, `operation("` + operation + `")`} {
+		if err := a.AddFact(fact); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.AddPolicy(`allow if resource($r), operation($op), right($r, $op)`); err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestAuthorize(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	asserting := ca.newSVID(t, "/asserting-wl")
+	middle := ca.newSVID(t, "/middle-tier")
+	target := ca.newSVID(t, "/target-wl")
+
+	lsvid := ca.newChain(t, []*x509svid.SVID{asserting, middle, target}, func(hop int, payload *Payload) {
+		switch hop {
+		case 0:
+			payload.Blk = &Block{Facts: []string{`right("account/1", "read")`, `right("account/1", "write")`}}
+		case 1:
+			payload.Blk = &Block{Checks: []string{`check if operation("read")`}}
+		}
+	})
+	if valid, err := Validate(lsvid.Token, WithAuthorizer(newTestAuthorizer(t, "account/1", "read"))); !valid {
+		t.Fatalf("LSVID rejected: %v", err)
+	}
+	for _, denied := range []struct{ resource, operation string }{
+		{"account/1", "write"}, // attenuated by the middle tier
+		{"account/2", "read"},  // not granted
+	} {
+		if valid, _ := Validate(lsvid.Token, WithAuthorizer(newTestAuthorizer(t, denied.resource, denied.operation))); valid {
+			t.Errorf("LSVID authorized to %s %s", denied.operation, denied.resource)
+		}
+	}
+
+	// the blocks are signed by their hop
+	lsvid.Token.Nested.Payload.Blk = nil
+	if valid, _ := Validate(lsvid.Token, WithAuthorizer(newTestAuthorizer(t, "account/1", "write"))); valid {
+		t.Error("LSVID with dropped block accepted")
+	}
+}
+
+// A later hop can not make the verifier evaluate unbounded blocks.
+func TestAuthorizeLimits(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	path := ca.newPath(t, "/asserting-wl", "/middle-tier", "/target-wl")
+
+	var facts []string
+	for i := 0; i <= datalogMaxBlockStatements; i++ {
+		facts = append(facts, fmt.Sprintf("n(%d)", i))
+	}
+	if _, err := ParseBlock(strings.Join(facts, ";")); err == nil {
+		t.Errorf("block with more than %d facts parsed", datalogMaxBlockStatements)
+	}
+
+	for name, block := range map[string]*Block{
+		"facts":    {Facts: facts},
+		"bindings": {Facts: facts[:40], Checks: []string{`check if n($a), n($b), n($c), n($d), $d == -1`}},
+	} {
+		lsvid := ca.newChain(t, path, func(hop int, payload *Payload) {
+			if hop == 1 {
+				payload.Blk = block
+			}
+		})
+		// the authorizer denies anyway: the evaluation must fail before
+		err := newTestAuthorizer(t, "account/1", "read").Authorize(lsvid.Token)
+		if err == nil || strings.Contains(err.Error(), "No policy matched") {
+			t.Errorf("%s: got error %v, want a limit error", name, err)
+		}
+	}
+}
+
+func TestAuthorizeLaterHopCannotGrant(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	asserting := ca.newSVID(t, "/asserting-wl")
+	middle := ca.newSVID(t, "/middle-tier")
+	target := ca.newSVID(t, "/target-wl")
+	grant := &Block{Facts: []string{`right("account/1", "write")`}, Rules: []string{`right($r, "write") <- right($r, "read")`}}
+
+	for name, edit := range map[string]func(int, *Payload){
+		"without authority block": func(hop int, payload *Payload) {
+			if hop == 1 {
+				payload.Blk = grant
+			}
+		},
+		"with authority block": func(hop int, payload *Payload) {
+			switch hop {
+			case 0:
+				payload.Blk = &Block{Facts: []string{`right("account/1", "read")`}}
+			case 1:
+				payload.Blk = grant
+			}
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			lsvid := ca.newChain(t, []*x509svid.SVID{asserting, middle, target}, edit)
+			if valid, _ := Validate(lsvid.Token, WithAuthorizer(newTestAuthorizer(t, "account/1", "write"))); valid {
+				t.Error("right granted by the middle tier")
+			}
+			if authority := BiscuitBlocks(lsvid.Token)[0]; authority == grant.String() {
+				t.Error("middle tier block converted as the authority block")
+			}
+		})
+	}
+}
+
+func TestBiscuitConversion(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	asserting := ca.newSVID(t, "/asserting-wl")
+	middle := ca.newSVID(t, "/middle-tier")
+	target := ca.newSVID(t, "/target-wl")
+
+	lsvid := ca.newChain(t, []*x509svid.SVID{asserting, middle, target}, func(hop int, payload *Payload) {
+		switch hop {
+		case 0:
+			payload.Blk = &Block{Facts: []string{`right("account/1", "read")`}}
+		case 1:
+			payload.Blk = &Block{
+				Rules:  []string{`is_read($op) <- operation($op), $op == "read"`},
+				Checks: []string{`check if operation($op), is_read($op)`},
+			}
+		}
+	})
+	sources := BiscuitBlocks(lsvid.Token)
+	if len(sources) != 2 {
+		t.Fatalf("got %d blocks, want 2", len(sources))
+	}
+	for _, src := range sources {
+		if _, err := ParseBlock(src); err != nil {
+			t.Errorf("ParseBlock(%q): %v", src, err)
+		}
+	}
+
+	// a Biscuit token verified at the middle tier is converted back to an LSVID
+	payload := ca.newPayload(t, middle, target.ID.String())
+	encLSVID, err := ExtendWithBiscuitBlocks(&LSVID{Token: ca.newLSVID(t, middle)}, sources, payload, middle.PrivateKey)
+	if err != nil {
+		t.Fatalf("ExtendWithBiscuitBlocks: %v", err)
+	}
+	converted, err := Decode(encLSVID)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if !reflect.DeepEqual(BiscuitBlocks(converted.Token), sources) {
+		t.Errorf("got blocks %q, want %q", BiscuitBlocks(converted.Token), sources)
+	}
+	if valid, err := Validate(converted.Token, WithAuthorizer(newTestAuthorizer(t, "account/1", "read"))); !valid {
+		t.Fatalf("converted LSVID rejected: %v", err)
+	}
+	if valid, _ := Validate(converted.Token, WithAuthorizer(newTestAuthorizer(t, "account/1", "write"))); valid {
+		t.Error("converted LSVID lost the attenuation block")
+	}
+
+	if _, err := ExtendWithBiscuitBlocks(lsvid, sources, payload, middle.PrivateKey); err == nil {
+		t.Error("Biscuit blocks extended a delegated LSVID")
+	}
+	if _, err := ExtendWithBiscuitBlocks(&LSVID{Token: ca.newLSVID(t, middle)}, []string{`right(`}, payload, middle.PrivateKey); err == nil {
+		t.Error("invalid Biscuit block converted")
+	}
+}
@@ -0,0 +1,269 @@
+package lsvid
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	hash256 "crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/bundle/spiffebundle"
+	"github.com/spiffe/go-spiffe/v2/bundle/x509bundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+)
+
+// TrustBundle is the verifier side of a bundle LSVID: the set of authority
+// keys of a trust domain, used to validate root LSVIDs.
+type TrustBundle struct {
+	trustDomain    spiffeid.TrustDomain
+	sequenceNumber uint64
+	refreshHint    time.Duration
+	authorities    []crypto.PublicKey
+}
+
+// BundleSource represents a source of trust bundles keyed by trust domain.
+type BundleSource interface {
+	// GetTrustBundleForTrustDomain returns the trust bundle for the given trust domain.
+	GetTrustBundleForTrustDomain(trustDomain spiffeid.TrustDomain) (*TrustBundle, error)
+}
+
+// NewBundle creates a bundle LSVID for the trust domain authorities and signs it with key.
+//
+// The bundle is signed as a root LSVID, with Iss and Sub set to the trust domain,
+// and key public part MUST be one of the authorities.
+func NewBundle(td spiffeid.TrustDomain, authorities []crypto.PublicKey, sequenceNumber uint64, refreshHint time.Duration, key crypto.Signer) (*Token, error) {
+	if td.IsZero() {
+		return nil, fmt.Errorf("Trust domain is required\n")
+	}
+	if !containsKey(authorities, key.Public()) {
+		return nil, fmt.Errorf("Signing key is not a bundle authority\n")
+	}
+
+	aut := make([][]byte, 0, len(authorities))
+	for _, authority := range authorities {
+		pk, err := x509.MarshalPKIXPublicKey(authority)
+		if err != nil {
+			return nil, fmt.Errorf("Error encoding authority key: %v\n", err)
+		}
+		aut = append(aut, pk)
+	}
+	issPK, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, fmt.Errorf("Error encoding issuer public key: %v\n", err)
+	}
+	alg, err := keyAlgorithm(key.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	payload := &Payload{
+		Ver: 1,
+		Alg: alg,
+		Iat: time.Now().Round(0).Unix(),
+		Iss: &IDClaim{
+			CN: td.IDString(),
+			PK: issPK,
+		},
+		Sub: &IDClaim{
+			CN: td.IDString(),
+		},
+		Seq: sequenceNumber,
+		Rfh: int64(refreshHint / time.Second),
+		Aut: aut,
+	}
+
+	return signRoot(payload, key)
+}
+
+// BundleFromX509Bundle creates a bundle LSVID with the X.509 authorities of an x509bundle.Bundle.
+func BundleFromX509Bundle(bundle *x509bundle.Bundle, sequenceNumber uint64, refreshHint time.Duration, key crypto.Signer) (*Token, error) {
+	var authorities []crypto.PublicKey
+	for _, cert := range bundle.X509Authorities() {
+		authorities = append(authorities, cert.PublicKey)
+	}
+
+	return NewBundle(bundle.TrustDomain(), authorities, sequenceNumber, refreshHint, key)
+}
+
+// BundleFromSPIFFEBundle creates a bundle LSVID with the X.509 and JWT authorities,
+// sequence number and refresh hint of a spiffebundle.Bundle.
+func BundleFromSPIFFEBundle(bundle *spiffebundle.Bundle, key crypto.Signer) (*Token, error) {
+	tb := TrustBundleFromSPIFFEBundle(bundle)
+
+	return NewBundle(tb.trustDomain, tb.authorities, tb.sequenceNumber, tb.refreshHint, key)
+}
+
+// TrustBundleFromSPIFFEBundle creates a trust bundle holding the X.509 and JWT
+// authorities of a SPIFFE bundle, obtained from a trusted source
+// (e.g.: the Workload API or a federation endpoint).
+func TrustBundleFromSPIFFEBundle(bundle *spiffebundle.Bundle) *TrustBundle {
+	var authorities []crypto.PublicKey
+	for _, cert := range bundle.X509Authorities() {
+		authorities = append(authorities, cert.PublicKey)
+	}
+	for _, authority := range bundle.JWTAuthorities() {
+		if !containsKey(authorities, authority) {
+			authorities = append(authorities, authority)
+		}
+	}
+	sequenceNumber, _ := bundle.SequenceNumber()
+	refreshHint, _ := bundle.RefreshHint()
+
+	return &TrustBundle{
+		trustDomain:    bundle.TrustDomain(),
+		sequenceNumber: sequenceNumber,
+		refreshHint:    refreshHint,
+		authorities:    authorities,
+	}
+}
+
+// ParseBundle verifies a bundle LSVID and returns its verifier key set.
+//
+// The bundle must be signed by one of its own authorities. It does not establish
+// trust in the bundle: it must be obtained from a trusted source or rotated
+// from a trusted bundle using Update.
+func ParseBundle(bundle *Token) (*TrustBundle, error) {
+	if bundle == nil || bundle.Payload == nil || bundle.Payload.Iss == nil {
+		return nil, fmt.Errorf("Invalid bundle LSVID\n")
+	}
+	if bundle.Nested != nil {
+		return nil, fmt.Errorf("Bundle LSVID can not be extended\n")
+	}
+	payload := bundle.Payload
+
+	td, err := spiffeid.TrustDomainFromString(payload.Iss.CN)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid bundle trust domain: %v\n", err)
+	}
+	if payload.Sub == nil || payload.Sub.CN != payload.Iss.CN {
+		return nil, fmt.Errorf("Bundle subject must be the trust domain %s\n", payload.Iss.CN)
+	}
+
+	var authorities []crypto.PublicKey
+	for _, aut := range payload.Aut {
+		pk, err := x509.ParsePKIXPublicKey(aut)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse authority key: %v\n", err)
+		}
+		authorities = append(authorities, pk)
+	}
+
+	issPK, err := x509.ParsePKIXPublicKey(payload.Iss.PK)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse public key: %v\n", err)
+	}
+	if !containsKey(authorities, issPK) {
+		return nil, fmt.Errorf("Bundle not signed by one of its authorities\n")
+	}
+
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling LSVID to JSON: %v\n", err)
+	}
+	hash := hash256.Sum256(payloadJSON)
+	if !verifyDigest(issPK, hash[:], bundle.Signature) {
+		return nil, fmt.Errorf("Bundle signature validation failed\n")
+	}
+
+	return &TrustBundle{
+		trustDomain:    td,
+		sequenceNumber: payload.Seq,
+		refreshHint:    time.Duration(payload.Rfh) * time.Second,
+		authorities:    authorities,
+	}, nil
+}
+
+// Update rotates the trust bundle to the next bundle LSVID.
+//
+// The next bundle must belong to the same trust domain, have a greater
+// sequence number and be signed by an authority of the current bundle.
+// Authorities removed by the next bundle are no longer accepted when validating.
+func (b *TrustBundle) Update(next *Token) (*TrustBundle, error) {
+	nextBundle, err := ParseBundle(next)
+	if err != nil {
+		return nil, err
+	}
+	if nextBundle.trustDomain != b.trustDomain {
+		return nil, fmt.Errorf("Bundle trust domain mismatch: %s != %s\n", nextBundle.trustDomain, b.trustDomain)
+	}
+	if nextBundle.sequenceNumber <= b.sequenceNumber {
+		return nil, fmt.Errorf("Bundle sequence number %d is not greater than %d\n", nextBundle.sequenceNumber, b.sequenceNumber)
+	}
+
+	issPK, err := x509.ParsePKIXPublicKey(next.Payload.Iss.PK)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse public key: %v\n", err)
+	}
+	if !b.HasAuthority(issPK) {
+		return nil, fmt.Errorf("Next bundle not signed by a current authority\n")
+	}
+
+	return nextBundle, nil
+}
+
+// TrustDomain returns the trust domain of the bundle.
+func (b *TrustBundle) TrustDomain() spiffeid.TrustDomain {
+	return b.trustDomain
+}
+
+// SequenceNumber returns the bundle sequence number.
+func (b *TrustBundle) SequenceNumber() uint64 {
+	return b.sequenceNumber
+}
+
+// RefreshHint returns the bundle refresh hint.
+func (b *TrustBundle) RefreshHint() time.Duration {
+	return b.refreshHint
+}
+
+// Authorities returns the bundle authority keys.
+func (b *TrustBundle) Authorities() []crypto.PublicKey {
+	return append([]crypto.PublicKey(nil), b.authorities...)
+}
+
+// HasAuthority checks if the given key is one of the bundle authorities.
+func (b *TrustBundle) HasAuthority(key crypto.PublicKey) bool {
+	return containsKey(b.authorities, key)
+}
+
+// GetTrustBundleForTrustDomain implements BundleSource.
+func (b *TrustBundle) GetTrustBundleForTrustDomain(trustDomain spiffeid.TrustDomain) (*TrustBundle, error) {
+	if b.trustDomain != trustDomain {
+		return nil, fmt.Errorf("No trust bundle for trust domain %q\n", trustDomain)
+	}
+	return b, nil
+}
+
+// keyAlgorithm returns the JWS algorithm name of the signatures created by the
+// private part of key, as verified by verifyDigest.
+func keyAlgorithm(key crypto.PublicKey) (string, error) {
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return "ES256", nil
+		case elliptic.P384():
+			return "ES384", nil
+		case elliptic.P521():
+			return "ES512", nil
+		}
+		return "", fmt.Errorf("Unsupported curve %s\n", key.Curve.Params().Name)
+	case *rsa.PublicKey:
+		return "RS256", nil
+	default:
+		return "", fmt.Errorf("Unsupported key type %T\n", key)
+	}
+}
+
+func containsKey(keys []crypto.PublicKey, key crypto.PublicKey) bool {
+	for _, k := range keys {
+		if eq, ok := k.(interface{ Equal(crypto.PublicKey) bool }); ok && eq.Equal(key) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,193 @@
+package lsvid
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+)
+
+func TestNewBundle(t *testing.T) {
+	td := spiffeid.RequireTrustDomainFromString("example.org")
+	for _, tt := range []struct {
+		name string
+		key  func() (crypto.Signer, error)
+		alg  string
+	}{
+		{"P-256", func() (crypto.Signer, error) { return ecdsa.GenerateKey(elliptic.P256(), rand.Reader) }, "ES256"},
+		{"P-384", func() (crypto.Signer, error) { return ecdsa.GenerateKey(elliptic.P384(), rand.Reader) }, "ES384"},
+		{"RSA", func() (crypto.Signer, error) { return rsa.GenerateKey(rand.Reader, 2048) }, "RS256"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := tt.key()
+			if err != nil {
+				t.Fatal(err)
+			}
+			bundle, err := NewBundle(td, []crypto.PublicKey{key.Public()}, 3, time.Minute, key)
+			if err != nil {
+				t.Fatalf("NewBundle: %v", err)
+			}
+			if bundle.Payload.Alg != tt.alg {
+				t.Errorf("got alg %s, want %s", bundle.Payload.Alg, tt.alg)
+			}
+
+			trustBundle, err := ParseBundle(bundle)
+			if err != nil {
+				t.Fatalf("ParseBundle: %v", err)
+			}
+			if trustBundle.TrustDomain() != td || trustBundle.SequenceNumber() != 3 || trustBundle.RefreshHint() != time.Minute {
+				t.Errorf("unexpected bundle %s #%d (%s)", trustBundle.TrustDomain(), trustBundle.SequenceNumber(), trustBundle.RefreshHint())
+			}
+			if !trustBundle.HasAuthority(key.Public()) {
+				t.Error("signing key is not an authority")
+			}
+
+			// tampered bundles are rejected
+			bundle.Payload.Seq++
+			if _, err := ParseBundle(bundle); err == nil {
+				t.Error("tampered bundle accepted")
+			}
+		})
+	}
+}
+
+func TestNewBundleRejected(t *testing.T) {
+	td := spiffeid.RequireTrustDomainFromString("example.org")
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewBundle(td, []crypto.PublicKey{other.Public()}, 1, 0, key); err == nil {
+		t.Error("bundle signed by a key that is not an authority")
+	}
+	if _, err := NewBundle(spiffeid.TrustDomain{}, []crypto.PublicKey{key.Public()}, 1, 0, key); err == nil {
+		t.Error("bundle created without trust domain")
+	}
+
+	// the bundle subject must be its trust domain
+	bundle, err := NewBundle(td, []crypto.PublicKey{key.Public()}, 1, 0, key)
+	if err != nil {
+		t.Fatalf("NewBundle: %v", err)
+	}
+	bundle.Payload.Sub.CN = "spiffe://example.org/workload"
+	resigned, err := signRoot(bundle.Payload, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseBundle(resigned); err == nil {
+		t.Error("bundle issued to a workload accepted")
+	}
+}
+
+func TestTrustBundleUpdate(t *testing.T) {
+	td := spiffeid.RequireTrustDomainFromString("example.org")
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bundle, err := NewBundle(td, []crypto.PublicKey{key.Public()}, 1, 0, key)
+	if err != nil {
+		t.Fatalf("NewBundle: %v", err)
+	}
+	current, err := ParseBundle(bundle)
+	if err != nil {
+		t.Fatalf("ParseBundle: %v", err)
+	}
+
+	// rotation signed by the current authority, dropping it
+	if _, err := NewBundle(td, []crypto.PublicKey{next.Public()}, 2, 0, key); err == nil {
+		t.Fatal("bundle signed by a removed authority")
+	}
+	rotated, err := NewBundle(td, []crypto.PublicKey{key.Public(), next.Public()}, 2, 0, key)
+	if err != nil {
+		t.Fatalf("NewBundle: %v", err)
+	}
+	updated, err := current.Update(rotated)
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if !updated.HasAuthority(next.Public()) {
+		t.Error("next key is not an authority after update")
+	}
+
+	// the sequence number must increase
+	if _, err := updated.Update(rotated); err == nil {
+		t.Error("bundle with the same sequence number accepted")
+	}
+
+	// the next bundle must be signed by a current authority
+	unknown, err := NewBundle(td, []crypto.PublicKey{next.Public()}, 2, 0, next)
+	if err != nil {
+		t.Fatalf("NewBundle: %v", err)
+	}
+	if _, err := current.Update(unknown); err == nil {
+		t.Error("bundle signed by an unknown authority accepted")
+	}
+
+	// and belong to the same trust domain
+	foreign, err := NewBundle(spiffeid.RequireTrustDomainFromString("other.org"), []crypto.PublicKey{key.Public()}, 2, 0, key)
+	if err != nil {
+		t.Fatalf("NewBundle: %v", err)
+	}
+	if _, err := current.Update(foreign); err == nil {
+		t.Error("bundle of another trust domain accepted")
+	}
+}
+
+func TestValidateWithBundle(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	asserting := ca.newSVID(t, "/asserting-wl")
+	middle := ca.newSVID(t, "/middle-tier")
+
+	bundle, err := BundleFromX509Bundle(ca.bundle(), 1, 0, ca.key)
+	if err != nil {
+		t.Fatalf("BundleFromX509Bundle: %v", err)
+	}
+	trustBundle, err := ParseBundle(bundle)
+	if err != nil {
+		t.Fatalf("ParseBundle: %v", err)
+	}
+
+	root := &LSVID{Token: ca.newLSVID(t, asserting)}
+	lsvid := extend(t, root, ca.newPayload(t, asserting, middle.ID.String()), asserting)
+	if valid, err := Validate(lsvid.Token, WithBundleSource(trustBundle)); !valid {
+		t.Fatalf("LSVID rejected: %v", err)
+	}
+
+	// roots signed by another authority are rejected with the bundle
+	other := newTestCA(t, "example.org")
+	forged := &LSVID{Token: other.newLSVID(t, asserting)}
+	lsvid = extend(t, forged, other.newPayload(t, asserting, middle.ID.String()), asserting)
+	if valid, _ := Validate(lsvid.Token); !valid {
+		t.Fatal("self-contained LSVID rejected without bundle")
+	}
+	if valid, _ := Validate(lsvid.Token, WithBundleSource(trustBundle)); valid {
+		t.Error("LSVID of another authority accepted")
+	}
+
+	// the issuer LSVID must be issued to the hop issuer
+	payload := ca.newPayload(t, asserting, middle.ID.String())
+	payload.Iss.ID = ca.newLSVID(t, middle)
+	lsvid = extend(t, root, payload, middle)
+	if valid, _ := Validate(lsvid.Token); valid {
+		t.Error("hop signed with the LSVID of another workload accepted")
+	}
+	if valid, _ := Validate(lsvid.Token, WithBundleSource(trustBundle)); valid {
+		t.Error("hop signed with the LSVID of another workload accepted with bundle")
+	}
+}
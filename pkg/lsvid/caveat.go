@@ -0,0 +1,374 @@
+package lsvid
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	hash256 "crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+)
+
+// ThirdPartyCaveat makes a hop valid only if a third party (e.g.: a fraud-check
+// service) approves it, as in macaroons. The third party issues a discharge LSVID
+// (see Discharge) that must be presented along with the LSVID (LSVID.Discharges).
+type ThirdPartyCaveat struct {
+	Loc string           `json:"loc"` // third party SPIFFE ID
+	Cid *EncryptedClaims `json:"cid"` // caveat ID, encrypted to the third party
+}
+
+// DischargeBinding binds a discharge to a caveat and to the hop that carries it.
+type DischargeBinding struct {
+	Cav []byte `json:"cav"` // caveat digest
+	Hop []byte `json:"hop"` // digest of the hop carrying the caveat
+	Exp int64  `json:"exp"` // expiration, in Unix seconds
+}
+
+// DischargeTTL is the lifetime of the discharges issued by a DischargeService.
+const DischargeTTL = 5 * time.Minute
+
+type caveatID struct {
+	Cnd string `json:"cnd"` // condition to be checked by the third party
+	Nce []byte `json:"nce"`
+}
+
+// NewThirdPartyCaveat creates a caveat for the third party at location, with a
+// condition only readable by it (encrypted to its key, e.g. from its X509-SVID).
+func NewThirdPartyCaveat(location string, locationKey crypto.PublicKey, condition string) (*ThirdPartyCaveat, error) {
+	cid := &caveatID{
+		Cnd: condition,
+		Nce: make([]byte, 16),
+	}
+	if _, err := rand.Read(cid.Nce); err != nil {
+		return nil, fmt.Errorf("Error generating nonce: %v\n", err)
+	}
+
+	enc, err := EncryptClaims(cid, location, locationKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ThirdPartyCaveat{
+		Loc: location,
+		Cid: enc,
+	}, nil
+}
+
+// Condition decrypts the caveat condition with the third party X509-SVID.
+func (c *ThirdPartyCaveat) Condition(svid *x509svid.SVID) (string, error) {
+	var cid caveatID
+	if err := DecryptClaims(c.Cid, svid, &cid); err != nil {
+		return "", err
+	}
+	return cid.Cnd, nil
+}
+
+func (c *ThirdPartyCaveat) digest() ([]byte, error) {
+	caveatJSON, err := json.Marshal(c)
+	if err != nil {
+		return nil, fmt.Errorf("Error generating json: %v\n", err)
+	}
+	hash := hash256.Sum256(caveatJSON)
+	return hash[:], nil
+}
+
+// Discharge issues a discharge LSVID for a caveat carried by a hop of the chain,
+// valid for ttl. It must be called by the third party, with its own identity
+// claim (CN and LSVID) and key, once the caveat condition is satisfied.
+func Discharge(chain *Token, caveat *ThirdPartyCaveat, issuer *IDClaim, key crypto.Signer, ttl time.Duration) (*Token, error) {
+	if issuer == nil || issuer.CN != caveat.Loc {
+		return nil, fmt.Errorf("Caveat must be discharged by %s\n", caveat.Loc)
+	}
+
+	hop, err := findCaveatHop(chain, caveat)
+	if err != nil {
+		return nil, err
+	}
+	cav, err := caveat.digest()
+	if err != nil {
+		return nil, err
+	}
+	hopDigest, err := tokenDigest(hop)
+	if err != nil {
+		return nil, err
+	}
+
+	alg, err := keyAlgorithm(key.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Round(0)
+	payload := &Payload{
+		Ver: 1,
+		Alg: alg,
+		Iat: now.Unix(),
+		Iss: issuer,
+		Dch: &DischargeBinding{
+			Cav: cav,
+			Hop: hopDigest,
+			Exp: now.Add(ttl).Unix(),
+		},
+	}
+	return signRoot(payload, key)
+}
+
+// findCaveatHop returns the hop carrying the caveat.
+func findCaveatHop(chain *Token, caveat *ThirdPartyCaveat) (*Token, error) {
+	cav, err := caveat.digest()
+	if err != nil {
+		return nil, err
+	}
+
+	var hop *Token
+	Walk(chain, func(token *Token, _ int) error {
+		if hop != nil || token.Payload == nil {
+			return nil
+		}
+		for _, c := range token.Payload.Tpc {
+			if d, err := c.digest(); err == nil && bytes.Equal(d, cav) {
+				hop = token
+				return nil
+			}
+		}
+		return nil
+	})
+	if hop == nil {
+		return nil, fmt.Errorf("Caveat not found in LSVID\n")
+	}
+	return hop, nil
+}
+
+// checkCaveats requires a valid discharge for every third party caveat of the chain.
+func checkCaveats(chain *Token, discharges []*Token, config *validateConfig) error {
+	return Walk(chain, func(token *Token, _ int) error {
+		if token.Payload == nil || len(token.Payload.Tpc) == 0 {
+			return nil
+		}
+		hopDigest, err := tokenDigest(token)
+		if err != nil {
+			return err
+		}
+
+		for _, caveat := range token.Payload.Tpc {
+			cav, err := caveat.digest()
+			if err != nil {
+				return err
+			}
+
+			discharged := false
+			for _, discharge := range discharges {
+				if discharge.Payload == nil || discharge.Payload.Dch == nil {
+					continue
+				}
+				dch := discharge.Payload.Dch
+				if !bytes.Equal(dch.Cav, cav) || !bytes.Equal(dch.Hop, hopDigest) {
+					continue
+				}
+				if err := verifyDischarge(discharge, caveat, config); err != nil {
+					return err
+				}
+				discharged = true
+				break
+			}
+			if !discharged {
+				return fmt.Errorf("No discharge for caveat of %s\n", caveat.Loc)
+			}
+			log.Printf("Caveat discharged by %s\n", caveat.Loc)
+		}
+		return nil
+	})
+}
+
+// verifyDischarge checks that the discharge was signed by the caveat third party
+// and has not expired.
+func verifyDischarge(discharge *Token, caveat *ThirdPartyCaveat, config *validateConfig) error {
+	if discharge.Nested != nil || len(discharge.Joined) > 0 {
+		return fmt.Errorf("Discharge LSVID can not be extended\n")
+	}
+	if time.Now().Unix() > discharge.Payload.Dch.Exp {
+		return fmt.Errorf("Discharge of %s expired\n", caveat.Loc)
+	}
+	return verifyIssuedToken(discharge, caveat.Loc, config)
+}
+
+// verifyIssuedToken checks that a root token was signed by the given workload,
+// with the key of its issuer LSVID (Iss.ID). The issuer LSVID is validated with
+// the bundle source: without one, anyone could sign with a self-made issuer
+// LSVID, so the token is rejected.
+func verifyIssuedToken(token *Token, issuer string, config *validateConfig) error {
+	payload := token.Payload
+	if payload.Iss == nil || payload.Iss.CN != issuer {
+		return fmt.Errorf("Token not issued by %s\n", issuer)
+	}
+	if payload.Iss.ID == nil {
+		return fmt.Errorf("Issuer LSVID not found for %s\n", issuer)
+	}
+	issLSVID := innermost(payload.Iss.ID)
+	if issLSVID.Payload == nil || issLSVID.Payload.Sub == nil || issLSVID.Payload.Sub.CN != issuer {
+		return fmt.Errorf("Issuer LSVID subject does not match %s\n", issuer)
+	}
+	if config.bundleSource == nil {
+		return fmt.Errorf("Issuer LSVID of %s can not be validated without a bundle source\n", issuer)
+	}
+	valid, err := validate(payload.Iss.ID, &validateConfig{
+		bundleSource:    config.bundleSource,
+		jwtBundleSource: config.jwtBundleSource,
+		keyResolver:     config.keyResolver,
+		verifyCache:     config.verifyCache,
+		verifyScope:     config.verifyScope,
+	})
+	if err != nil {
+		return fmt.Errorf("Error validating issuer LSVID: %v\n", err)
+	}
+	if !valid {
+		return fmt.Errorf("Issuer LSVID of %s validation failed\n", issuer)
+	}
+
+	pk, err := claimKey(issLSVID.Payload.Sub, config.keyResolver)
+	if err != nil {
+		return err
+	}
+	hash, err := payloadCommitment(payload)
+	if err != nil {
+		return err
+	}
+	if !verifyDigest(pk, hash, token.Signature) {
+		return fmt.Errorf("Signature validation failed\n")
+	}
+	return nil
+}
+
+// DischargeService is the HTTP endpoint of a third party, issuing discharges
+// for the caveats addressed to it.
+//
+//	POST / with an encoded LSVID	returns the JSON list of discharge tokens
+type DischargeService struct {
+	svid   *x509svid.SVID
+	issuer *IDClaim
+	opts   []ValidateOption
+
+	// approve checks the caveat condition for the given chain.
+	approve func(condition string, chain *Token) error
+}
+
+// NewDischargeService creates a discharge service for the third party X509-SVID and
+// its LSVID. The chain is validated with opts (except for its caveats) before approve is called.
+func NewDischargeService(svid *x509svid.SVID, lsvid *Token, approve func(condition string, chain *Token) error, opts ...ValidateOption) *DischargeService {
+	return &DischargeService{
+		svid: svid,
+		issuer: &IDClaim{
+			CN: svid.ID.String(),
+			ID: lsvid,
+		},
+		opts:    opts,
+		approve: approve,
+	}
+}
+
+func (s *DischargeService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	lsvid, err := Decode(strings.TrimSpace(string(body)))
+	if err != nil || lsvid.Token == nil {
+		http.Error(w, "Invalid LSVID", http.StatusBadRequest)
+		return
+	}
+
+	config := &validateConfig{}
+	for _, opt := range s.opts {
+		opt.apply(config)
+	}
+	valid, err := validate(lsvid.Token, config)
+	if err != nil || !valid {
+		http.Error(w, "LSVID validation failed", http.StatusForbidden)
+		return
+	}
+
+	discharges, err := s.discharge(lsvid.Token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	json.NewEncoder(w).Encode(discharges)
+}
+
+func (s *DischargeService) discharge(chain *Token) ([]*Token, error) {
+	var discharges []*Token
+	err := Walk(chain, func(token *Token, _ int) error {
+		if token.Payload == nil {
+			return nil
+		}
+		for _, caveat := range token.Payload.Tpc {
+			if caveat.Loc != s.issuer.CN {
+				continue
+			}
+			condition, err := caveat.Condition(s.svid)
+			if err != nil {
+				return err
+			}
+			if err := s.approve(condition, chain); err != nil {
+				return fmt.Errorf("Caveat not approved: %v\n", err)
+			}
+			discharge, err := Discharge(chain, caveat, s.issuer, s.svid.PrivateKey, DischargeTTL)
+			if err != nil {
+				return err
+			}
+			discharges = append(discharges, discharge)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(discharges) == 0 {
+		return nil, fmt.Errorf("No caveat for %s\n", s.issuer.CN)
+	}
+	return discharges, nil
+}
+
+// RequestDischarges requests the discharges of a third party discharge service
+// and appends them to the LSVID.
+func RequestDischarges(ctx context.Context, client *http.Client, url string, lsvid *LSVID) error {
+	encLSVID, err := Encode(lsvid)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(encLSVID))
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Unable to reach discharge service: %v\n", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("Discharge service returned %s: %s\n", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var discharges []*Token
+	if err := json.NewDecoder(resp.Body).Decode(&discharges); err != nil {
+		return fmt.Errorf("Error decoding discharges: %v\n", err)
+	}
+	lsvid.Discharges = append(lsvid.Discharges, discharges...)
+
+	return nil
+}
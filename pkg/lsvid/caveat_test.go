@@ -0,0 +1,159 @@
+package lsvid
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestThirdPartyCaveat(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	asserting := ca.newSVID(t, "/asserting-wl")
+	middle := ca.newSVID(t, "/middle-tier")
+	fraud := ca.newSVID(t, "/fraud-check")
+
+	// the fraud-check service approves deposits up to 100
+	approved := true
+	service := NewDischargeService(fraud, ca.newLSVID(t, fraud), func(condition string, chain *Token) error {
+		if condition != "deposit <= 100" || !approved {
+			return errors.New("fraud suspected")
+		}
+		return nil
+	})
+	server := httptest.NewServer(service)
+	defer server.Close()
+
+	caveat, err := NewThirdPartyCaveat(fraud.ID.String(), fraud.Certificates[0].PublicKey, "deposit <= 100")
+	if err != nil {
+		t.Fatalf("NewThirdPartyCaveat: %v", err)
+	}
+	payload := &Payload{
+		Ver: 1,
+		Alg: "ES256",
+		Iat: time.Now().Unix(),
+		Iss: &IDClaim{CN: asserting.ID.String(), ID: ca.newLSVID(t, asserting)},
+		Aud: &IDClaim{CN: middle.ID.String()},
+		Tpc: []*ThirdPartyCaveat{caveat},
+	}
+	encLSVID, err := Extend(&LSVID{Token: ca.newLSVID(t, asserting)}, payload, asserting.PrivateKey)
+	if err != nil {
+		t.Fatalf("Extend: %v", err)
+	}
+	lsvid, err := Decode(encLSVID)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	trustBundle := newTestTrustBundle(t, ca)
+
+	// without the discharge the LSVID is rejected
+	if valid, _ := Validate(lsvid.Token, WithBundleSource(trustBundle)); valid {
+		t.Fatal("LSVID accepted without discharge")
+	}
+
+	condition, err := caveat.Condition(fraud)
+	if err != nil || condition != "deposit <= 100" {
+		t.Fatalf("Condition: %q, %v", condition, err)
+	}
+	if _, err := caveat.Condition(middle); err == nil {
+		t.Fatal("caveat condition readable by other workload")
+	}
+
+	if err := RequestDischarges(context.Background(), server.Client(), server.URL, lsvid); err != nil {
+		t.Fatalf("RequestDischarges: %v", err)
+	}
+	if valid, err := Validate(lsvid.Token, WithBundleSource(trustBundle), WithDischarges(lsvid.Discharges)); !valid {
+		t.Fatalf("LSVID with discharge rejected: %v", err)
+	}
+	if valid, _ := Validate(lsvid.Token, WithDischarges(lsvid.Discharges)); valid {
+		t.Fatal("discharge accepted without bundle source")
+	}
+
+	// discharges are kept when extending, and stay bound to the caveat hop
+	final := ca.newSVID(t, "/target-wl")
+	extPayload := &Payload{
+		Ver: 1,
+		Alg: "ES256",
+		Iat: time.Now().Unix(),
+		Iss: &IDClaim{CN: middle.ID.String(), ID: ca.newLSVID(t, middle)},
+		Aud: &IDClaim{CN: final.ID.String()},
+	}
+	encLSVID, err = Extend(lsvid, extPayload, middle.PrivateKey)
+	if err != nil {
+		t.Fatalf("Extend: %v", err)
+	}
+	extended, err := Decode(encLSVID)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if valid, err := Validate(extended.Token, WithBundleSource(trustBundle), WithDischarges(extended.Discharges)); !valid {
+		t.Fatalf("extended LSVID rejected: %v", err)
+	}
+
+	// a discharge signed by another workload, with a self-made issuer LSVID or
+	// expired is rejected
+	attacker := newTestCA(t, "example.org")
+	impostor := attacker.newSVID(t, "/fraud-check")
+	for name, issue := range map[string]func() (*Token, error){
+		"forged": func() (*Token, error) {
+			return Discharge(lsvid.Token, caveat, &IDClaim{CN: fraud.ID.String(), ID: ca.newLSVID(t, fraud)}, middle.PrivateKey, DischargeTTL)
+		},
+		"self-made issuer LSVID": func() (*Token, error) {
+			return Discharge(lsvid.Token, caveat, &IDClaim{CN: fraud.ID.String(), ID: attacker.newLSVID(t, impostor)}, impostor.PrivateKey, DischargeTTL)
+		},
+		"expired": func() (*Token, error) {
+			return Discharge(lsvid.Token, caveat, &IDClaim{CN: fraud.ID.String(), ID: ca.newLSVID(t, fraud)}, fraud.PrivateKey, -time.Minute)
+		},
+	} {
+		discharge, err := issue()
+		if err != nil {
+			t.Fatalf("Discharge: %v", err)
+		}
+		for _, opts := range [][]ValidateOption{
+			{WithDischarges([]*Token{discharge})},
+			{WithBundleSource(trustBundle), WithDischarges([]*Token{discharge})},
+		} {
+			if valid, _ := Validate(lsvid.Token, opts...); valid {
+				t.Errorf("%s discharge accepted", name)
+			}
+		}
+	}
+
+	// the third party may refuse to discharge
+	approved = false
+	refused := &LSVID{Token: lsvid.Token}
+	if err := RequestDischarges(context.Background(), server.Client(), server.URL, refused); err == nil {
+		t.Fatal("discharge issued for refused caveat")
+	}
+}
+
+// The discharge alg follows the key of the third party.
+func TestDischargeAlg(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	path := ca.newPath(t, "/asserting-wl", "/middle-tier")
+	fraud := ca.newSVID(t, "/fraud-check")
+	caveat, err := NewThirdPartyCaveat(fraud.ID.String(), fraud.Certificates[0].PublicKey, "deposit <= 100")
+	if err != nil {
+		t.Fatalf("NewThirdPartyCaveat: %v", err)
+	}
+	lsvid := ca.newChain(t, path, func(_ int, payload *Payload) {
+		payload.Tpc = []*ThirdPartyCaveat{caveat}
+	})
+
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	discharge, err := Discharge(lsvid.Token, caveat, &IDClaim{CN: fraud.ID.String()}, key, DischargeTTL)
+	if err != nil {
+		t.Fatalf("Discharge: %v", err)
+	}
+	if discharge.Payload.Alg != "ES384" {
+		t.Errorf("got alg %s for P-384 key, want ES384", discharge.Payload.Alg)
+	}
+}
@@ -0,0 +1,247 @@
+package lsvid
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"net/url"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/bundle/x509bundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/spiffetls/tlsconfig"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+)
+
+// OIDLSVIDExtension identifies the X.509 extension carrying an encoded LSVID.
+// The arc is a PoC placeholder and not a registered OID.
+var OIDLSVIDExtension = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1, 1}
+
+// DefaultCarrierTTL is the lifetime used when no TTL is given to NewCarrierCertificate.
+const DefaultCarrierTTL = 5 * time.Minute
+
+// NewCarrierCertificate creates a short-lived leaf certificate embedding the given LSVID.
+//
+// The carrier has the same SPIFFE ID of the X509-SVID, a fresh ECDSA key and is
+// signed by the SVID private key. The returned certificate chain is the carrier
+// followed by the X509-SVID chain, so it can be presented in an mTLS handshake.
+// Peers must verify it with VerifyPeerCertificate, given that the SVID is not a CA.
+func NewCarrierCertificate(svid *x509svid.SVID, lsvid *LSVID, ttl time.Duration) (*tls.Certificate, error) {
+	if svid == nil || len(svid.Certificates) == 0 {
+		return nil, fmt.Errorf("X509-SVID is required\n")
+	}
+	if ttl <= 0 {
+		ttl = DefaultCarrierTTL
+	}
+
+	encLSVID, err := Encode(lsvid)
+	if err != nil {
+		return nil, err
+	}
+	extValue, err := asn1.Marshal([]byte(encLSVID))
+	if err != nil {
+		return nil, fmt.Errorf("Error marshaling LSVID extension: %v\n", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("Error generating carrier key: %v\n", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("Error generating serial number: %v\n", err)
+	}
+
+	// The carrier can not outlive the SVID that signs it
+	svidCert := svid.Certificates[0]
+	now := time.Now()
+	notAfter := now.Add(ttl)
+	if notAfter.After(svidCert.NotAfter) {
+		notAfter = svidCert.NotAfter
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:    serial,
+		Subject:         pkix.Name{SerialNumber: serial.String()},
+		URIs:            []*url.URL{svid.ID.URL()},
+		NotBefore:       now.Add(-time.Minute),
+		NotAfter:        notAfter,
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		ExtraExtensions: []pkix.Extension{{Id: OIDLSVIDExtension, Value: extValue}},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, svidCert, key.Public(), svid.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating carrier certificate: %v\n", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing carrier certificate: %v\n", err)
+	}
+
+	chain := [][]byte{der}
+	for _, cert := range svid.Certificates {
+		chain = append(chain, cert.Raw)
+	}
+
+	return &tls.Certificate{
+		Certificate: chain,
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+// FromCertificate extracts the LSVID embedded in a carrier certificate.
+// It does not perform any verification.
+func FromCertificate(cert *x509.Certificate) (*LSVID, error) {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(OIDLSVIDExtension) {
+			continue
+		}
+		var encLSVID []byte
+		rest, err := asn1.Unmarshal(ext.Value, &encLSVID)
+		if err != nil {
+			return nil, fmt.Errorf("Error unmarshaling LSVID extension: %v\n", err)
+		}
+		if len(rest) != 0 {
+			return nil, fmt.Errorf("Trailing data after LSVID extension\n")
+		}
+		return Decode(string(encLSVID))
+	}
+
+	return nil, fmt.Errorf("Certificate has no LSVID extension\n")
+}
+
+// FromPeerCertificates extracts and validates the LSVID presented in an mTLS handshake
+// (e.g., tls.ConnectionState.PeerCertificates).
+//
+// It verifies that the carrier was signed by the peer X509-SVID key, that the
+// X509-SVID chains back to the bundle, that the LSVID was issued to or by the peer
+// and finally validates the LSVID itself, with its root verified against the same
+// bundle. Further options (e.g.: WithTrustDomainPolicy) are passed to Validate and
+// may replace the bundle source (e.g.: with a BundleSet holding other authorities).
+// It returns the LSVID and the peer SPIFFE ID.
+func FromPeerCertificates(certs []*x509.Certificate, bundleSource x509bundle.Source, opts ...ValidateOption) (*LSVID, spiffeid.ID, error) {
+	id, _, err := verifyCarrier(certs, bundleSource)
+	if err != nil {
+		return nil, spiffeid.ID{}, err
+	}
+
+	lsvid, err := FromCertificate(certs[0])
+	if err != nil {
+		return nil, spiffeid.ID{}, err
+	}
+	if lsvid.Token == nil || lsvid.Token.Payload == nil {
+		return nil, spiffeid.ID{}, fmt.Errorf("Empty LSVID in carrier certificate\n")
+	}
+
+	// The LSVID must be the peer own LSVID or a token extended by the peer
+	payload := lsvid.Token.Payload
+	if !(payload.Iss != nil && payload.Iss.CN == id.String()) && !(payload.Sub != nil && payload.Sub.CN == id.String()) {
+		return nil, spiffeid.ID{}, fmt.Errorf("LSVID is not bound to peer %s\n", id)
+	}
+
+	opts = append([]ValidateOption{WithBundleSource(X509BundleSource(bundleSource))}, opts...)
+	valid, err := Validate(lsvid.Token, opts...)
+	if err != nil {
+		return nil, spiffeid.ID{}, fmt.Errorf("Error validating LSVID: %v\n", err)
+	}
+	if !valid {
+		return nil, spiffeid.ID{}, fmt.Errorf("LSVID validation failed\n")
+	}
+
+	return lsvid, id, nil
+}
+
+// VerifyPeerCertificate returns a tls.Config VerifyPeerCertificate callback accepting
+// both plain X509-SVIDs and LSVID carrier certificates. Carriers are checked as in
+// FromPeerCertificates (except the LSVID validation) and the authorizer is
+// called with the peer X509-SVID SPIFFE ID.
+func VerifyPeerCertificate(bundleSource x509bundle.Source, authorizer tlsconfig.Authorizer) func([][]byte, [][]*x509.Certificate) error {
+	return func(raw [][]byte, _ [][]*x509.Certificate) error {
+		certs, err := parseRawCertificates(raw)
+		if err != nil {
+			return err
+		}
+
+		if !hasLSVIDExtension(certs[0]) {
+			id, chains, err := x509svid.Verify(certs, bundleSource)
+			if err != nil {
+				return err
+			}
+			return authorizer(id, chains)
+		}
+
+		id, chains, err := verifyCarrier(certs, bundleSource)
+		if err != nil {
+			return err
+		}
+		return authorizer(id, chains)
+	}
+}
+
+// verifyCarrier checks the carrier certificate against the X509-SVID that follows it.
+// It returns the peer SPIFFE ID and the X509-SVID verified chains.
+func verifyCarrier(certs []*x509.Certificate, bundleSource x509bundle.Source) (spiffeid.ID, [][]*x509.Certificate, error) {
+	if len(certs) < 2 {
+		return spiffeid.ID{}, nil, fmt.Errorf("Carrier certificate must be followed by the X509-SVID chain\n")
+	}
+	carrier := certs[0]
+
+	id, chains, err := x509svid.Verify(certs[1:], bundleSource)
+	if err != nil {
+		return spiffeid.ID{}, nil, fmt.Errorf("Error verifying X509-SVID: %v\n", err)
+	}
+
+	// The SVID is not a CA, so CheckSignatureFrom can not be used here
+	err = certs[1].CheckSignature(carrier.SignatureAlgorithm, carrier.RawTBSCertificate, carrier.Signature)
+	if err != nil {
+		return spiffeid.ID{}, nil, fmt.Errorf("Carrier certificate not signed by X509-SVID: %v\n", err)
+	}
+
+	carrierID, err := x509svid.IDFromCert(carrier)
+	if err != nil {
+		return spiffeid.ID{}, nil, fmt.Errorf("Error retrieving carrier SPIFFE ID: %v\n", err)
+	}
+	if carrierID != id {
+		return spiffeid.ID{}, nil, fmt.Errorf("Carrier SPIFFE ID %s does not match X509-SVID %s\n", carrierID, id)
+	}
+
+	now := time.Now()
+	if now.Before(carrier.NotBefore) || now.After(carrier.NotAfter) {
+		return spiffeid.ID{}, nil, fmt.Errorf("Carrier certificate expired or not yet valid\n")
+	}
+
+	return id, chains, nil
+}
+
+func hasLSVIDExtension(cert *x509.Certificate) bool {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(OIDLSVIDExtension) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseRawCertificates(raw [][]byte) ([]*x509.Certificate, error) {
+	if len(raw) == 0 {
+		return nil, fmt.Errorf("No peer certificates\n")
+	}
+	certs := make([]*x509.Certificate, 0, len(raw))
+	for _, der := range raw {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing peer certificate: %v\n", err)
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
@@ -0,0 +1,146 @@
+package lsvid
+
+import (
+	"crypto/x509"
+	"testing"
+
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/spiffetls/tlsconfig"
+)
+
+func TestCarrierCertificate(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	asserting := ca.newSVID(t, "/asserting-wl")
+	middle := ca.newSVID(t, "/middle-tier")
+
+	root := &LSVID{Token: ca.newLSVID(t, asserting)}
+	lsvid := extend(t, root, ca.newPayload(t, asserting, middle.ID.String()), asserting)
+
+	carrier, err := NewCarrierCertificate(asserting, lsvid, 0)
+	if err != nil {
+		t.Fatalf("NewCarrierCertificate: %v", err)
+	}
+	certs := parseChain(t, carrier.Certificate)
+	if len(certs) != 2 {
+		t.Fatalf("got %d certificates, want the carrier and the X509-SVID", len(certs))
+	}
+
+	got, id, err := FromPeerCertificates(certs, ca.bundle())
+	if err != nil {
+		t.Fatalf("FromPeerCertificates: %v", err)
+	}
+	if id != asserting.ID {
+		t.Errorf("got peer %s, want %s", id, asserting.ID)
+	}
+	if got.Token.Payload.Aud.CN != middle.ID.String() {
+		t.Errorf("got LSVID for %s, want %s", got.Token.Payload.Aud.CN, middle.ID)
+	}
+
+	var authorized spiffeid.ID
+	verify := VerifyPeerCertificate(ca.bundle(), func(id spiffeid.ID, _ [][]*x509.Certificate) error {
+		authorized = id
+		return nil
+	})
+	if err := verify(carrier.Certificate, nil); err != nil {
+		t.Fatalf("carrier rejected: %v", err)
+	}
+	if authorized != asserting.ID {
+		t.Errorf("authorizer called with %s, want %s", authorized, asserting.ID)
+	}
+
+	// plain X509-SVIDs are still accepted
+	if err := verify([][]byte{middle.Certificates[0].Raw}, nil); err != nil {
+		t.Errorf("X509-SVID rejected: %v", err)
+	}
+}
+
+func TestCarrierCertificateRejected(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	asserting := ca.newSVID(t, "/asserting-wl")
+	middle := ca.newSVID(t, "/middle-tier")
+	verify := VerifyPeerCertificate(ca.bundle(), tlsconfig.AuthorizeAny())
+
+	root := &LSVID{Token: ca.newLSVID(t, asserting)}
+	lsvid := extend(t, root, ca.newPayload(t, asserting, middle.ID.String()), asserting)
+	carrier, err := NewCarrierCertificate(asserting, lsvid, 0)
+	if err != nil {
+		t.Fatalf("NewCarrierCertificate: %v", err)
+	}
+	certs := parseChain(t, carrier.Certificate)
+
+	// carrier presented with another X509-SVID
+	swapped := [][]byte{carrier.Certificate[0], middle.Certificates[0].Raw}
+	if err := verify(swapped, nil); err == nil {
+		t.Error("carrier accepted with another X509-SVID")
+	}
+	if _, _, err := FromPeerCertificates(parseChain(t, swapped), ca.bundle()); err == nil {
+		t.Error("LSVID accepted with another X509-SVID")
+	}
+
+	// carrier without the X509-SVID
+	if err := verify(carrier.Certificate[:1], nil); err == nil {
+		t.Error("carrier accepted without X509-SVID")
+	}
+
+	// carrier of another trust domain
+	other := newTestCA(t, "example.org")
+	if _, _, err := FromPeerCertificates(certs, other.bundle()); err == nil {
+		t.Error("carrier accepted by another authority")
+	}
+
+	// tampered carrier signature
+	tampered := append([]byte(nil), carrier.Certificate[0]...)
+	tampered[len(tampered)-1] ^= 0xff
+	if err := verify([][]byte{tampered, carrier.Certificate[1]}, nil); err == nil {
+		t.Error("tampered carrier accepted")
+	}
+
+	// carrier of an LSVID not bound to the peer
+	foreign := extend(t, &LSVID{Token: ca.newLSVID(t, middle)}, ca.newPayload(t, middle, asserting.ID.String()), middle)
+	carrier, err = NewCarrierCertificate(ca.newSVID(t, "/other-wl"), foreign, 0)
+	if err != nil {
+		t.Fatalf("NewCarrierCertificate: %v", err)
+	}
+	if _, _, err := FromPeerCertificates(parseChain(t, carrier.Certificate), ca.bundle()); err == nil {
+		t.Error("LSVID of another workload accepted")
+	}
+
+	// carrier of an LSVID whose root was signed by a foreign CA
+	attacker := newTestCA(t, "example.org")
+	forged := extend(t, &LSVID{Token: attacker.newLSVID(t, asserting)}, ca.newPayload(t, asserting, middle.ID.String()), asserting)
+	carrier, err = NewCarrierCertificate(asserting, forged, 0)
+	if err != nil {
+		t.Fatalf("NewCarrierCertificate: %v", err)
+	}
+	if _, _, err := FromPeerCertificates(parseChain(t, carrier.Certificate), ca.bundle()); err == nil {
+		t.Error("LSVID with a foreign root accepted")
+	}
+
+	// options are passed to the LSVID validation
+	carrier, err = NewCarrierCertificate(asserting, lsvid, 0)
+	if err != nil {
+		t.Fatalf("NewCarrierCertificate: %v", err)
+	}
+	policy := WithTrustDomainPolicy(AllowTrustDomains(spiffeid.RequireTrustDomainFromString("other.org")))
+	if _, _, err := FromPeerCertificates(parseChain(t, carrier.Certificate), ca.bundle(), policy); err == nil {
+		t.Error("LSVID accepted despite the trust domain policy")
+	}
+
+	// carrier of an LSVID with a tampered hop
+	lsvid.Token.Payload.Dpr = "mallory"
+	carrier, err = NewCarrierCertificate(asserting, lsvid, 0)
+	if err != nil {
+		t.Fatalf("NewCarrierCertificate: %v", err)
+	}
+	if _, _, err := FromPeerCertificates(parseChain(t, carrier.Certificate), ca.bundle()); err == nil {
+		t.Error("tampered LSVID accepted")
+	}
+}
+
+func parseChain(t *testing.T, raw [][]byte) []*x509.Certificate {
+	certs, err := parseRawCertificates(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certs
+}
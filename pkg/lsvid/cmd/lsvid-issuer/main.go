@@ -0,0 +1,181 @@
+// lsvid-issuer is a standalone LSVID issuer for environments running the
+// stock SPIRE (i.e., without FetchLSVID support).
+//
+// The workloads request their LSVID over a Unix socket presenting their X509-SVID,
+// which is verified against the bundle watched through the Workload API and converted
+// in a root LSVID signed by a locally held CA key. Callers are attested by their uid
+// and, optionally, executable (SO_PEERCRED) against the registered SPIFFE IDs.
+//
+//	usage: ./lsvid-issuer -trustdomain example.org -key ./ca.pem -listen /tmp/lsvid-issuer/api.sock \
+//		-register spiffe://example.org/asserting-wl=1000 -register spiffe://example.org/target-wl=1001:/usr/local/bin/target-wl
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	lsvid "github.com/hpe-usp-spire/signed-assertions/lsvid"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+)
+
+func main() {
+	socketPath := flag.String("socket", os.Getenv("SOCKET_PATH"), "SPIRE Workload API socket path")
+	trustDomain := flag.String("trustdomain", os.Getenv("TRUST_DOMAIN"), "trust domain of the issued LSVIDs")
+	keyPath := flag.String("key", "./ca.pem", "PEM encoded CA private key. Generated if not present")
+	listen := flag.String("listen", "/tmp/lsvid-issuer/api.sock", "Unix socket where LSVIDs are served")
+	var entries registrations
+	flag.Var(&entries, "register", "registration entry <spiffe id>=<uid>[:<executable>]. Can be repeated")
+	flag.Parse()
+
+	td, err := spiffeid.TrustDomainFromString(*trustDomain)
+	if err != nil {
+		log.Fatalf("Invalid trust domain: %v\n", err)
+	}
+
+	key, err := loadOrCreateKey(*keyPath)
+	if err != nil {
+		log.Fatalf("Unable to load CA key: %v\n", err)
+	}
+
+	issuer, err := lsvid.NewIssuer(td, key)
+	if err != nil {
+		log.Fatalf("Unable to create issuer: %v\n", err)
+	}
+	for _, entry := range entries {
+		id, authorize, err := parseRegistration(entry)
+		if err != nil {
+			log.Fatalf("Invalid registration entry %q: %v\n", entry, err)
+		}
+		if err := issuer.Register(id, authorize); err != nil {
+			log.Fatalf("Unable to register %s: %v\n", id, err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	listenPath := strings.TrimPrefix(*listen, "unix://")
+	if err := os.MkdirAll(filepath.Dir(listenPath), 0755); err != nil {
+		log.Fatalf("Unable to create socket directory: %v\n", err)
+	}
+	os.Remove(listenPath)
+	l, err := net.Listen("unix", listenPath)
+	if err != nil {
+		log.Fatalf("Unable to listen on %s: %v\n", listenPath, err)
+	}
+	defer l.Close()
+
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	go func() {
+		if err := issuer.Run(ctx, *socketPath); err != nil && ctx.Err() == nil {
+			log.Fatalf("Error watching X.509 bundles: %v\n", err)
+		}
+	}()
+
+	log.Printf("Serving LSVIDs on %s\n", listenPath)
+	if err := issuer.Serve(l); err != nil && ctx.Err() == nil {
+		log.Fatalf("Error serving LSVIDs: %v\n", err)
+	}
+}
+
+// registrations are the repeated -register flags.
+type registrations []string
+
+func (r *registrations) String() string {
+	return strings.Join(*r, ",")
+}
+
+func (r *registrations) Set(entry string) error {
+	*r = append(*r, entry)
+	return nil
+}
+
+// parseRegistration parses a <spiffe id>=<uid>[:<executable>] registration entry.
+func parseRegistration(entry string) (spiffeid.ID, lsvid.PeerAuthorizer, error) {
+	idStr, selectors, ok := strings.Cut(entry, "=")
+	if !ok {
+		return spiffeid.ID{}, nil, fmt.Errorf("missing uid")
+	}
+	id, err := spiffeid.FromString(idStr)
+	if err != nil {
+		return spiffeid.ID{}, nil, err
+	}
+
+	uidStr, exe, _ := strings.Cut(selectors, ":")
+	uid, err := strconv.ParseUint(uidStr, 10, 32)
+	if err != nil {
+		return spiffeid.ID{}, nil, fmt.Errorf("invalid uid %q", uidStr)
+	}
+	authorizeUID := lsvid.AuthorizeUIDs(uint32(uid))
+	if exe == "" {
+		return id, authorizeUID, nil
+	}
+
+	authorizeExe := lsvid.AuthorizeExecutables(exe)
+	return id, func(cred *lsvid.PeerCred) error {
+		if err := authorizeUID(cred); err != nil {
+			return err
+		}
+		return authorizeExe(cred)
+	}, nil
+}
+
+// loadOrCreateKey reads an ECDSA (SEC 1 or PKCS #8) private key from path,
+// generating and storing a new P-256 key if the file does not exist.
+func loadOrCreateKey(path string) (crypto.Signer, error) {
+	pemBytes, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		log.Printf("No CA key found in %s. Generating a new one...\n", path)
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		pemBytes = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		if err := os.WriteFile(path, pemBytes, 0600); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	return signer, nil
+}
@@ -0,0 +1,125 @@
+// lsvid-signer is a local signing service holding the workload X509-SVID key,
+// so the workloads extend LSVIDs and sign assertions without holding it.
+//
+// It watches the X509-SVID through the Workload API and serves the signer over a
+// Unix socket. Callers are authorized by their uid and, optionally, executable
+// (SO_PEERCRED), and use it through lsvid.SignerClient.
+//
+//	usage: ./lsvid-signer -uid 1000 -listen /tmp/lsvid-signer/api.sock
+package main
+
+import (
+	"context"
+	"crypto"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	lsvid "github.com/hpe-usp-spire/signed-assertions/lsvid"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+)
+
+func main() {
+	socketPath := flag.String("socket", os.Getenv("SOCKET_PATH"), "SPIRE Workload API socket path")
+	listen := flag.String("listen", "/tmp/lsvid-signer/api.sock", "Unix socket where the signer is served")
+	uids := flag.String("uid", strconv.Itoa(os.Getuid()), "comma separated uids allowed to use the signer")
+	exe := flag.String("exe", "", "comma separated executables allowed to use the signer. Any if empty")
+	flag.Parse()
+
+	authorize, err := peerAuthorizer(*uids, *exe)
+	if err != nil {
+		log.Fatalf("Invalid authorization flags: %v\n", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	source, err := workloadapi.NewX509Source(ctx, workloadapi.WithClientOptions(workloadapi.WithAddr(*socketPath)))
+	if err != nil {
+		log.Fatalf("Unable to create X509Source: %v\n", err)
+	}
+	defer source.Close()
+
+	listenPath := strings.TrimPrefix(*listen, "unix://")
+	if err := os.MkdirAll(filepath.Dir(listenPath), 0755); err != nil {
+		log.Fatalf("Unable to create socket directory: %v\n", err)
+	}
+	os.Remove(listenPath)
+	l, err := net.Listen("unix", listenPath)
+	if err != nil {
+		log.Fatalf("Unable to listen on %s: %v\n", listenPath, err)
+	}
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	signer := lsvid.NewSignerService(&svidSigner{source: source}, authorize)
+	log.Printf("Serving signer on %s\n", listenPath)
+	if err := signer.Serve(l); err != nil && ctx.Err() == nil {
+		log.Fatalf("Error serving signer: %v\n", err)
+	}
+}
+
+// svidSigner signs with the current X509-SVID key, following its rotation.
+type svidSigner struct {
+	source *workloadapi.X509Source
+}
+
+func (s *svidSigner) key() (crypto.Signer, error) {
+	svid, err := s.source.GetX509SVID()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get X509-SVID: %v\n", err)
+	}
+	return svid.PrivateKey, nil
+}
+
+// Public returns the current X509-SVID public key, or nil if it is not available.
+func (s *svidSigner) Public() crypto.PublicKey {
+	key, err := s.key()
+	if err != nil {
+		log.Print(err)
+		return nil
+	}
+	return key.Public()
+}
+
+func (s *svidSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	key, err := s.key()
+	if err != nil {
+		return nil, err
+	}
+	return key.Sign(rand, digest, opts)
+}
+
+// peerAuthorizer allows the given uids and, if any, executables.
+func peerAuthorizer(uids string, exes string) (lsvid.PeerAuthorizer, error) {
+	var allowed []uint32
+	for _, uid := range strings.Split(uids, ",") {
+		n, err := strconv.ParseUint(strings.TrimSpace(uid), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid uid %q", uid)
+		}
+		allowed = append(allowed, uint32(n))
+	}
+	authorizeUID := lsvid.AuthorizeUIDs(allowed...)
+	if exes == "" {
+		return authorizeUID, nil
+	}
+
+	authorizeExe := lsvid.AuthorizeExecutables(strings.Split(exes, ",")...)
+	return func(cred *lsvid.PeerCred) error {
+		if err := authorizeUID(cred); err != nil {
+			return err
+		}
+		return authorizeExe(cred)
+	}, nil
+}
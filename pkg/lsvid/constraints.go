@@ -0,0 +1,110 @@
+package lsvid
+
+import (
+	"fmt"
+	"path"
+	"time"
+)
+
+// Constraints limit how far an LSVID travels after the hop that sets them,
+// usually the originator minting the delegation for a user.
+// They are enforced by Validate against every later hop.
+type Constraints struct {
+	// Maximum number of hops after the constraining hop. Unlimited if nil.
+	MaxHops *int `json:"max_hops,omitempty"`
+	// SPIFFE ID patterns allowed as audiences of later hops, as in path.Match
+	// (e.g.: spiffe://example.org/ns/bank/*). Any audience is allowed if empty.
+	Aud []string `json:"aud,omitempty"`
+	// Deadline, in seconds since epoch. Later hops can not be issued, nor the
+	// LSVID validated, after it. No deadline if zero.
+	Exp int64 `json:"exp,omitempty"`
+}
+
+// NewConstraints creates constraints for the later hops.
+// A negative maxHops or a zero deadline are not enforced.
+func NewConstraints(maxHops int, audiences []string, deadline time.Time) (*Constraints, error) {
+	for _, pattern := range audiences {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("Invalid audience pattern %q: %v\n", pattern, err)
+		}
+	}
+
+	cst := &Constraints{
+		Aud: audiences,
+	}
+	if maxHops >= 0 {
+		cst.MaxHops = &maxHops
+	}
+	if !deadline.IsZero() {
+		cst.Exp = deadline.Unix()
+	}
+
+	return cst, nil
+}
+
+// checkConstraints enforces the constraints of each hop against the later
+// hops of every path of the chain.
+func checkConstraints(lsvid *Token, now time.Time) error {
+	var hops []*Token
+	var check func(token *Token) error
+	check = func(token *Token) error {
+		// hops holds the later hops, from the outer most one
+		if token.Payload != nil && token.Payload.Cst != nil {
+			if err := token.Payload.Cst.check(hops, now); err != nil {
+				return fmt.Errorf("Constraints of %s violated: %v\n", idClaimCN(token.Payload.Iss), err)
+			}
+		}
+
+		hops = append(hops, token)
+		defer func() { hops = hops[:len(hops)-1] }()
+		for _, parent := range token.Parents() {
+			if err := check(parent); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return check(lsvid)
+}
+
+func (c *Constraints) check(later []*Token, now time.Time) error {
+	if c.MaxHops != nil && len(later) > *c.MaxHops {
+		return fmt.Errorf("%d later hops, at most %d allowed", len(later), *c.MaxHops)
+	}
+
+	if c.Exp != 0 {
+		if now.Unix() > c.Exp {
+			return fmt.Errorf("deadline %s exceeded", time.Unix(c.Exp, 0).UTC())
+		}
+		for _, hop := range later {
+			if hop.Payload != nil && hop.Payload.Iat > c.Exp {
+				return fmt.Errorf("hop by %s issued after deadline", idClaimCN(hop.Payload.Iss))
+			}
+		}
+	}
+
+	if len(c.Aud) > 0 {
+		for _, hop := range later {
+			if hop.Payload == nil {
+				return fmt.Errorf("audience of redacted hop can not be checked")
+			}
+			for _, aud := range hop.Payload.Audiences() {
+				if !c.allowsAudience(aud.CN) {
+					return fmt.Errorf("audience %s is not allowed", aud.CN)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *Constraints) allowsAudience(cn string) bool {
+	for _, pattern := range c.Aud {
+		if ok, _ := path.Match(pattern, cn); ok {
+			return true
+		}
+	}
+	return false
+}
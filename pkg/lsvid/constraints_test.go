@@ -0,0 +1,78 @@
+package lsvid
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+)
+
+func TestConstraints(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	asserting := ca.newSVID(t, "/asserting-wl")
+	middle := ca.newSVID(t, "/bank/middle-tier")
+	target := ca.newSVID(t, "/bank/target-wl")
+	outside := ca.newSVID(t, "/shop/target-wl")
+
+	constrain := func(maxHops int, audiences []string, deadline time.Time) func(int, *Payload) {
+		cst, err := NewConstraints(maxHops, audiences, deadline)
+		if err != nil {
+			t.Fatalf("NewConstraints: %v", err)
+		}
+		return func(hop int, payload *Payload) {
+			if hop == 0 {
+				payload.Cst = cst
+			}
+		}
+	}
+	bank := []string{"spiffe://example.org/bank/*"}
+	later := time.Now().Add(time.Hour)
+
+	for _, tt := range []struct {
+		name  string
+		path  []*x509svid.SVID
+		edit  func(int, *Payload)
+		valid bool
+	}{
+		{"unconstrained", []*x509svid.SVID{asserting, middle, target, outside}, nil, true},
+		{"max hops", []*x509svid.SVID{asserting, middle, target}, constrain(1, nil, time.Time{}), true},
+		{"too many hops", []*x509svid.SVID{asserting, middle, target, outside}, constrain(1, nil, time.Time{}), false},
+		{"no later hop", []*x509svid.SVID{asserting, middle, target}, constrain(0, nil, time.Time{}), false},
+		{"audience", []*x509svid.SVID{asserting, middle, target}, constrain(-1, bank, time.Time{}), true},
+		{"audience not allowed", []*x509svid.SVID{asserting, middle, target, outside}, constrain(-1, bank, time.Time{}), false},
+		{"deadline", []*x509svid.SVID{asserting, middle, target}, constrain(-1, nil, later), true},
+		{"deadline exceeded", []*x509svid.SVID{asserting, middle}, constrain(-1, nil, time.Now().Add(-time.Minute)), false},
+		{"hop after deadline", []*x509svid.SVID{asserting, middle, target}, func(hop int, payload *Payload) {
+			constrain(-1, nil, later)(hop, payload)
+			if hop == 1 {
+				payload.Iat = later.Add(time.Minute).Unix()
+			}
+		}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			checkValidate(t, ca.newChain(t, tt.path, tt.edit).Token, tt.valid)
+		})
+	}
+}
+
+func TestConstraintsTampered(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	cst, err := NewConstraints(0, nil, time.Time{})
+	if err != nil {
+		t.Fatalf("NewConstraints: %v", err)
+	}
+	lsvid := ca.newChain(t, ca.newPath(t, "/asserting-wl", "/middle-tier", "/target-wl"), func(hop int, payload *Payload) {
+		if hop == 0 {
+			payload.Cst = cst
+		}
+	})
+	checkValidate(t, lsvid.Token, false)
+
+	// the constraints are signed by the constraining hop
+	lsvid.Token.Nested.Payload.Cst = nil
+	checkValidate(t, lsvid.Token, false)
+
+	if _, err := NewConstraints(-1, []string{"spiffe://example.org/["}, time.Time{}); err == nil {
+		t.Error("invalid audience pattern accepted")
+	}
+}
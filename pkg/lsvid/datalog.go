@@ -0,0 +1,639 @@
+package lsvid
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// This file implements the subset of the Biscuit Datalog language used by
+// LSVID blocks: facts, rules, checks and policies over strings, integers,
+// booleans and dates, with comparison expressions.
+// E.g.:
+//
+//	right("account/1", "read")
+//	can_read($r) <- right($r, "read")
+//	check if resource($r), can_read($r)
+//	check if time($t), $t < 2030-01-01T00:00:00Z
+//	allow if operation("read")
+
+// maximum number of facts, fixpoint iterations and unifications (of a whole
+// evaluation), bounding the evaluation cost, and of statements of each kind per block
+const (
+	datalogMaxFacts           = 1000
+	datalogMaxIterations      = 100
+	datalogMaxWork            = 1000000
+	datalogMaxBlockStatements = 100
+)
+
+type variable string
+
+type date int64
+
+type predicate struct {
+	name  string
+	terms []interface{}
+}
+
+type expression struct {
+	op          string
+	left, right interface{}
+}
+
+type rule struct {
+	head  predicate
+	body  []predicate
+	exprs []expression
+}
+
+// query is a check or policy: it holds if any of its bodies matches
+type query struct {
+	kind   string // "check", "allow" or "deny"
+	bodies []rule
+	source string
+}
+
+func (p predicate) String() string {
+	terms := make([]string, len(p.terms))
+	for i, t := range p.terms {
+		terms[i] = formatTerm(t)
+	}
+	return p.name + "(" + strings.Join(terms, ", ") + ")"
+}
+
+func formatTerm(t interface{}) string {
+	switch t := t.(type) {
+	case variable:
+		return "$" + string(t)
+	case string:
+		return strconv.Quote(t)
+	case int64:
+		return strconv.FormatInt(t, 10)
+	case bool:
+		return strconv.FormatBool(t)
+	case date:
+		return time.Unix(int64(t), 0).UTC().Format(time.RFC3339)
+	default:
+		return fmt.Sprint(t)
+	}
+}
+
+func (p predicate) ground() bool {
+	for _, t := range p.terms {
+		if _, ok := t.(variable); ok {
+			return false
+		}
+	}
+	return true
+}
+
+// factSet holds ground facts, keyed by their source representation
+type factSet map[string]predicate
+
+func (s factSet) add(p predicate) bool {
+	key := p.String()
+	if _, ok := s[key]; ok {
+		return false
+	}
+	s[key] = p
+	return true
+}
+
+func (s factSet) clone() factSet {
+	c := make(factSet, len(s))
+	for k, v := range s {
+		c[k] = v
+	}
+	return c
+}
+
+// sorted returns the facts in a stable order, so evaluation is deterministic
+func (s factSet) sorted() []predicate {
+	keys := make([]string, 0, len(s))
+	for k := range s {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	facts := make([]predicate, len(keys))
+	for i, k := range keys {
+		facts[i] = s[k]
+	}
+	return facts
+}
+
+// datalogBudget is the work left to an evaluation, shared by its rules and queries.
+type datalogBudget struct {
+	work int
+}
+
+func newDatalogBudget() *datalogBudget {
+	return &datalogBudget{work: datalogMaxWork}
+}
+
+func (b *datalogBudget) spend(work int) error {
+	b.work -= work
+	if b.work < 0 {
+		return fmt.Errorf("Datalog evaluation exceeded %d unifications\n", datalogMaxWork)
+	}
+	return nil
+}
+
+// fixpoint applies the rules to the facts until no new fact is produced.
+func fixpoint(facts factSet, rules []rule, budget *datalogBudget) (factSet, error) {
+	facts = facts.clone()
+	for i := 0; i < datalogMaxIterations; i++ {
+		changed := false
+		for _, r := range rules {
+			bindings, err := matchBody(facts, r.body, r.exprs, budget)
+			if err != nil {
+				return nil, err
+			}
+			for _, binding := range bindings {
+				if facts.add(substitute(r.head, binding)) {
+					changed = true
+				}
+				if len(facts) > datalogMaxFacts {
+					return nil, fmt.Errorf("Datalog evaluation exceeded %d facts\n", datalogMaxFacts)
+				}
+			}
+		}
+		if !changed {
+			return facts, nil
+		}
+	}
+	return nil, fmt.Errorf("Datalog evaluation exceeded %d iterations\n", datalogMaxIterations)
+}
+
+// matches checks if any of the query bodies matches the facts.
+func (q query) matches(facts factSet, budget *datalogBudget) (bool, error) {
+	for _, body := range q.bodies {
+		bindings, err := matchBody(facts, body.body, body.exprs, budget)
+		if err != nil {
+			return false, err
+		}
+		if len(bindings) > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// matchBody returns every binding of the body variables satisfying the expressions.
+// Expressions are evaluated as soon as their variables are bound, so the
+// bindings they reject are not joined with the next predicates.
+func matchBody(facts factSet, body []predicate, exprs []expression, budget *datalogBudget) ([]map[variable]interface{}, error) {
+	bindings := []map[variable]interface{}{{}}
+	all := facts.sorted()
+
+	for _, p := range body {
+		if err := budget.spend(len(bindings) * len(all)); err != nil {
+			return nil, err
+		}
+		var next []map[variable]interface{}
+		for _, binding := range bindings {
+			for _, fact := range all {
+				if b, ok := unify(p, fact, binding); ok && holds(exprs, b, false) {
+					next = append(next, b)
+				}
+			}
+		}
+		bindings = next
+		if len(bindings) == 0 {
+			return nil, nil
+		}
+	}
+
+	var matched []map[variable]interface{}
+	for _, binding := range bindings {
+		if holds(exprs, binding, true) {
+			matched = append(matched, binding)
+		}
+	}
+	return matched, nil
+}
+
+// holds evaluates the expressions on the binding. Unless final, expressions
+// with unbound variables are skipped.
+func holds(exprs []expression, binding map[variable]interface{}, final bool) bool {
+	for _, e := range exprs {
+		if !final && !e.bound(binding) {
+			continue
+		}
+		if !e.eval(binding) {
+			return false
+		}
+	}
+	return true
+}
+
+func unify(p, fact predicate, binding map[variable]interface{}) (map[variable]interface{}, bool) {
+	if p.name != fact.name || len(p.terms) != len(fact.terms) {
+		return nil, false
+	}
+
+	b := make(map[variable]interface{}, len(binding))
+	for k, v := range binding {
+		b[k] = v
+	}
+	for i, t := range p.terms {
+		v, isVar := t.(variable)
+		if !isVar {
+			if t != fact.terms[i] {
+				return nil, false
+			}
+			continue
+		}
+		if bound, ok := b[v]; ok {
+			if bound != fact.terms[i] {
+				return nil, false
+			}
+			continue
+		}
+		b[v] = fact.terms[i]
+	}
+	return b, true
+}
+
+func substitute(p predicate, binding map[variable]interface{}) predicate {
+	terms := make([]interface{}, len(p.terms))
+	for i, t := range p.terms {
+		if v, ok := t.(variable); ok {
+			terms[i] = binding[v]
+			continue
+		}
+		terms[i] = t
+	}
+	return predicate{name: p.name, terms: terms}
+}
+
+func (e expression) bound(binding map[variable]interface{}) bool {
+	for _, t := range []interface{}{e.left, e.right} {
+		if v, ok := t.(variable); ok {
+			if _, ok := binding[v]; !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (e expression) eval(binding map[variable]interface{}) bool {
+	resolve := func(t interface{}) (interface{}, bool) {
+		if v, ok := t.(variable); ok {
+			value, ok := binding[v]
+			return value, ok
+		}
+		return t, true
+	}
+	left, ok := resolve(e.left)
+	if !ok {
+		return false
+	}
+	right, ok := resolve(e.right)
+	if !ok {
+		return false
+	}
+
+	switch e.op {
+	case "==":
+		return left == right
+	case "!=":
+		return left != right
+	}
+
+	var cmp int
+	switch l := left.(type) {
+	case int64:
+		r, ok := right.(int64)
+		if !ok {
+			return false
+		}
+		cmp = compareInt(l, r)
+	case date:
+		r, ok := right.(date)
+		if !ok {
+			return false
+		}
+		cmp = compareInt(int64(l), int64(r))
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return false
+		}
+		cmp = strings.Compare(l, r)
+	default:
+		return false
+	}
+
+	switch e.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// Parser
+
+type datalogParser struct {
+	src string
+	pos int
+}
+
+// parseFact parses a ground predicate.
+func parseFact(src string) (predicate, error) {
+	p := &datalogParser{src: src}
+	fact, err := p.predicate()
+	if err != nil {
+		return predicate{}, err
+	}
+	if err := p.end(); err != nil {
+		return predicate{}, err
+	}
+	if !fact.ground() {
+		return predicate{}, fmt.Errorf("Fact %q must not have variables\n", src)
+	}
+	return fact, nil
+}
+
+// parseRule parses head <- body.
+func parseRule(src string) (rule, error) {
+	p := &datalogParser{src: src}
+	head, err := p.predicate()
+	if err != nil {
+		return rule{}, err
+	}
+	if !p.consume("<-") {
+		return rule{}, p.errorf("expected <-")
+	}
+	r, err := p.body()
+	if err != nil {
+		return rule{}, err
+	}
+	if err := p.end(); err != nil {
+		return rule{}, err
+	}
+	r.head = head
+
+	// every head variable must be bound by the body
+	bound := make(map[variable]bool)
+	for _, b := range r.body {
+		for _, t := range b.terms {
+			if v, ok := t.(variable); ok {
+				bound[v] = true
+			}
+		}
+	}
+	for _, t := range head.terms {
+		if v, ok := t.(variable); ok && !bound[v] {
+			return rule{}, fmt.Errorf("Unbound variable $%s in rule %q\n", v, src)
+		}
+	}
+	return r, nil
+}
+
+// parseQuery parses "check if", "allow if" or "deny if" followed by bodies separated by "or".
+func parseQuery(src string) (query, error) {
+	p := &datalogParser{src: src}
+	q := query{source: strings.TrimSpace(src)}
+	switch {
+	case p.consumeWord("check"):
+		q.kind = "check"
+	case p.consumeWord("allow"):
+		q.kind = "allow"
+	case p.consumeWord("deny"):
+		q.kind = "deny"
+	default:
+		return query{}, p.errorf("expected check, allow or deny")
+	}
+	if !p.consumeWord("if") {
+		return query{}, p.errorf("expected if")
+	}
+
+	for {
+		body, err := p.body()
+		if err != nil {
+			return query{}, err
+		}
+		q.bodies = append(q.bodies, body)
+		if !p.consumeWord("or") {
+			break
+		}
+	}
+	if err := p.end(); err != nil {
+		return query{}, err
+	}
+	return q, nil
+}
+
+func (p *datalogParser) body() (rule, error) {
+	var r rule
+	for {
+		p.skipSpace()
+		if p.peekPredicate() {
+			pred, err := p.predicate()
+			if err != nil {
+				return rule{}, err
+			}
+			r.body = append(r.body, pred)
+		} else {
+			e, err := p.expression()
+			if err != nil {
+				return rule{}, err
+			}
+			r.exprs = append(r.exprs, e)
+		}
+		if !p.consume(",") {
+			break
+		}
+	}
+	if len(r.body) == 0 && len(r.exprs) == 0 {
+		return rule{}, p.errorf("empty body")
+	}
+	return r, nil
+}
+
+func (p *datalogParser) predicate() (predicate, error) {
+	name := p.identifier()
+	if name == "" {
+		return predicate{}, p.errorf("expected predicate name")
+	}
+	if !p.consume("(") {
+		return predicate{}, p.errorf("expected (")
+	}
+	pred := predicate{name: name}
+	for {
+		t, err := p.term()
+		if err != nil {
+			return predicate{}, err
+		}
+		pred.terms = append(pred.terms, t)
+		if !p.consume(",") {
+			break
+		}
+	}
+	if !p.consume(")") {
+		return predicate{}, p.errorf("expected )")
+	}
+	return pred, nil
+}
+
+func (p *datalogParser) expression() (expression, error) {
+	left, err := p.term()
+	if err != nil {
+		return expression{}, err
+	}
+	var op string
+	for _, candidate := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if p.consume(candidate) {
+			op = candidate
+			break
+		}
+	}
+	if op == "" {
+		return expression{}, p.errorf("expected comparison operator")
+	}
+	right, err := p.term()
+	if err != nil {
+		return expression{}, err
+	}
+	return expression{op: op, left: left, right: right}, nil
+}
+
+func (p *datalogParser) term() (interface{}, error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return nil, p.errorf("expected term")
+	}
+
+	switch c := p.src[p.pos]; {
+	case c == '$':
+		p.pos++
+		name := p.identifier()
+		if name == "" {
+			return nil, p.errorf("expected variable name")
+		}
+		return variable(name), nil
+	case c == '"':
+		end := p.pos + 1
+		for end < len(p.src) && p.src[end] != '"' {
+			if p.src[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(p.src) {
+			return nil, p.errorf("unterminated string")
+		}
+		s, err := strconv.Unquote(p.src[p.pos : end+1])
+		if err != nil {
+			return nil, p.errorf("invalid string")
+		}
+		p.pos = end + 1
+		return s, nil
+	case c == '-' || (c >= '0' && c <= '9'):
+		end := p.pos + 1
+		for end < len(p.src) && strings.ContainsRune("0123456789-:TZ+.", rune(p.src[end])) {
+			end++
+		}
+		literal := p.src[p.pos:end]
+		p.pos = end
+		if strings.Contains(literal, "T") {
+			t, err := time.Parse(time.RFC3339, literal)
+			if err != nil {
+				return nil, p.errorf("invalid date")
+			}
+			return date(t.Unix()), nil
+		}
+		n, err := strconv.ParseInt(literal, 10, 64)
+		if err != nil {
+			return nil, p.errorf("invalid integer")
+		}
+		return n, nil
+	}
+
+	switch {
+	case p.consumeWord("true"):
+		return true, nil
+	case p.consumeWord("false"):
+		return false, nil
+	}
+	return nil, p.errorf("invalid term")
+}
+
+func (p *datalogParser) identifier() string {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.src) {
+		r := rune(p.src[p.pos])
+		if !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || (r == ':' && p.pos > start)) {
+			break
+		}
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *datalogParser) peekPredicate() bool {
+	save := p.pos
+	defer func() { p.pos = save }()
+	if p.identifier() == "" {
+		return false
+	}
+	p.skipSpace()
+	return p.pos < len(p.src) && p.src[p.pos] == '('
+}
+
+func (p *datalogParser) consume(s string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(p.src[p.pos:], s) {
+		p.pos += len(s)
+		return true
+	}
+	return false
+}
+
+func (p *datalogParser) consumeWord(word string) bool {
+	save := p.pos
+	if p.identifier() == word {
+		return true
+	}
+	p.pos = save
+	return false
+}
+
+func (p *datalogParser) skipSpace() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *datalogParser) end() error {
+	p.skipSpace()
+	if p.pos != len(p.src) {
+		return p.errorf("unexpected input")
+	}
+	return nil
+}
+
+func (p *datalogParser) errorf(msg string) error {
+	return fmt.Errorf("Datalog syntax error at %d in %q: %s\n", p.pos, p.src, msg)
+}
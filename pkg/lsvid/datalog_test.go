@@ -0,0 +1,170 @@
+package lsvid
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDatalog(t *testing.T) {
+	for _, src := range []string{
+		`right("account/1", "read")`,
+		`limit(100, true)`,
+		`expires(2030-01-01T00:00:00Z)`,
+	} {
+		fact, err := parseFact(src)
+		if err != nil {
+			t.Errorf("parseFact(%q): %v", src, err)
+			continue
+		}
+		if fact.String() != src {
+			t.Errorf("got fact %s, want %s", fact, src)
+		}
+	}
+	for _, src := range []string{
+		`right($r, "read")`,
+		`right("account/1", "read"`,
+		`right("account/1)`,
+		`right()`,
+		`right("a") extra`,
+		`expires(2030-01-01T00:00)`,
+	} {
+		if _, err := parseFact(src); err == nil {
+			t.Errorf("invalid fact %q parsed", src)
+		}
+	}
+
+	if _, err := parseRule(`can_read($r) <- right($r, "read"), $r != "admin"`); err != nil {
+		t.Errorf("parseRule: %v", err)
+	}
+	for _, src := range []string{
+		`can_read($r) <- right($a, "read")`,
+		`can_read($r) right($r, "read")`,
+		`can_read($r) <-`,
+	} {
+		if _, err := parseRule(src); err == nil {
+			t.Errorf("invalid rule %q parsed", src)
+		}
+	}
+
+	for src, kind := range map[string]string{
+		`check if operation("read")`:                             "check",
+		`allow if right($r, $op), operation($op) or admin(true)`: "allow",
+		`deny if time($t), $t > 2030-01-01T00:00:00Z`:            "deny",
+	} {
+		q, err := parseQuery(src)
+		if err != nil {
+			t.Errorf("parseQuery(%q): %v", src, err)
+			continue
+		}
+		if q.kind != kind {
+			t.Errorf("got %s query, want %s", q.kind, kind)
+		}
+	}
+	for _, src := range []string{
+		`check operation("read")`,
+		`permit if operation("read")`,
+		`check if`,
+		`check if $a ~ 1`,
+	} {
+		if _, err := parseQuery(src); err == nil {
+			t.Errorf("invalid query %q parsed", src)
+		}
+	}
+}
+
+func TestDatalogEvaluation(t *testing.T) {
+	facts := make(factSet)
+	for _, src := range []string{
+		`right("account/1", "read")`,
+		`right("account/2", "write")`,
+		`owner("alice", "account/2")`,
+		`amount(100)`,
+		`time(2026-01-01T00:00:00Z)`,
+	} {
+		fact, err := parseFact(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		facts.add(fact)
+	}
+	var rules []rule
+	for _, src := range []string{
+		`can_read($r) <- right($r, "read")`,
+		`can_read($r) <- owner($u, $r)`,
+	} {
+		r, err := parseRule(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules = append(rules, r)
+	}
+	facts, err := fixpoint(facts, rules, newDatalogBudget())
+	if err != nil {
+		t.Fatalf("fixpoint: %v", err)
+	}
+
+	for src, matches := range map[string]bool{
+		`check if can_read("account/1")`:                              true,
+		`check if can_read("account/2")`:                              true,
+		`check if can_read("account/3")`:                              false,
+		`check if right($r, "write"), owner("alice", $r)`:             true,
+		`check if right($r, "read"), owner("alice", $r)`:              false,
+		`check if amount($a), $a <= 100`:                              true,
+		`check if amount($a), $a > 100`:                               false,
+		`check if amount($a), $a == "100"`:                            false,
+		`check if time($t), $t < 2030-01-01T00:00:00Z`:                true,
+		`check if time($t), $t < 2025-01-01T00:00:00Z`:                false,
+		`check if time($t), $t < 2025-01-01T00:00:00Z or amount(100)`: true,
+	} {
+		q, err := parseQuery(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ok, err := q.matches(facts, newDatalogBudget())
+		if err != nil {
+			t.Fatalf("%s: %v", src, err)
+		}
+		if ok != matches {
+			t.Errorf("%s: got %v, want %v", src, ok, matches)
+		}
+	}
+}
+
+func TestDatalogLimits(t *testing.T) {
+	facts := make(factSet)
+	for i := 0; i < 40; i++ {
+		facts.add(predicate{name: "n", terms: []interface{}{int64(i)}})
+	}
+	r, err := parseRule(`pair($a, $b) <- n($a), n($b)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fixpoint(facts, []rule{r}, newDatalogBudget()); err == nil {
+		t.Errorf("fixpoint produced more than %d facts", datalogMaxFacts)
+	}
+
+	// 40^4 bindings, unless the expression filters them early
+	for src, bounded := range map[string]bool{
+		`check if n($a), n($b), n($c), n($d), $d == -1`: false,
+		`check if n($a), n($b), n($c), n($d), $a == -1`: true,
+	} {
+		q, err := parseQuery(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := q.matches(facts, newDatalogBudget()); (err == nil) != bounded {
+			t.Errorf("%s: got error %v", src, err)
+		}
+	}
+
+	// the authorizer time is compared as a date
+	a := NewAuthorizer()
+	a.AddTime(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	q, err := parseQuery(`check if time($t), $t == 2026-01-01T00:00:00Z`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := q.matches(a.facts, newDatalogBudget()); !ok {
+		t.Error("time fact not matched")
+	}
+}
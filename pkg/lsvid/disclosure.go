@@ -0,0 +1,233 @@
+package lsvid
+
+import (
+	"crypto/rand"
+	hash256 "crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// Claims that can be concealed with Conceal.
+const (
+	ClaimDpa = "dpa"
+	ClaimDpr = "dpr"
+	ClaimSel = "sel"
+)
+
+// Disclosure reveals the value of a concealed claim, as in SD-JWT:
+// the encoded disclosure is the base64url encoded JSON array [salt, name, value],
+// and its digest is the base64url encoded SHA-256 of the encoded disclosure.
+type Disclosure struct {
+	Salt    string
+	Name    string
+	Value   interface{}
+	Encoded string
+}
+
+// Digest returns the digest signed in place of the disclosed claim.
+func (d *Disclosure) Digest() string {
+	return disclosureDigest(d.Encoded)
+}
+
+// NewDisclosure creates a disclosure of the claim with a random salt.
+func NewDisclosure(name string, value interface{}) (*Disclosure, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("Error generating salt: %v\n", err)
+	}
+	d := &Disclosure{
+		Salt:  base64.RawURLEncoding.EncodeToString(salt),
+		Name:  name,
+		Value: value,
+	}
+
+	disclosureJSON, err := json.Marshal([]interface{}{d.Salt, d.Name, d.Value})
+	if err != nil {
+		return nil, fmt.Errorf("Error generating json: %v\n", err)
+	}
+	d.Encoded = base64.RawURLEncoding.EncodeToString(disclosureJSON)
+
+	return d, nil
+}
+
+// ParseDisclosure decodes an encoded disclosure.
+func ParseDisclosure(encoded string) (*Disclosure, error) {
+	disclosureJSON, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("Error decoding disclosure: %v\n", err)
+	}
+
+	var elements []json.RawMessage
+	if err := json.Unmarshal(disclosureJSON, &elements); err != nil {
+		return nil, fmt.Errorf("Error unmarshaling disclosure: %v\n", err)
+	}
+	if len(elements) != 3 {
+		return nil, fmt.Errorf("Disclosure must have 3 elements\n")
+	}
+
+	d := &Disclosure{Encoded: encoded}
+	if err := json.Unmarshal(elements[0], &d.Salt); err != nil || d.Salt == "" {
+		return nil, fmt.Errorf("Invalid disclosure salt\n")
+	}
+	if err := json.Unmarshal(elements[1], &d.Name); err != nil || d.Name == "" {
+		return nil, fmt.Errorf("Invalid disclosure claim name\n")
+	}
+	if err := json.Unmarshal(elements[2], &d.Value); err != nil {
+		return nil, fmt.Errorf("Invalid disclosure value: %v\n", err)
+	}
+
+	return d, nil
+}
+
+// Conceal replaces the given claims of the payload (ClaimDpa, ClaimDpr or
+// ClaimSel) by their salted digests, before the payload is signed.
+// It returns the encoded disclosures, to be sent along with the LSVID (LSVID.Disclosures).
+// Empty claims are skipped.
+func Conceal(payload *Payload, claims ...string) ([]string, error) {
+	var disclosures []string
+	for _, claim := range claims {
+		var value interface{}
+		switch claim {
+		case ClaimDpa:
+			if payload.Dpa == "" {
+				continue
+			}
+			value = payload.Dpa
+		case ClaimDpr:
+			if payload.Dpr == "" {
+				continue
+			}
+			value = payload.Dpr
+		case ClaimSel:
+			if len(payload.Sel) == 0 {
+				continue
+			}
+			value = payload.Sel
+		default:
+			return nil, fmt.Errorf("Claim %q can not be concealed\n", claim)
+		}
+
+		d, err := NewDisclosure(claim, value)
+		if err != nil {
+			return nil, err
+		}
+		payload.Sd = append(payload.Sd, d.Digest())
+		disclosures = append(disclosures, d.Encoded)
+
+		switch claim {
+		case ClaimDpa:
+			payload.Dpa = ""
+		case ClaimDpr:
+			payload.Dpr = ""
+		case ClaimSel:
+			payload.Sel = nil
+		}
+	}
+
+	return disclosures, nil
+}
+
+// RetainDisclosures drops from the LSVID every disclosure not related to the
+// given claims, e.g. before forwarding it to a hop that only needs the user
+// principal: RetainDisclosures(lsvid, ClaimDpr).
+func RetainDisclosures(lsvid *LSVID, claims ...string) error {
+	var retained []string
+	for _, encoded := range lsvid.Disclosures {
+		d, err := ParseDisclosure(encoded)
+		if err != nil {
+			return err
+		}
+		if containsString(claims, d.Name) {
+			retained = append(retained, encoded)
+		}
+	}
+	lsvid.Disclosures = retained
+
+	return nil
+}
+
+// MatchDisclosures checks each disclosure against the digests signed in the
+// LSVID hops. It returns the disclosures by the payload that signed their digest.
+// It does not validate the LSVID signatures: use Validate with WithDisclosures.
+func MatchDisclosures(lsvid *Token, disclosures []string) (map[*Payload][]*Disclosure, error) {
+	digests := make(map[string]*Payload)
+	err := Walk(lsvid, func(token *Token, _ int) error {
+		if token.Payload == nil {
+			return nil
+		}
+		for _, digest := range token.Payload.Sd {
+			if _, ok := digests[digest]; ok {
+				return fmt.Errorf("Duplicated disclosure digest %s\n", digest)
+			}
+			digests[digest] = token.Payload
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	matched := make(map[*Payload][]*Disclosure)
+	seen := make(map[string]bool)
+	for _, encoded := range disclosures {
+		d, err := ParseDisclosure(encoded)
+		if err != nil {
+			return nil, err
+		}
+		digest := d.Digest()
+		payload, ok := digests[digest]
+		if !ok {
+			return nil, fmt.Errorf("Disclosure of %q does not match any signed digest\n", d.Name)
+		}
+		if seen[digest] {
+			return nil, fmt.Errorf("Disclosure of %q presented twice\n", d.Name)
+		}
+		seen[digest] = true
+		matched[payload] = append(matched[payload], d)
+	}
+	if len(disclosures) > 0 {
+		fmt.Printf("Disclosures validation successful!\n")
+	}
+
+	return matched, nil
+}
+
+// Disclose returns a copy of the payload with the claims revealed by the given
+// disclosures, that must have been matched to the payload (MatchDisclosures).
+func Disclose(payload *Payload, disclosures []*Disclosure) (*Payload, error) {
+	revealed := *payload
+	for _, d := range disclosures {
+		if !containsString(payload.Sd, d.Digest()) {
+			return nil, fmt.Errorf("Disclosure of %q does not match the payload\n", d.Name)
+		}
+
+		switch d.Name {
+		case ClaimDpa, ClaimDpr:
+			value, ok := d.Value.(string)
+			if !ok {
+				return nil, fmt.Errorf("Invalid %q disclosure value\n", d.Name)
+			}
+			if d.Name == ClaimDpa {
+				revealed.Dpa = value
+			} else {
+				revealed.Dpr = value
+			}
+		case ClaimSel:
+			value, ok := d.Value.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("Invalid %q disclosure value\n", d.Name)
+			}
+			revealed.Sel = value
+		default:
+			return nil, fmt.Errorf("Unknown disclosed claim %q\n", d.Name)
+		}
+	}
+
+	return &revealed, nil
+}
+
+func disclosureDigest(encoded string) string {
+	hash := hash256.Sum256([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
@@ -0,0 +1,123 @@
+package lsvid
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+)
+
+func TestDisclosureDigest(t *testing.T) {
+	// SD-JWT (draft-ietf-oauth-selective-disclosure-jwt) example disclosure
+	encoded := "WyI2cU1RdlJMNWhhaiIsICJmYW1pbHlfbmFtZSIsICJNw7ZiaXVzIl0"
+	d, err := ParseDisclosure(encoded)
+	if err != nil {
+		t.Fatalf("ParseDisclosure: %v", err)
+	}
+	if d.Salt != "6qMQvRL5haj" || d.Name != "family_name" || d.Value != "Möbius" {
+		t.Errorf("unexpected disclosure [%q, %q, %v]", d.Salt, d.Name, d.Value)
+	}
+	if digest := d.Digest(); digest != "uutlBuYeMDyjLLTpf6Jxi7yNkEF35jdyWMn9U7b_RYY" {
+		t.Errorf("got digest %s", digest)
+	}
+
+	// round trip
+	d, err = NewDisclosure(ClaimDpr, "alice")
+	if err != nil {
+		t.Fatalf("NewDisclosure: %v", err)
+	}
+	parsed, err := ParseDisclosure(d.Encoded)
+	if err != nil {
+		t.Fatalf("ParseDisclosure: %v", err)
+	}
+	if parsed.Salt != d.Salt || parsed.Name != ClaimDpr || parsed.Value != "alice" || parsed.Digest() != d.Digest() {
+		t.Errorf("disclosure changed by round trip: %+v", parsed)
+	}
+
+	for _, invalid := range [][]interface{}{
+		{"salt", "dpr"},
+		{"", "dpr", "alice"},
+		{"salt", "", "alice"},
+	} {
+		disclosureJSON, err := json.Marshal(invalid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ParseDisclosure(base64.RawURLEncoding.EncodeToString(disclosureJSON)); err == nil {
+			t.Errorf("invalid disclosure %v accepted", invalid)
+		}
+	}
+}
+
+func TestConcealedClaims(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	path := ca.newPath(t, "/asserting-wl", "/middle-tier")
+	asserting := path[0]
+
+	var disclosures []string
+	lsvid := ca.newChain(t, path, func(_ int, payload *Payload) {
+		payload.Dpa = asserting.ID.String()
+		payload.Dpr = "alice"
+		payload.Sel = map[string]interface{}{"uid": "1000"}
+		var err error
+		if disclosures, err = Conceal(payload, ClaimDpa, ClaimDpr, ClaimSel); err != nil {
+			t.Fatalf("Conceal: %v", err)
+		}
+		if payload.Dpa != "" || payload.Dpr != "" || payload.Sel != nil || len(payload.Sd) != 3 || len(disclosures) != 3 {
+			t.Fatalf("claims not concealed: %+v", payload)
+		}
+		if _, err := Conceal(payload, "aud"); err == nil {
+			t.Error("aud concealed")
+		}
+	})
+	lsvid.Disclosures = disclosures
+	checkValidate(t, lsvid.Token, true, WithDisclosures(lsvid.Disclosures))
+
+	// disclosures reveal the signed claims
+	matched, err := MatchDisclosures(lsvid.Token, lsvid.Disclosures)
+	if err != nil {
+		t.Fatalf("MatchDisclosures: %v", err)
+	}
+	revealed, err := Disclose(lsvid.Token.Payload, matched[lsvid.Token.Payload])
+	if err != nil {
+		t.Fatalf("Disclose: %v", err)
+	}
+	if revealed.Dpa != asserting.ID.String() || revealed.Dpr != "alice" || revealed.Sel["uid"] != "1000" {
+		t.Errorf("unexpected disclosed claims %+v", revealed)
+	}
+
+	// only the retained disclosures are forwarded
+	if err := RetainDisclosures(lsvid, ClaimDpr); err != nil {
+		t.Fatalf("RetainDisclosures: %v", err)
+	}
+	if len(lsvid.Disclosures) != 1 {
+		t.Fatalf("got %d disclosures, want 1", len(lsvid.Disclosures))
+	}
+	checkValidate(t, lsvid.Token, true, WithDisclosures(lsvid.Disclosures))
+
+	// disclosures with another value, presented twice or unrelated are rejected
+	dpr, err := ParseDisclosure(lsvid.Disclosures[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	disclosureJSON, err := json.Marshal([]interface{}{dpr.Salt, ClaimDpr, "mallory"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := base64.RawURLEncoding.EncodeToString(disclosureJSON)
+	unrelated, err := NewDisclosure(ClaimDpr, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, presented := range map[string][]string{
+		"tampered":  {forged},
+		"twice":     {dpr.Encoded, dpr.Encoded},
+		"unrelated": {unrelated.Encoded},
+	} {
+		t.Run(name, func(t *testing.T) {
+			checkValidate(t, lsvid.Token, false, WithDisclosures(presented))
+		})
+	}
+	if _, err := Disclose(lsvid.Token.Payload, []*Disclosure{unrelated}); err == nil {
+		t.Error("unrelated disclosure revealed")
+	}
+}
@@ -0,0 +1,203 @@
+package lsvid
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	hash256 "crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+)
+
+// Key agreement and content encryption algorithms of encrypted claims, as in JWE (RFC 7518).
+const (
+	EncAlgECDHES = "ECDH-ES"
+	EncA256GCM   = "A256GCM"
+)
+
+// EncryptedClaims is a claim set encrypted to a single recipient, JWE-style:
+// the content key is agreed by ECDH-ES between an ephemeral key and the recipient
+// key, derived with the Concat KDF, and used to encrypt the claims with AES-GCM.
+type EncryptedClaims struct {
+	Alg string `json:"alg"`
+	Enc string `json:"enc"`
+	Rcp string `json:"rcp"` // recipient SPIFFE ID
+	Epk []byte `json:"epk"` // PKIX DER encoded ephemeral public key
+	Iv  []byte `json:"iv"`
+	Ct  []byte `json:"ct"` // ciphertext followed by the GCM tag
+}
+
+// EncryptClaims encrypts the claims to the recipient public key (e.g., the key of
+// the audience X509-SVID). Only ECDSA recipient keys are supported.
+func EncryptClaims(claims interface{}, recipient string, recipientKey crypto.PublicKey) (*EncryptedClaims, error) {
+	pub, ok := recipientKey.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("Unsupported recipient key type %T\n", recipientKey)
+	}
+
+	ephemeral, err := ecdsa.GenerateKey(pub.Curve, rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("Error generating ephemeral key: %v\n", err)
+	}
+	epk, err := x509.MarshalPKIXPublicKey(ephemeral.Public())
+	if err != nil {
+		return nil, fmt.Errorf("Error encoding ephemeral key: %v\n", err)
+	}
+
+	enc := &EncryptedClaims{
+		Alg: EncAlgECDHES,
+		Enc: EncA256GCM,
+		Rcp: recipient,
+		Epk: epk,
+	}
+
+	aead, err := newClaimsAEAD(ephemeral, pub)
+	if err != nil {
+		return nil, err
+	}
+	enc.Iv = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(enc.Iv); err != nil {
+		return nil, fmt.Errorf("Error generating IV: %v\n", err)
+	}
+
+	plaintext, err := json.Marshal(claims)
+	if err != nil {
+		return nil, fmt.Errorf("Error generating json: %v\n", err)
+	}
+	aad, err := enc.aad()
+	if err != nil {
+		return nil, err
+	}
+	enc.Ct = aead.Seal(nil, enc.Iv, plaintext, aad)
+
+	return enc, nil
+}
+
+// EncryptForAudience encrypts the claims to the payload audience and sets them in the payload.
+func EncryptForAudience(payload *Payload, claims interface{}, audienceKey crypto.PublicKey) error {
+	if payload.Aud == nil || payload.Aud.CN == "" {
+		return fmt.Errorf("Payload audience is required\n")
+	}
+
+	enc, err := EncryptClaims(claims, payload.Aud.CN, audienceKey)
+	if err != nil {
+		return err
+	}
+	payload.Enc = enc
+
+	return nil
+}
+
+// DecryptClaims decrypts the claims using the recipient X509-SVID private key,
+// and unmarshals them into claims.
+func DecryptClaims(enc *EncryptedClaims, svid *x509svid.SVID, claims interface{}) error {
+	if enc == nil {
+		return fmt.Errorf("No encrypted claims\n")
+	}
+	if enc.Alg != EncAlgECDHES || enc.Enc != EncA256GCM {
+		return fmt.Errorf("Unsupported encryption %s/%s\n", enc.Alg, enc.Enc)
+	}
+	if svid.ID.String() != enc.Rcp {
+		return fmt.Errorf("Claims encrypted to %s, not to %s\n", enc.Rcp, svid.ID)
+	}
+	priv, ok := svid.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return fmt.Errorf("Unsupported recipient key type %T\n", svid.PrivateKey)
+	}
+
+	epk, err := x509.ParsePKIXPublicKey(enc.Epk)
+	if err != nil {
+		return fmt.Errorf("Failed to parse ephemeral key: %v\n", err)
+	}
+	ephemeral, ok := epk.(*ecdsa.PublicKey)
+	if !ok || ephemeral.Curve != priv.Curve {
+		return fmt.Errorf("Invalid ephemeral key\n")
+	}
+
+	aead, err := newClaimsAEAD(priv, ephemeral)
+	if err != nil {
+		return err
+	}
+	aad, err := enc.aad()
+	if err != nil {
+		return err
+	}
+	plaintext, err := aead.Open(nil, enc.Iv, enc.Ct, aad)
+	if err != nil {
+		return fmt.Errorf("Error decrypting claims: %v\n", err)
+	}
+
+	if err := json.Unmarshal(plaintext, claims); err != nil {
+		return fmt.Errorf("Error unmarshaling claims: %v\n", err)
+	}
+	return nil
+}
+
+// aad returns the additional authenticated data: the JSON encoded
+// header (alg, enc, rcp and epk), as the JWE protected header.
+func (enc *EncryptedClaims) aad() ([]byte, error) {
+	header := &EncryptedClaims{
+		Alg: enc.Alg,
+		Enc: enc.Enc,
+		Rcp: enc.Rcp,
+		Epk: enc.Epk,
+	}
+	aad, err := json.Marshal(header)
+	if err != nil {
+		return nil, fmt.Errorf("Error generating json: %v\n", err)
+	}
+	return aad, nil
+}
+
+// newClaimsAEAD agrees the content key between priv and pub and returns the AES-GCM cipher.
+func newClaimsAEAD(priv *ecdsa.PrivateKey, pub *ecdsa.PublicKey) (cipher.AEAD, error) {
+	privECDH, err := priv.ECDH()
+	if err != nil {
+		return nil, fmt.Errorf("Unsupported private key: %v\n", err)
+	}
+	pubECDH, err := pub.ECDH()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid public key: %v\n", err)
+	}
+	z, err := privECDH.ECDH(pubECDH)
+	if err != nil {
+		return nil, fmt.Errorf("Error agreeing content key: %v\n", err)
+	}
+
+	block, err := aes.NewCipher(concatKDF(z, EncA256GCM, 256))
+	if err != nil {
+		return nil, fmt.Errorf("Error creating cipher: %v\n", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// concatKDF derives a key from the shared secret z as in RFC 7518 Section 4.6.2,
+// with empty PartyUInfo and PartyVInfo.
+func concatKDF(z []byte, algID string, keyBits int) []byte {
+	var otherInfo []byte
+	otherInfo = appendLengthPrefixed(otherInfo, []byte(algID))
+	otherInfo = appendLengthPrefixed(otherInfo, nil) // PartyUInfo
+	otherInfo = appendLengthPrefixed(otherInfo, nil) // PartyVInfo
+	otherInfo = binary.BigEndian.AppendUint32(otherInfo, uint32(keyBits))
+
+	var key []byte
+	for counter := uint32(1); len(key) < keyBits/8; counter++ {
+		h := hash256.New()
+		binary.Write(h, binary.BigEndian, counter)
+		h.Write(z)
+		h.Write(otherInfo)
+		key = h.Sum(key)
+	}
+	return key[:keyBits/8]
+}
+
+func appendLengthPrefixed(b []byte, data []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(data)))
+	return append(b, data...)
+}
@@ -0,0 +1,131 @@
+package lsvid
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"testing"
+)
+
+type testClaims struct {
+	Account string `json:"account"`
+	Amount  int    `json:"amount"`
+}
+
+func TestConcatKDF(t *testing.T) {
+	z := make([]byte, 32)
+	for i := range z {
+		z[i] = byte(i)
+	}
+	// SHA-256(counter || Z || AlgorithmID || PartyUInfo || PartyVInfo || SuppPubInfo), RFC 7518 Section 4.6.2
+	want := "9c69e3c7102763b0078e554d3301fa1ab2c55a821fd6b2132b41aa495f0b302e"
+	if key := hex.EncodeToString(concatKDF(z, EncA256GCM, 256)); key != want {
+		t.Errorf("got key %s, want %s", key, want)
+	}
+}
+
+func TestEncryptClaims(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	target := ca.newSVID(t, "/target-wl")
+	other := ca.newSVID(t, "/other-wl")
+
+	claims := &testClaims{Account: "1", Amount: 100}
+	enc, err := EncryptClaims(claims, target.ID.String(), target.Certificates[0].PublicKey)
+	if err != nil {
+		t.Fatalf("EncryptClaims: %v", err)
+	}
+	if enc.Alg != EncAlgECDHES || enc.Enc != EncA256GCM {
+		t.Errorf("unexpected algorithms %s/%s", enc.Alg, enc.Enc)
+	}
+
+	var decrypted testClaims
+	if err := DecryptClaims(enc, target, &decrypted); err != nil {
+		t.Fatalf("DecryptClaims: %v", err)
+	}
+	if decrypted != *claims {
+		t.Errorf("got claims %+v, want %+v", decrypted, *claims)
+	}
+
+	// only the recipient decrypts
+	if err := DecryptClaims(enc, other, &decrypted); err == nil {
+		t.Error("claims decrypted by another workload")
+	}
+	impostor := *other
+	impostor.ID = target.ID
+	if err := DecryptClaims(enc, &impostor, &decrypted); err == nil {
+		t.Error("claims decrypted with another key")
+	}
+
+	// the ciphertext and header are authenticated
+	for name, tamper := range map[string]func(enc *EncryptedClaims){
+		"ct":  func(enc *EncryptedClaims) { enc.Ct[0] ^= 0xff },
+		"iv":  func(enc *EncryptedClaims) { enc.Iv[0] ^= 0xff },
+		"rcp": func(enc *EncryptedClaims) { enc.Rcp = target.ID.String() + "/" },
+		"epk": func(enc *EncryptedClaims) { enc.Epk = newTestEphemeralKey(t) },
+		"enc": func(enc *EncryptedClaims) { enc.Enc = "A128GCM" },
+	} {
+		tampered, err := EncryptClaims(claims, target.ID.String(), target.Certificates[0].PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tamper(tampered)
+		if err := DecryptClaims(tampered, target, &decrypted); err == nil {
+			t.Errorf("claims with tampered %s decrypted", name)
+		}
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := EncryptClaims(claims, target.ID.String(), rsaKey.Public()); err == nil {
+		t.Error("claims encrypted to an RSA key")
+	}
+}
+
+func TestEncryptForAudience(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	asserting := ca.newSVID(t, "/asserting-wl")
+	target := ca.newSVID(t, "/target-wl")
+
+	payload := ca.newPayload(t, asserting, target.ID.String())
+	claims := &testClaims{Account: "1", Amount: 100}
+	if err := EncryptForAudience(payload, claims, target.Certificates[0].PublicKey); err != nil {
+		t.Fatalf("EncryptForAudience: %v", err)
+	}
+	lsvid := extend(t, &LSVID{Token: ca.newLSVID(t, asserting)}, payload, asserting)
+	if valid, err := Validate(lsvid.Token); !valid {
+		t.Fatalf("LSVID with encrypted claims rejected: %v", err)
+	}
+
+	var decrypted testClaims
+	if err := DecryptClaims(lsvid.Token.Payload.Enc, target, &decrypted); err != nil || decrypted != *claims {
+		t.Errorf("DecryptClaims: %+v, %v", decrypted, err)
+	}
+
+	// the encrypted claims are signed by the hop
+	lsvid.Token.Payload.Enc.Ct[0] ^= 0xff
+	if valid, _ := Validate(lsvid.Token); valid {
+		t.Error("LSVID with tampered encrypted claims accepted")
+	}
+
+	payload.Aud = nil
+	if err := EncryptForAudience(payload, claims, target.Certificates[0].PublicKey); err == nil {
+		t.Error("claims encrypted without audience")
+	}
+}
+
+func newTestEphemeralKey(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	epk, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return epk
+}
@@ -1,6 +1,6 @@
 module github.com/hpe-usp-spire/signed-assertions/lsvid
 
-go 1.19
+go 1.20
 
 require (
 	github.com/hpe-usp-spire/signed-assertions/poclib v0.0.0-20231027162922-104e2990cc5c
@@ -0,0 +1,565 @@
+package lsvid
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	hash256 "crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+)
+
+// HTTP message signatures (RFC 9421) of the requests carrying LSVIDs, signed with
+// the sender X509-SVID key. The keyid is the SPIFFE ID of the outermost hop issuer,
+// and the key is taken from its issuer LSVID (Iss.ID).
+const (
+	SignatureLabel = "lsvid"
+
+	SigAlgECDSAP256 = "ecdsa-p256-sha256"
+	SigAlgRSAV15    = "rsa-v1_5-sha256"
+
+	// The body digest header (RFC 9530), added to the default components of requests with body.
+	componentContentDigest = "content-digest"
+)
+
+var defaultSignatureComponents = []string{ComponentMethod, ComponentAuthority, ComponentPath, ComponentQuery}
+
+// SignRequest signs the request with the key of the outermost hop issuer of the LSVID
+// sent with it, setting the Signature-Input and Signature headers. If no components are
+// given, the method, authority, path, query and the body digest are covered.
+func SignRequest(r *http.Request, lsvid *LSVID, key crypto.Signer, components ...string) error {
+	if lsvid == nil || lsvid.Token == nil || lsvid.Token.Payload == nil || lsvid.Token.Payload.Iss == nil {
+		return fmt.Errorf("Invalid LSVID\n")
+	}
+	if len(components) == 0 {
+		components = append(components, defaultSignatureComponents...)
+		if r.Body != nil && r.Body != http.NoBody {
+			components = append(components, componentContentDigest)
+		}
+	}
+
+	for _, component := range components {
+		if component == componentContentDigest && r.Header.Get("Content-Digest") == "" {
+			digest, err := contentDigest(r)
+			if err != nil {
+				return err
+			}
+			r.Header.Set("Content-Digest", digest)
+		}
+	}
+
+	var alg string
+	switch pub := key.Public().(type) {
+	case *ecdsa.PublicKey:
+		if pub.Curve != elliptic.P256() {
+			return fmt.Errorf("Unsupported curve %s\n", pub.Curve.Params().Name)
+		}
+		alg = SigAlgECDSAP256
+	case *rsa.PublicKey:
+		alg = SigAlgRSAV15
+	default:
+		return fmt.Errorf("Unsupported key type %T\n", pub)
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("Error generating nonce: %v\n", err)
+	}
+	input := &signatureInput{
+		components: components,
+		created:    time.Now().Unix(),
+		keyID:      lsvid.Token.Payload.Iss.CN,
+		alg:        alg,
+		nonce:      base64.RawURLEncoding.EncodeToString(nonce),
+	}
+
+	base, err := signatureBase(r, input)
+	if err != nil {
+		return err
+	}
+	hash := hash256.Sum256([]byte(base))
+	s, err := key.Sign(rand.Reader, hash[:], crypto.SHA256)
+	if err != nil {
+		return fmt.Errorf("Error signing request: %v\n", err)
+	}
+	if alg == SigAlgECDSAP256 {
+		// RFC 9421 ECDSA signatures are the concatenation of r and s
+		if s, err = ecdsaRawSignature(s); err != nil {
+			return err
+		}
+	}
+
+	r.Header.Set("Signature-Input", SignatureLabel+"="+input.String())
+	r.Header.Set("Signature", SignatureLabel+"=:"+base64.StdEncoding.EncodeToString(s)+":")
+	return nil
+}
+
+// HTTPSignatureVerifier verifies the message signatures of received requests.
+// It rejects signatures older than MaxAge and nonces already seen in that period.
+type HTTPSignatureVerifier struct {
+	MaxAge time.Duration
+
+	// Components every signature must cover: the method, authority, path and
+	// query by default. The body digest is also required for requests with a body.
+	Components []string
+
+	// Resolver of the signer keys referenced by JWK thumbprint, if any.
+	Resolver KeyResolver
+
+	mtx    sync.Mutex
+	nonces map[string]time.Time
+}
+
+// NewHTTPSignatureVerifier creates a verifier accepting signatures created up to maxAge ago.
+func NewHTTPSignatureVerifier(maxAge time.Duration) *HTTPSignatureVerifier {
+	return &HTTPSignatureVerifier{
+		MaxAge:     maxAge,
+		Components: defaultSignatureComponents,
+		nonces:     make(map[string]time.Time),
+	}
+}
+
+// Verify checks that the request was signed by the outermost hop issuer of the LSVID sent with it,
+// covering the verifier Components and the body digest of requests with a body.
+// When the request was received over mTLS, the client SPIFFE ID must also match the signer.
+// The LSVID itself must be validated with Validate.
+func (v *HTTPSignatureVerifier) Verify(r *http.Request, lsvid *LSVID) error {
+	inputs, err := parseSignatureInputs(r.Header.Get("Signature-Input"))
+	if err != nil {
+		return err
+	}
+	input, ok := inputs[SignatureLabel]
+	if !ok {
+		return fmt.Errorf("Request has no %s signature\n", SignatureLabel)
+	}
+	signature, err := parseSignature(r.Header.Get("Signature"), SignatureLabel)
+	if err != nil {
+		return err
+	}
+
+	// Cross-check the signer with the LSVID and the mTLS client
+	if lsvid == nil || lsvid.Token == nil || lsvid.Token.Payload == nil || lsvid.Token.Payload.Iss == nil {
+		return fmt.Errorf("Invalid LSVID\n")
+	}
+	issuer := lsvid.Token.Payload.Iss
+	if input.keyID != issuer.CN {
+		return fmt.Errorf("Request signed by %s, but LSVID issued by %s\n", input.keyID, issuer.CN)
+	}
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		clientID, err := x509svid.IDFromCert(r.TLS.PeerCertificates[0])
+		if err != nil {
+			return fmt.Errorf("Error retrieving client SPIFFE-ID: %v\n", err)
+		}
+		if clientID.String() != input.keyID {
+			return fmt.Errorf("Request signed by %s, but sent by %s\n", input.keyID, clientID)
+		}
+	}
+	if issuer.ID == nil {
+		return fmt.Errorf("Issuer LSVID not found for %s\n", issuer.CN)
+	}
+	issLSVID := innermost(issuer.ID)
+	if issLSVID.Payload == nil || issLSVID.Payload.Sub == nil || issLSVID.Payload.Sub.CN != issuer.CN {
+		return fmt.Errorf("Issuer LSVID subject does not match %s\n", issuer.CN)
+	}
+
+	// Replay protection
+	now := time.Now()
+	created := time.Unix(input.created, 0)
+	if input.created == 0 || input.nonce == "" {
+		return fmt.Errorf("Signature has no created or nonce parameter\n")
+	}
+	if now.Sub(created) > v.MaxAge || created.Sub(now) > time.Minute {
+		return fmt.Errorf("Signature created at %s is out of the accepted window\n", created)
+	}
+
+	// Covered components and body integrity
+	covered := make(map[string]bool)
+	for _, component := range input.components {
+		covered[component] = true
+	}
+	for _, component := range v.Components {
+		if !covered[component] {
+			return fmt.Errorf("Request %s is not covered by the signature\n", component)
+		}
+	}
+	if covered[componentContentDigest] {
+		digest, err := contentDigest(r)
+		if err != nil {
+			return err
+		}
+		if r.Header.Get("Content-Digest") != digest {
+			return fmt.Errorf("Content-Digest does not match the body\n")
+		}
+	} else if r.ContentLength != 0 && r.Body != nil && r.Body != http.NoBody {
+		return fmt.Errorf("Request body is not covered by the signature\n")
+	}
+
+	pk, err := claimKey(issLSVID.Payload.Sub, v.Resolver)
+	if err != nil {
+		return err
+	}
+	base, err := signatureBase(r, input)
+	if err != nil {
+		return err
+	}
+	hash := hash256.Sum256([]byte(base))
+	switch pub := pk.(type) {
+	case *ecdsa.PublicKey:
+		if input.alg != SigAlgECDSAP256 || len(signature) != 64 {
+			return fmt.Errorf("Invalid %s signature\n", input.alg)
+		}
+		sr, ss := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(pub, hash[:], sr, ss) {
+			return fmt.Errorf("Request signature validation failed\n")
+		}
+	case *rsa.PublicKey:
+		if input.alg != SigAlgRSAV15 {
+			return fmt.Errorf("Invalid %s signature\n", input.alg)
+		}
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, hash[:], signature); err != nil {
+			return fmt.Errorf("Request signature validation failed\n")
+		}
+	default:
+		return fmt.Errorf("Unsupported key type %T\n", pub)
+	}
+
+	// Only valid signatures consume their nonce
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+	for nonce, expires := range v.nonces {
+		if now.After(expires) {
+			delete(v.nonces, nonce)
+		}
+	}
+	if _, seen := v.nonces[input.keyID+" "+input.nonce]; seen {
+		return fmt.Errorf("Signature nonce already used\n")
+	}
+	v.nonces[input.keyID+" "+input.nonce] = created.Add(v.MaxAge)
+
+	return nil
+}
+
+// Middleware verifies the message signature of the requests before calling next.
+// extract returns the LSVID sent with the request, unless an outer middleware already
+// extracted it; the request body is restored for next, that gets the LSVID with
+// LSVIDFromContext.
+func (v *HTTPSignatureVerifier) Middleware(extract func(r *http.Request) (*LSVID, error), next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		restore, ok := bufferBody(w, r)
+		if !ok {
+			return
+		}
+
+		lsvid, err := extractLSVID(r, extract)
+		if err != nil {
+			log.Printf("Error extracting LSVID: %v", err)
+			http.Error(w, "invalid LSVID", http.StatusUnauthorized)
+			return
+		}
+		restore()
+		if err := v.Verify(r, lsvid); err != nil {
+			log.Printf("Error verifying request signature: %v", err)
+			http.Error(w, "invalid request signature", http.StatusUnauthorized)
+			return
+		}
+		restore()
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), lsvidContextKey{}, lsvid)))
+	})
+}
+
+type lsvidContextKey struct{}
+
+// LSVIDFromContext returns the LSVID extracted from the request by Middleware or
+// TraceMiddleware, so the handler does not decode it again.
+func LSVIDFromContext(ctx context.Context) (*LSVID, bool) {
+	lsvid, ok := ctx.Value(lsvidContextKey{}).(*LSVID)
+	return lsvid, ok
+}
+
+// extractLSVID returns the LSVID extracted by an outer middleware, or calls extract.
+func extractLSVID(r *http.Request, extract func(r *http.Request) (*LSVID, error)) (*LSVID, error) {
+	if lsvid, ok := LSVIDFromContext(r.Context()); ok {
+		return lsvid, nil
+	}
+	return extract(r)
+}
+
+// maxBufferedBody is the maximum size of the request bodies buffered by the middlewares.
+const maxBufferedBody = 1 << 20
+
+// bufferBody reads the request body, up to maxBufferedBody bytes, and returns a
+// function restoring it to be read again. The body is restored once read. On
+// error, it replies to the request and returns false.
+func bufferBody(w http.ResponseWriter, r *http.Request) (func(), bool) {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = io.ReadAll(http.MaxBytesReader(w, r.Body, maxBufferedBody))
+		r.Body.Close()
+		if err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				http.Error(w, "body too large", http.StatusRequestEntityTooLarge)
+			} else {
+				http.Error(w, "unable to read body", http.StatusBadRequest)
+			}
+			return nil, false
+		}
+	}
+
+	restore := func() {
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	restore()
+	return restore, true
+}
+
+// signatureInput holds the signature parameters (RFC 9421, Section 2.3).
+type signatureInput struct {
+	components []string
+	created    int64
+	keyID      string
+	alg        string
+	nonce      string
+}
+
+// String serializes the signature parameters as a structured field inner list.
+func (s *signatureInput) String() string {
+	quoted := make([]string, len(s.components))
+	for i, component := range s.components {
+		quoted[i] = strconv.Quote(component)
+	}
+	return fmt.Sprintf("(%s);created=%d;keyid=%q;alg=%q;nonce=%q", strings.Join(quoted, " "), s.created, s.keyID, s.alg, s.nonce)
+}
+
+// signatureBase creates the signature base (RFC 9421, Section 2.5).
+func signatureBase(r *http.Request, input *signatureInput) (string, error) {
+	var base strings.Builder
+	for _, component := range input.components {
+		var value string
+		switch component {
+		case ComponentMethod:
+			value = r.Method
+		case ComponentAuthority:
+			value = r.Host
+			if value == "" {
+				value = r.URL.Host
+			}
+			value = strings.ToLower(value)
+		case ComponentPath:
+			value = r.URL.EscapedPath()
+			if value == "" {
+				value = "/"
+			}
+		case ComponentQuery:
+			value = "?" + r.URL.RawQuery
+		default:
+			if strings.HasPrefix(component, "@") || component != strings.ToLower(component) {
+				return "", fmt.Errorf("Unsupported signature component %q\n", component)
+			}
+			fields := r.Header.Values(component)
+			if len(fields) == 0 {
+				return "", fmt.Errorf("Signed header %s not found\n", component)
+			}
+			values := make([]string, len(fields))
+			for i, field := range fields {
+				values[i] = strings.TrimSpace(field)
+			}
+			value = strings.Join(values, ", ")
+		}
+		fmt.Fprintf(&base, "%q: %s\n", component, value)
+	}
+	fmt.Fprintf(&base, "%q: %s", "@signature-params", input.String())
+
+	return base.String(), nil
+}
+
+// contentDigest computes the Content-Digest header (RFC 9530) of the request body, restoring it.
+func contentDigest(r *http.Request) (string, error) {
+	var body []byte
+	var err error
+	switch {
+	case r.GetBody != nil:
+		var rc io.ReadCloser
+		if rc, err = r.GetBody(); err == nil {
+			body, err = io.ReadAll(rc)
+			rc.Close()
+		}
+	case r.Body != nil:
+		if body, err = io.ReadAll(r.Body); err == nil {
+			r.Body.Close()
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("Unable to read body: %v\n", err)
+	}
+
+	hash := hash256.Sum256(body)
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(hash[:]) + ":", nil
+}
+
+// ecdsaRawSignature converts an ASN.1 ECDSA P-256 signature to r || s.
+func ecdsaRawSignature(der []byte) ([]byte, error) {
+	var sig struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		return nil, fmt.Errorf("Invalid ECDSA signature: %v\n", err)
+	}
+	raw := make([]byte, 64)
+	sig.R.FillBytes(raw[:32])
+	sig.S.FillBytes(raw[32:])
+	return raw, nil
+}
+
+// parseSignatureInputs parses the Signature-Input dictionary.
+func parseSignatureInputs(header string) (map[string]*signatureInput, error) {
+	inputs := make(map[string]*signatureInput)
+	p := &sfParser{s: header}
+	for {
+		p.skipSpaces()
+		if p.done() {
+			return inputs, nil
+		}
+		label := p.token()
+		if label == "" || !p.consume('=') || !p.consume('(') {
+			return nil, fmt.Errorf("Invalid Signature-Input header\n")
+		}
+
+		input := &signatureInput{}
+		for {
+			p.skipSpaces()
+			if p.consume(')') {
+				break
+			}
+			component, ok := p.quoted()
+			if !ok {
+				return nil, fmt.Errorf("Invalid Signature-Input header\n")
+			}
+			input.components = append(input.components, component)
+		}
+		for p.consume(';') {
+			name := p.token()
+			if !p.consume('=') {
+				return nil, fmt.Errorf("Invalid Signature-Input parameter %s\n", name)
+			}
+			var value string
+			var ok bool
+			if value, ok = p.quoted(); !ok {
+				value = p.token()
+			}
+			switch name {
+			case "created":
+				created, err := strconv.ParseInt(value, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("Invalid created parameter: %v\n", err)
+				}
+				input.created = created
+			case "keyid":
+				input.keyID = value
+			case "alg":
+				input.alg = value
+			case "nonce":
+				input.nonce = value
+			default:
+				// other parameters (expires, tag) change the signature base, reject them
+				return nil, fmt.Errorf("Unsupported Signature-Input parameter %s\n", name)
+			}
+		}
+		inputs[label] = input
+
+		p.skipSpaces()
+		if !p.done() && !p.consume(',') {
+			return nil, fmt.Errorf("Invalid Signature-Input header\n")
+		}
+	}
+}
+
+// parseSignature returns the signature with the given label of the Signature dictionary.
+func parseSignature(header, label string) ([]byte, error) {
+	for _, member := range strings.Split(header, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(member), "=")
+		if !ok || name != label {
+			continue
+		}
+		if len(value) < 2 || value[0] != ':' || value[len(value)-1] != ':' {
+			return nil, fmt.Errorf("Invalid Signature header\n")
+		}
+		return base64.StdEncoding.DecodeString(value[1 : len(value)-1])
+	}
+	return nil, fmt.Errorf("Request has no %s signature\n", label)
+}
+
+// sfParser parses the structured field (RFC 8941) subset used by signature inputs.
+type sfParser struct {
+	s   string
+	pos int
+}
+
+func (p *sfParser) done() bool {
+	return p.pos >= len(p.s)
+}
+
+func (p *sfParser) skipSpaces() {
+	for !p.done() && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *sfParser) consume(c byte) bool {
+	if !p.done() && p.s[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *sfParser) token() string {
+	start := p.pos
+	for !p.done() && strings.IndexByte(" \t=;,()\"", p.s[p.pos]) < 0 {
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+func (p *sfParser) quoted() (string, bool) {
+	if !p.consume('"') {
+		return "", false
+	}
+	var value strings.Builder
+	for !p.done() {
+		c := p.s[p.pos]
+		p.pos++
+		switch c {
+		case '\\':
+			if p.done() {
+				return "", false
+			}
+			value.WriteByte(p.s[p.pos])
+			p.pos++
+		case '"':
+			return value.String(), true
+		default:
+			value.WriteByte(c)
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,146 @@
+package lsvid
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+)
+
+// newSignedRequest signs a request of the middle tier, and returns it as received by the target.
+func newSignedRequest(t *testing.T, lsvid *LSVID, signer *x509svid.SVID, method, url, body string, components ...string) *http.Request {
+	var sent *http.Request
+	if body == "" {
+		sent = httptest.NewRequest(method, url, nil)
+	} else {
+		sent = httptest.NewRequest(method, url, strings.NewReader(body))
+	}
+	if err := SignRequest(sent, lsvid, signer.PrivateKey, components...); err != nil {
+		t.Fatalf("SignRequest: %v", err)
+	}
+	received := httptest.NewRequest(method, url, strings.NewReader(body))
+	received.Header = sent.Header.Clone()
+	return received
+}
+
+func TestHTTPSignature(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	asserting := ca.newSVID(t, "/asserting-wl")
+	middle := ca.newSVID(t, "/middle-tier")
+	target := ca.newSVID(t, "/target-wl")
+	lsvid := ca.newChain(t, []*x509svid.SVID{asserting, middle, target}, nil)
+
+	verifier := NewHTTPSignatureVerifier(time.Minute)
+	const url, body = "https://target-wl:8443/deposit?amount=100", `{"account":"1"}`
+	r := newSignedRequest(t, lsvid, middle, http.MethodPost, url, body)
+	if err := verifier.Verify(r, lsvid); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if err := verifier.Verify(r, lsvid); err == nil {
+		t.Error("replayed signature verified")
+	}
+
+	// the request is covered by the signature
+	for name, tamper := range map[string]func(r *http.Request){
+		"method":    func(r *http.Request) { r.Method = http.MethodPut },
+		"authority": func(r *http.Request) { r.Host = "other-wl:8443" },
+		"path":      func(r *http.Request) { r.URL.Path = "/withdraw" },
+		"query":     func(r *http.Request) { r.URL.RawQuery = "amount=1000" },
+		"body":      func(r *http.Request) { r.Body = io.NopCloser(strings.NewReader(`{"account":"2"}`)) },
+	} {
+		r := newSignedRequest(t, lsvid, middle, http.MethodPost, url, body)
+		tamper(r)
+		if err := verifier.Verify(r, lsvid); err == nil {
+			t.Errorf("request with tampered %s verified", name)
+		}
+	}
+
+	// the signer must be the outermost hop issuer, and the mTLS client
+	if err := verifier.Verify(newSignedRequest(t, lsvid, asserting, http.MethodPost, url, body), lsvid); err == nil {
+		t.Error("request signed by another workload verified")
+	}
+	r = newSignedRequest(t, lsvid, middle, http.MethodPost, url, body)
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{asserting.Certificates[0]}}
+	if err := verifier.Verify(r, lsvid); err == nil {
+		t.Error("request sent by another mTLS client verified")
+	}
+	if err := NewHTTPSignatureVerifier(-time.Second).Verify(newSignedRequest(t, lsvid, middle, http.MethodPost, url, body), lsvid); err == nil {
+		t.Error("expired signature verified")
+	}
+}
+
+func TestHTTPSignatureComponents(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	asserting := ca.newSVID(t, "/asserting-wl")
+	middle := ca.newSVID(t, "/middle-tier")
+	target := ca.newSVID(t, "/target-wl")
+	lsvid := ca.newChain(t, []*x509svid.SVID{asserting, middle, target}, nil)
+
+	const url = "https://target-wl:8443/balance?account=1"
+	verifier := NewHTTPSignatureVerifier(time.Minute)
+	for _, components := range [][]string{
+		{ComponentMethod, ComponentAuthority, ComponentPath},
+		{ComponentMethod, ComponentPath, ComponentQuery},
+		{ComponentAuthority, ComponentPath, ComponentQuery},
+	} {
+		r := newSignedRequest(t, lsvid, middle, http.MethodGet, url, "", components...)
+		if err := verifier.Verify(r, lsvid); err == nil {
+			t.Errorf("signature covering %v verified", components)
+		}
+	}
+
+	// the required components are configurable
+	verifier.Components = []string{ComponentMethod, ComponentPath}
+	if err := verifier.Verify(newSignedRequest(t, lsvid, middle, http.MethodGet, url, "", ComponentMethod, ComponentPath), lsvid); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+
+	// but the body digest is always required for requests with a body
+	r := newSignedRequest(t, lsvid, middle, http.MethodPost, url, `{"amount":100}`, defaultSignatureComponents...)
+	if err := NewHTTPSignatureVerifier(time.Minute).Verify(r, lsvid); err == nil {
+		t.Error("signature without body digest verified")
+	}
+}
+
+func TestHTTPSignatureMiddleware(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	asserting := ca.newSVID(t, "/asserting-wl")
+	middle := ca.newSVID(t, "/middle-tier")
+	target := ca.newSVID(t, "/target-wl")
+	lsvid := ca.newChain(t, []*x509svid.SVID{asserting, middle, target}, nil)
+
+	const body = `{"account":"1"}`
+	var received string
+	handler := NewHTTPSignatureVerifier(time.Minute).Middleware(func(r *http.Request) (*LSVID, error) {
+		io.ReadAll(r.Body)
+		return lsvid, nil
+	}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		received = string(b)
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, newSignedRequest(t, lsvid, middle, http.MethodPost, "https://target-wl:8443/deposit", body))
+	if w.Code != http.StatusOK || received != body {
+		t.Errorf("got status %d and body %q", w.Code, received)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "https://target-wl:8443/deposit", strings.NewReader(body)))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("unsigned request got status %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	large := strings.Repeat("a", maxBufferedBody+1)
+	handler.ServeHTTP(w, newSignedRequest(t, lsvid, middle, http.MethodPost, "https://target-wl:8443/deposit", large))
+	if w.Code != http.StatusRequestEntityTooLarge || received != body {
+		t.Errorf("request with a %d bytes body got status %d", len(large), w.Code)
+	}
+}
@@ -0,0 +1,375 @@
+package lsvid
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/spiffe/go-spiffe/v2/bundle/x509bundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+)
+
+// Issuer mints root LSVIDs signed by a locally held CA key.
+//
+// It is meant for environments without the LSVID-enabled SPIRE server/agent. The
+// stock Workload API only returns the caller own X509-SVIDs, so each workload requests
+// its LSVID over the issuer Unix socket (Serve), presenting its X509-SVID. The issuer
+// verifies the X509-SVID against the trust domain bundle, attests the caller through
+// its peer credentials (SO_PEERCRED) against the registered SPIFFE IDs (Register) and
+// converts the X509-SVID into an LSVID (as in Cert2LSR).
+type Issuer struct {
+	td spiffeid.TrustDomain
+
+	mtx            sync.RWMutex
+	key            crypto.Signer
+	authorities    []crypto.PublicKey
+	sequenceNumber uint64
+	bundle         *Token
+	x509Bundles    x509bundle.Source
+	registrations  map[spiffeid.ID]PeerAuthorizer
+}
+
+type issueRequest struct {
+	Certificates [][]byte `json:"x5c"`
+	Audience     string   `json:"aud,omitempty"`
+}
+
+// PeerCred holds the credentials of the local process connected to the issuer or
+// signer socket, as reported by the kernel (SO_PEERCRED).
+type PeerCred struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+// PeerAuthorizer authorizes the local processes allowed to use the issuer or signer.
+type PeerAuthorizer func(cred *PeerCred) error
+
+// AuthorizeUIDs allows the processes running as one of the given users.
+func AuthorizeUIDs(uids ...uint32) PeerAuthorizer {
+	return func(cred *PeerCred) error {
+		for _, uid := range uids {
+			if cred.UID == uid {
+				return nil
+			}
+		}
+		return fmt.Errorf("uid %d not authorized", cred.UID)
+	}
+}
+
+// AuthorizeExecutables allows the processes running one of the given binaries (/proc/<pid>/exe).
+func AuthorizeExecutables(paths ...string) PeerAuthorizer {
+	return func(cred *PeerCred) error {
+		exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", cred.PID))
+		if err != nil {
+			return fmt.Errorf("unable to attest pid %d: %v", cred.PID, err)
+		}
+		for _, path := range paths {
+			if exe == path {
+				return nil
+			}
+		}
+		return fmt.Errorf("executable %s not authorized", exe)
+	}
+}
+
+type peerCredKey struct{}
+
+// NewIssuer creates an Issuer for the given trust domain using key as the trust domain authority.
+func NewIssuer(td spiffeid.TrustDomain, key crypto.Signer) (*Issuer, error) {
+	if td.IsZero() {
+		return nil, fmt.Errorf("Trust domain is required\n")
+	}
+	if key == nil {
+		return nil, fmt.Errorf("Issuer key is required\n")
+	}
+
+	i := &Issuer{
+		td:            td,
+		key:           key,
+		authorities:   []crypto.PublicKey{key.Public()},
+		registrations: make(map[spiffeid.ID]PeerAuthorizer),
+	}
+	if err := i.signBundle(key); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// Register allows the local processes authorized by authorize to obtain the LSVID of id.
+func (i *Issuer) Register(id spiffeid.ID, authorize PeerAuthorizer) error {
+	if id.TrustDomain() != i.td {
+		return fmt.Errorf("%s is not a member of trust domain %s\n", id, i.td)
+	}
+
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+	i.registrations[id] = authorize
+	return nil
+}
+
+// Issue creates a root LSVID for the SPIFFE ID and public key of the given certificate.
+// If audience is empty, the certificate SPIFFE ID is used.
+//
+// The certificate is neither verified nor attested: it is up to the caller.
+func (i *Issuer) Issue(cert *x509.Certificate, audience string) (*LSVID, error) {
+	i.mtx.RLock()
+	key, bundle := i.key, i.bundle
+	i.mtx.RUnlock()
+
+	if audience == "" && len(cert.URIs) > 0 {
+		audience = cert.URIs[0].String()
+	}
+
+	payload, err := cert2Payload(i.td.IDString(), cert, audience)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating LSVID payload: %v\n", err)
+	}
+	payload.Iss.PK, err = x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, fmt.Errorf("Error encoding issuer public key: %v\n", err)
+	}
+
+	token, err := signRoot(payload, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LSVID{
+		Token:  token,
+		Bundle: bundle,
+	}, nil
+}
+
+// Bundle returns the trust bundle LSVID, carrying the issuer public keys as authorities.
+func (i *Issuer) Bundle() (*Token, error) {
+	i.mtx.RLock()
+	defer i.mtx.RUnlock()
+
+	return i.bundle, nil
+}
+
+// Rotate replaces the issuer key by next. The bundle LSVID is signed again with the
+// next sequence number by the current key, so verifiers can Update to it, and keeps
+// the current key as an authority until the next rotation, so the LSVIDs already
+// issued remain valid.
+func (i *Issuer) Rotate(next crypto.Signer) error {
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+
+	current := i.key
+	i.authorities = []crypto.PublicKey{current.Public(), next.Public()}
+	if err := i.signBundle(current); err != nil {
+		return err
+	}
+	i.key = next
+	return nil
+}
+
+// signBundle signs the bundle LSVID of the current authorities with the next sequence number.
+func (i *Issuer) signBundle(key crypto.Signer) error {
+	bundle, err := NewBundle(i.td, i.authorities, i.sequenceNumber+1, 0, key)
+	if err != nil {
+		return err
+	}
+	i.sequenceNumber++
+	i.bundle = bundle
+	return nil
+}
+
+// OnX509ContextUpdate keeps the X.509 bundles used to verify the X509-SVIDs
+// presented by the workloads. It implements workloadapi.X509ContextWatcher.
+func (i *Issuer) OnX509ContextUpdate(x509Context *workloadapi.X509Context) {
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+	i.x509Bundles = x509Context.Bundles
+}
+
+// OnX509ContextWatchError implements workloadapi.X509ContextWatcher.
+func (i *Issuer) OnX509ContextWatchError(err error) {
+	log.Printf("X509 context watch error: %v\n", err)
+}
+
+// Run watches the Workload API at socketPath until ctx is done.
+func (i *Issuer) Run(ctx context.Context, socketPath string) error {
+	return workloadapi.WatchX509Context(ctx, i, workloadapi.WithAddr(socketPath))
+}
+
+// Serve serves the issuer on a Unix socket listener.
+func (i *Issuer) Serve(l net.Listener) error {
+	return servePeers(l, i)
+}
+
+// servePeers serves handler on a Unix socket listener, with the credentials of
+// the peer process in the request context.
+func servePeers(l net.Listener, handler http.Handler) error {
+	server := &http.Server{
+		Handler: handler,
+		ConnContext: func(ctx context.Context, conn net.Conn) context.Context {
+			cred, err := peerCred(conn)
+			if err != nil {
+				log.Printf("Unable to read peer credentials: %v\n", err)
+				return ctx
+			}
+			return context.WithValue(ctx, peerCredKey{}, cred)
+		},
+	}
+	return server.Serve(l)
+}
+
+// ServeHTTP serves the LSVIDs of the attested workloads and the bundle LSVID.
+//
+//	POST /lsvid	returns the encoded LSVID of the X509-SVID in the request (x5c)
+//	GET /bundle	returns an encoded LSVID containing only the bundle
+func (i *Issuer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var lsvid *LSVID
+	switch {
+	case r.URL.Path == "/lsvid" && r.Method == http.MethodPost:
+		var err error
+		lsvid, err = i.issueAttested(r)
+		if err != nil {
+			log.Printf("LSVID request denied: %v", err)
+			http.Error(w, strings.TrimSpace(err.Error()), http.StatusForbidden)
+			return
+		}
+	case r.URL.Path == "/bundle" && r.Method == http.MethodGet:
+		bundle, err := i.Bundle()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		lsvid = &LSVID{Bundle: bundle}
+	case r.URL.Path == "/lsvid" || r.URL.Path == "/bundle":
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	encLSVID, err := Encode(lsvid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	io.WriteString(w, encLSVID)
+}
+
+// issueAttested issues the LSVID of the X509-SVID presented in the request,
+// if the peer process is registered for its SPIFFE ID.
+func (i *Issuer) issueAttested(r *http.Request) (*LSVID, error) {
+	cred, ok := r.Context().Value(peerCredKey{}).(*PeerCred)
+	if !ok {
+		return nil, fmt.Errorf("Peer credentials not available\n")
+	}
+
+	var req issueRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, fmt.Errorf("Invalid LSVID request: %v\n", err)
+	}
+	certs, err := parseRawCertificates(req.Certificates)
+	if err != nil {
+		return nil, err
+	}
+
+	i.mtx.RLock()
+	bundles := i.x509Bundles
+	i.mtx.RUnlock()
+	if bundles == nil {
+		return nil, fmt.Errorf("X.509 bundle not available yet\n")
+	}
+	id, _, err := x509svid.Verify(certs, bundles)
+	if err != nil {
+		return nil, fmt.Errorf("Error verifying X509-SVID: %v\n", err)
+	}
+
+	i.mtx.RLock()
+	authorize, ok := i.registrations[id]
+	i.mtx.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%s is not registered\n", id)
+	}
+	if err := authorize(cred); err != nil {
+		return nil, fmt.Errorf("pid %d not attested as %s: %v\n", cred.PID, id, err)
+	}
+
+	lsvid, err := i.Issue(certs[0], req.Audience)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("LSVID issued for %s (pid %d)\n", id, cred.PID)
+	return lsvid, nil
+}
+
+// FetchLocalLSVID retrieves the LSVID of the given X509-SVID from a local Issuer
+// listening on socketPath (e.g.: unix:///tmp/lsvid-issuer/api.sock). If audience
+// is empty, the LSVID audience is the SVID SPIFFE ID.
+func FetchLocalLSVID(ctx context.Context, socketPath string, svid *x509svid.SVID, audience string) (string, error) {
+	req := &issueRequest{Audience: audience}
+	for _, cert := range svid.Certificates {
+		req.Certificates = append(req.Certificates, cert.Raw)
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("Error generating json: %v\n", err)
+	}
+	return fetchLocal(ctx, socketPath, http.MethodPost, "/lsvid", body)
+}
+
+// FetchLocalBundle retrieves the bundle LSVID from a local Issuer listening on socketPath.
+func FetchLocalBundle(ctx context.Context, socketPath string) (*Token, error) {
+	encLSVID, err := fetchLocal(ctx, socketPath, http.MethodGet, "/bundle", nil)
+	if err != nil {
+		return nil, err
+	}
+	lsvid, err := Decode(encLSVID)
+	if err != nil {
+		return nil, err
+	}
+	return lsvid.Bundle, nil
+}
+
+func fetchLocal(ctx context.Context, socketPath string, method string, path string, body []byte) (string, error) {
+	socketPath = strings.TrimPrefix(socketPath, "unix://")
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socketPath)
+			},
+		},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, "http://lsvid-issuer"+path, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("Unable to reach LSVID issuer: %v\n", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("Unable to read LSVID issuer response: %v\n", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("LSVID issuer returned %s: %s\n", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+
+	return string(respBody), nil
+}
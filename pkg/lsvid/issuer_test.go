@@ -0,0 +1,130 @@
+package lsvid
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/spiffe/go-spiffe/v2/bundle/x509bundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+)
+
+func TestIssuer(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials only supported on Linux")
+	}
+
+	ca := newTestCA(t, "example.org")
+	asserting := ca.newSVID(t, "/asserting-wl")
+	target := ca.newSVID(t, "/target-wl")
+	other := newTestCA(t, "example.org").newSVID(t, "/asserting-wl")
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer, err := NewIssuer(ca.td, key)
+	if err != nil {
+		t.Fatalf("NewIssuer: %v", err)
+	}
+	issuer.OnX509ContextUpdate(&workloadapi.X509Context{Bundles: x509bundle.NewSet(ca.bundle())})
+	if err := issuer.Register(asserting.ID, AuthorizeUIDs(uint32(os.Getuid()))); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if err := issuer.Register(target.ID, AuthorizeUIDs(uint32(os.Getuid()+1))); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if err := issuer.Register(spiffeid.RequireFromString("spiffe://other.org/wl"), AuthorizeUIDs(0)); err == nil {
+		t.Error("foreign SPIFFE ID registered")
+	}
+
+	socketPath := filepath.Join(t.TempDir(), "api.sock")
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go issuer.Serve(l)
+
+	ctx := context.Background()
+	encBundle, err := FetchLocalBundle(ctx, "unix://"+socketPath)
+	if err != nil {
+		t.Fatalf("FetchLocalBundle: %v", err)
+	}
+	bundle, err := ParseBundle(encBundle)
+	if err != nil {
+		t.Fatalf("ParseBundle: %v", err)
+	}
+
+	// the attested workload gets the LSVID of its X509-SVID
+	encLSVID, err := FetchLocalLSVID(ctx, "unix://"+socketPath, asserting, "")
+	if err != nil {
+		t.Fatalf("FetchLocalLSVID: %v", err)
+	}
+	lsvid, err := Decode(encLSVID)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if lsvid.Token.Payload.Sub.CN != asserting.ID.String() || lsvid.Token.Payload.Iss.CN != ca.td.IDString() {
+		t.Errorf("unexpected LSVID %s -> %s", lsvid.Token.Payload.Iss.CN, lsvid.Token.Payload.Sub.CN)
+	}
+	if valid, err := Validate(lsvid.Token, WithBundleSource(bundle)); !valid {
+		t.Fatalf("issued LSVID rejected: %v", err)
+	}
+
+	// callers not attested as the SPIFFE ID, unregistered IDs and
+	// X509-SVIDs of another authority are denied
+	if _, err := FetchLocalLSVID(ctx, socketPath, target, ""); err == nil {
+		t.Error("LSVID issued to a caller with another uid")
+	}
+	if _, err := FetchLocalLSVID(ctx, socketPath, ca.newSVID(t, "/unregistered"), ""); err == nil {
+		t.Error("LSVID issued to an unregistered SPIFFE ID")
+	}
+	if _, err := FetchLocalLSVID(ctx, socketPath, other, ""); err == nil {
+		t.Error("LSVID issued to an X509-SVID of another authority")
+	}
+
+	// rotation increments the bundle sequence number, keeping the issued LSVIDs valid
+	next, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := issuer.Rotate(next); err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	rotated, err := FetchLocalBundle(ctx, socketPath)
+	if err != nil {
+		t.Fatalf("FetchLocalBundle: %v", err)
+	}
+	updated, err := bundle.Update(rotated)
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if updated.SequenceNumber() != bundle.SequenceNumber()+1 {
+		t.Errorf("got sequence number %d after rotation, want %d", updated.SequenceNumber(), bundle.SequenceNumber()+1)
+	}
+	if valid, err := Validate(lsvid.Token, WithBundleSource(updated)); !valid {
+		t.Errorf("LSVID issued before rotation rejected: %v", err)
+	}
+	encLSVID, err = FetchLocalLSVID(ctx, socketPath, asserting, "")
+	if err != nil {
+		t.Fatalf("FetchLocalLSVID: %v", err)
+	}
+	lsvid, err = Decode(encLSVID)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if valid, _ := Validate(lsvid.Token, WithBundleSource(bundle)); valid {
+		t.Error("LSVID signed by the next key accepted before bundle update")
+	}
+	if valid, err := Validate(lsvid.Token, WithBundleSource(updated)); !valid {
+		t.Errorf("LSVID issued after rotation rejected: %v", err)
+	}
+}
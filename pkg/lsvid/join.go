@@ -0,0 +1,179 @@
+package lsvid
+
+import (
+	"crypto"
+	"crypto/rand"
+	"fmt"
+	"strings"
+)
+
+// Parents returns the parent tokens: the nested token, or the parent chains
+// of a join hop. Root tokens have no parents.
+func (t *Token) Parents() []*Token {
+	if t.Nested != nil {
+		return []*Token{t.Nested}
+	}
+	return t.Joined
+}
+
+// Audiences returns the audience and the additional audiences of the payload.
+func (p *Payload) Audiences() []*IDClaim {
+	var auds []*IDClaim
+	if p.Aud != nil {
+		auds = append(auds, p.Aud)
+	}
+	for _, aud := range p.Auds {
+		if aud != nil {
+			auds = append(auds, aud)
+		}
+	}
+	return auds
+}
+
+// HasAudience checks if cn is one of the payload audiences.
+func (p *Payload) HasAudience(cn string) bool {
+	for _, aud := range p.Audiences() {
+		if aud.CN == cn {
+			return true
+		}
+	}
+	return false
+}
+
+// Join creates a join hop, nesting two or more parent LSVIDs under a single
+// signature, for a workload that combines requests delegated to it.
+// The payload issuer must be an audience of every parent, that should be
+// validated before joining. Disclosures and discharges of the parents are
+// kept, and the bundle of the first parent is used.
+func Join(parents []*LSVID, newPayload *Payload, key crypto.Signer) (string, error) {
+	if len(parents) < 2 {
+		return "", fmt.Errorf("Join requires at least two parent LSVIDs\n")
+	}
+	if newPayload.Iss == nil {
+		return "", fmt.Errorf("Join payload issuer is required\n")
+	}
+
+	token := &Token{
+		Payload: newPayload,
+	}
+	extLSVID := &LSVID{
+		Token:  token,
+		Bundle: parents[0].Bundle,
+	}
+	for _, parent := range parents {
+		if parent.Token == nil || parent.Token.Payload == nil {
+			return "", fmt.Errorf("Invalid parent LSVID\n")
+		}
+		if !parent.Token.Payload.HasAudience(newPayload.Iss.CN) {
+			return "", fmt.Errorf("%s is not an audience of parent LSVID\n", newPayload.Iss.CN)
+		}
+		token.Joined = append(token.Joined, parent.Token)
+		extLSVID.Disclosures = append(extLSVID.Disclosures, parent.Disclosures...)
+		extLSVID.Discharges = append(extLSVID.Discharges, parent.Discharges...)
+		extLSVID.Inclusions = append(extLSVID.Inclusions, parent.Inclusions...)
+	}
+
+	// Hash linked hops can be redacted, so a nonce hides their payload
+	if newPayload.Ver == VerHashLinked && len(newPayload.Nce) == 0 {
+		newPayload.Nce = make([]byte, 16)
+		if _, err := rand.Read(newPayload.Nce); err != nil {
+			return "", fmt.Errorf("Error generating nonce: %v\n", err)
+		}
+	}
+
+	hash, err := signingHash(token)
+	if err != nil {
+		return "", err
+	}
+	token.Signature, err = key.Sign(rand.Reader, hash, crypto.SHA256)
+	if err != nil {
+		return "", fmt.Errorf("Error generating signed assertion: %v\n", err)
+	}
+
+	outLSVID, err := Encode(extLSVID)
+	if err != nil {
+		return "", fmt.Errorf("Error encoding LSVID: %v\n", err)
+	}
+
+	return outLSVID, nil
+}
+
+// Walk calls fn for every token of the chain, from the outer most one, in
+// depth-first order. Tokens shared by joined chains are visited only once, at
+// the depth they are first found. Walk stops at the first error returned by fn.
+func Walk(lsvid *Token, fn func(token *Token, depth int) error) error {
+	visited := make(map[*Token]bool)
+
+	var walk func(token *Token, depth int) error
+	walk = func(token *Token, depth int) error {
+		if visited[token] {
+			return nil
+		}
+		visited[token] = true
+
+		if err := fn(token, depth); err != nil {
+			return err
+		}
+		for _, parent := range token.Parents() {
+			if err := walk(parent, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return walk(lsvid, 0)
+}
+
+// Roots returns the root tokens of the chain. Linear chains have a single root.
+func Roots(lsvid *Token) []*Token {
+	var roots []*Token
+	Walk(lsvid, func(token *Token, _ int) error {
+		if len(token.Parents()) == 0 {
+			roots = append(roots, token)
+		}
+		return nil
+	})
+	return roots
+}
+
+// PrintLSVID prints the hops of the LSVID, indenting the parents of each hop.
+func PrintLSVID(lsvid *Token) {
+	var printHop func(token *Token, depth int)
+	printHop = func(token *Token, depth int) {
+		indent := strings.Repeat("  ", depth)
+		switch {
+		case token.Payload == nil:
+			fmt.Printf("%s[%d] redacted hop\n", indent, depth)
+		case len(token.Parents()) == 0:
+			fmt.Printf("%s[%d] root: iss %s sub %s aud %s\n", indent, depth, idClaimCN(token.Payload.Iss), idClaimCN(token.Payload.Sub), audiencesCN(token.Payload))
+		case len(token.Joined) > 0:
+			fmt.Printf("%s[%d] join of %d chains: iss %s aud %s\n", indent, depth, len(token.Joined), idClaimCN(token.Payload.Iss), audiencesCN(token.Payload))
+		default:
+			fmt.Printf("%s[%d] hop: iss %s aud %s\n", indent, depth, idClaimCN(token.Payload.Iss), audiencesCN(token.Payload))
+		}
+		for _, parent := range token.Parents() {
+			printHop(parent, depth+1)
+		}
+	}
+
+	printHop(lsvid, 0)
+}
+
+func idClaimCN(claim *IDClaim) string {
+	if claim == nil {
+		return "-"
+	}
+	return claim.CN
+}
+
+func audiencesCN(payload *Payload) string {
+	var cns []string
+	for _, aud := range payload.Audiences() {
+		cns = append(cns, aud.CN)
+	}
+	if len(cns) == 0 {
+		return "-"
+	}
+	return strings.Join(cns, ",")
+}
@@ -0,0 +1,203 @@
+package lsvid
+
+import (
+	"crypto"
+	"crypto/rand"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+)
+
+func TestJoin(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	path := ca.newPath(t, "/asserting-a", "/asserting-b", "/aggregator", "/target-wl")
+	aggregator, target := path[2], path[3]
+	trustBundle := newTestTrustBundle(t, ca)
+
+	a := ca.newChain(t, []*x509svid.SVID{path[0], aggregator}, nil)
+	b := ca.newChain(t, []*x509svid.SVID{path[1], aggregator}, nil)
+	joined := join(t, []*LSVID{a, b}, ca.newPayload(t, aggregator, target.ID.String()), aggregator)
+	checkValidate(t, joined.Token, true, WithBundleSource(trustBundle))
+
+	// the joined LSVID is extended as any other
+	extended := extend(t, joined, ca.newPayload(t, target, "spiffe://example.org/backend"), target)
+	checkValidate(t, extended.Token, true, WithBundleSource(trustBundle))
+
+	roots := Roots(extended.Token)
+	if len(roots) != 2 {
+		t.Fatalf("got %d roots, want 2", len(roots))
+	}
+	for i, root := range roots {
+		if root.Payload.Sub.CN != path[i].ID.String() {
+			t.Errorf("got root %d of %s, want %s", i, root.Payload.Sub.CN, path[i].ID)
+		}
+	}
+
+	output := captureStdout(t, func() { PrintLSVID(extended.Token) })
+	for _, want := range []string{
+		"[0] hop: iss " + target.ID.String(),
+		"  [1] join of 2 chains: iss " + aggregator.ID.String() + " aud " + target.ID.String(),
+		"      [3] root: iss spiffe://example.org sub " + path[1].ID.String(),
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("got output\n%s\nwant line %q", output, want)
+		}
+	}
+}
+
+func TestJoinRequirements(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	path := ca.newPath(t, "/asserting-a", "/asserting-b", "/aggregator", "/target-wl")
+	aggregator, target := path[2], path[3]
+
+	a := ca.newChain(t, []*x509svid.SVID{path[0], aggregator}, nil)
+	b := ca.newChain(t, []*x509svid.SVID{path[1], target}, nil)
+	payload := ca.newPayload(t, aggregator, target.ID.String())
+
+	if _, err := Join([]*LSVID{a}, payload, aggregator.PrivateKey); err == nil {
+		t.Error("join with a single parent created")
+	}
+	if _, err := Join([]*LSVID{a, b}, payload, aggregator.PrivateKey); err == nil {
+		t.Error("join of a parent for another audience created")
+	}
+	if _, err := Join([]*LSVID{a, a}, &Payload{Ver: 1}, aggregator.PrivateKey); err == nil {
+		t.Error("join without issuer created")
+	}
+}
+
+// Validation rejects malformed joins, that Join would not create.
+func TestJoinRejected(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	path := ca.newPath(t, "/asserting-a", "/asserting-b", "/aggregator", "/target-wl")
+	aggregator, target := path[2], path[3]
+	trustBundle := newTestTrustBundle(t, ca)
+
+	a := ca.newChain(t, []*x509svid.SVID{path[0], aggregator}, nil)
+	b := ca.newChain(t, []*x509svid.SVID{path[1], aggregator}, nil)
+	other := ca.newChain(t, []*x509svid.SVID{path[1], target}, nil)
+
+	for _, tt := range []struct {
+		name  string
+		token *Token
+	}{
+		{"valid", signJoin(t, &Token{Joined: []*Token{a.Token, b.Token}}, ca.newPayload(t, aggregator, target.ID.String()), aggregator)},
+		{"bad Aud -> Iss link on one parent", signJoin(t, &Token{Joined: []*Token{a.Token, other.Token}}, ca.newPayload(t, aggregator, target.ID.String()), aggregator)},
+		{"nested and joined", signJoin(t, &Token{Nested: a.Token, Joined: []*Token{a.Token, b.Token}}, ca.newPayload(t, aggregator, target.ID.String()), aggregator)},
+		{"join with a single parent", signJoin(t, &Token{Joined: []*Token{a.Token}}, ca.newPayload(t, aggregator, target.ID.String()), aggregator)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			checkValidate(t, tt.token, tt.name == "valid", WithBundleSource(trustBundle))
+		})
+	}
+}
+
+// A hop with additional audiences is delegated to each of them, and the
+// branches can be joined back.
+func TestJoinAudiences(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	path := ca.newPath(t, "/asserting-wl", "/middle-a", "/middle-b", "/aggregator", "/middle-c")
+	asserting, middleA, middleB, aggregator, middleC := path[0], path[1], path[2], path[3], path[4]
+	trustBundle := newTestTrustBundle(t, ca)
+
+	payload := ca.newPayload(t, asserting, middleA.ID.String())
+	payload.Auds = []*IDClaim{{CN: middleB.ID.String()}}
+	if auds := payload.Audiences(); len(auds) != 2 || auds[1].CN != middleB.ID.String() {
+		t.Errorf("got audiences %s, want %s and %s", audiencesCN(payload), middleA.ID, middleB.ID)
+	}
+	if !payload.HasAudience(middleB.ID.String()) || payload.HasAudience(middleC.ID.String()) {
+		t.Errorf("got audiences %s", audiencesCN(payload))
+	}
+	delegated := extend(t, &LSVID{Token: ca.newLSVID(t, asserting)}, payload, asserting)
+
+	branchA := extend(t, delegated, ca.newPayload(t, middleA, aggregator.ID.String()), middleA)
+	branchB := extend(t, delegated, ca.newPayload(t, middleB, aggregator.ID.String()), middleB)
+	checkValidate(t, branchB.Token, true, WithBundleSource(trustBundle))
+	branchC := extend(t, delegated, ca.newPayload(t, middleC, aggregator.ID.String()), middleC)
+	checkValidate(t, branchC.Token, false, WithBundleSource(trustBundle))
+
+	joined := join(t, []*LSVID{branchA, branchB}, ca.newPayload(t, aggregator, "spiffe://example.org/target-wl"), aggregator)
+	checkValidate(t, joined.Token, true, WithBundleSource(trustBundle))
+
+	// decoding copies the shared hops: walked from the same token, they are
+	// visited once, at the depth first found
+	if visits := walkCount(joined.Token); visits != 7 {
+		t.Errorf("got %d tokens walked, want 7", visits)
+	}
+	joined.Token.Joined[1].Nested = joined.Token.Joined[0].Nested
+	checkValidate(t, joined.Token, true, WithBundleSource(trustBundle))
+	if visits := walkCount(joined.Token); visits != 5 {
+		t.Errorf("got %d tokens walked, want 5", visits)
+	}
+	depths := make(map[string]int)
+	Walk(joined.Token, func(token *Token, depth int) error {
+		if len(token.Parents()) == 0 {
+			depths["root"] = depth
+		} else {
+			depths[token.Payload.Iss.CN] = depth
+		}
+		return nil
+	})
+	if depths[asserting.ID.String()] != 2 || depths["root"] != 3 {
+		t.Errorf("got depths %v", depths)
+	}
+	if roots := Roots(joined.Token); len(roots) != 1 {
+		t.Errorf("got %d roots, want the shared root", len(roots))
+	}
+}
+
+// join joins the parent LSVIDs with the payload signed by the SVID key, and decodes the result.
+func join(t *testing.T, parents []*LSVID, payload *Payload, svid *x509svid.SVID) *LSVID {
+	encLSVID, err := Join(parents, payload, svid.PrivateKey)
+	if err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	joined, err := Decode(encLSVID)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	return joined
+}
+
+// signJoin signs the token with the payload, without the checks of Join.
+func signJoin(t *testing.T, token *Token, payload *Payload, svid *x509svid.SVID) *Token {
+	token.Payload = payload
+	hash, err := signingHash(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token.Signature, err = svid.PrivateKey.Sign(rand.Reader, hash, crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func walkCount(lsvid *Token) int {
+	visits := 0
+	Walk(lsvid, func(*Token, int) error {
+		visits++
+		return nil
+	})
+	return visits
+}
+
+func captureStdout(t *testing.T, fn func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	fn()
+	os.Stdout = stdout
+	w.Close()
+
+	output, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(output)
+}
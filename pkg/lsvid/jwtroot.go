@@ -0,0 +1,152 @@
+package lsvid
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/spiffe/go-spiffe/v2/bundle/jwtbundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
+)
+
+// FromJWTSVID wraps a standard JWT-SVID as the root of a new LSVID, so it can be
+// extended with Extend by the given audience. If audience is empty, the first
+// JWT-SVID audience is used.
+//
+// The root token carries the JWT-SVID as issued, and its payload mirrors the
+// JWT-SVID claims. As JWT-SVIDs do not bind a key to the subject, the workloads
+// extending the LSVID still need their own LSVID as issuer ID (Iss.ID).
+func FromJWTSVID(svid *jwtsvid.SVID, audience string) (*LSVID, error) {
+	if svid == nil || svid.Marshal() == "" {
+		return nil, fmt.Errorf("JWT-SVID is required\n")
+	}
+	if audience == "" {
+		if len(svid.Audience) == 0 {
+			return nil, fmt.Errorf("JWT-SVID has no audience\n")
+		}
+		audience = svid.Audience[0]
+	}
+	if !containsString(svid.Audience, audience) {
+		return nil, fmt.Errorf("%s is not a JWT-SVID audience\n", audience)
+	}
+
+	payload, err := jwtRootPayload(svid, audience)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LSVID{
+		Token: &Token{
+			Payload: payload,
+			JWT:     svid.Marshal(),
+		},
+	}, nil
+}
+
+// jwtRootPayload returns the root payload derived from the JWT-SVID claims.
+func jwtRootPayload(svid *jwtsvid.SVID, audience string) (*Payload, error) {
+	alg, err := jwtAlgorithm(svid.Marshal())
+	if err != nil {
+		return nil, err
+	}
+
+	payload := &Payload{
+		Ver: 1,
+		Alg: alg,
+		Iss: &IDClaim{
+			CN: svid.ID.TrustDomain().IDString(),
+		},
+		Sub: &IDClaim{
+			CN: svid.ID.String(),
+		},
+		Aud: &IDClaim{
+			CN: audience,
+		},
+	}
+	if iat, ok := svid.Claims["iat"].(float64); ok {
+		payload.Iat = int64(iat)
+	}
+
+	return payload, nil
+}
+
+// validateJWTRoot validates a JWT-SVID root token against the JWT bundle source
+// and checks its payload against the JWT-SVID claims.
+// It returns the JWT-SVID trust domain.
+func validateJWTRoot(root *Token, source jwtbundle.Source) (spiffeid.TrustDomain, error) {
+	if source == nil {
+		return spiffeid.TrustDomain{}, fmt.Errorf("JWT bundle source is required to validate JWT-SVID roots\n")
+	}
+	payload := root.Payload
+	if payload == nil || payload.Iss == nil || payload.Sub == nil || payload.Aud == nil {
+		return spiffeid.TrustDomain{}, fmt.Errorf("Invalid JWT-SVID root payload\n")
+	}
+
+	svid, err := jwtsvid.ParseAndValidate(root.JWT, source, []string{payload.Aud.CN})
+	if err != nil {
+		return spiffeid.TrustDomain{}, fmt.Errorf("JWT-SVID validation failed: %v\n", err)
+	}
+
+	if payload.Sub.CN != svid.ID.String() {
+		return spiffeid.TrustDomain{}, fmt.Errorf("Root subject %s does not match JWT-SVID %s\n", payload.Sub.CN, svid.ID)
+	}
+	td := svid.ID.TrustDomain()
+	if payload.Iss.CN != td.IDString() {
+		return spiffeid.TrustDomain{}, fmt.Errorf("Root issuer %s does not match JWT-SVID trust domain %s\n", payload.Iss.CN, td)
+	}
+	if iat, ok := svid.Claims["iat"].(float64); ok && payload.Iat != int64(iat) {
+		return spiffeid.TrustDomain{}, fmt.Errorf("Root iat does not match JWT-SVID\n")
+	}
+
+	// The JWT-SVID only vouches for the claims derived from it: any other
+	// root claim (e.g.: Scp, Cst, Blk, Dpr or keys) would be unsigned.
+	expected, err := jwtRootPayload(svid, payload.Aud.CN)
+	if err != nil {
+		return spiffeid.TrustDomain{}, err
+	}
+	expectedJSON, err := json.Marshal(expected)
+	if err != nil {
+		return spiffeid.TrustDomain{}, fmt.Errorf("Error generating json: %v\n", err)
+	}
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return spiffeid.TrustDomain{}, fmt.Errorf("Error generating json: %v\n", err)
+	}
+	if !bytes.Equal(payloadJSON, expectedJSON) {
+		return spiffeid.TrustDomain{}, fmt.Errorf("Root payload carries claims not derived from the JWT-SVID\n")
+	}
+	fmt.Printf("JWT-SVID root validation successful!\n")
+
+	return td, nil
+}
+
+// jwtAlgorithm returns the alg header of a compact serialized JWT.
+func jwtAlgorithm(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("Invalid JWT-SVID serialization\n")
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", fmt.Errorf("Error decoding JWT-SVID header: %v\n", err)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return "", fmt.Errorf("Error unmarshaling JWT-SVID header: %v\n", err)
+	}
+	return header.Alg, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
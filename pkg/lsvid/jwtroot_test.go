@@ -0,0 +1,119 @@
+package lsvid
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/bundle/jwtbundle"
+	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
+)
+
+func TestJWTSVIDRoot(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	frontend := ca.newSVID(t, "/frontend")
+	backend := ca.newSVID(t, "/backend")
+	jwtBundle := jwtbundle.New(ca.td)
+	jwtBundle.AddJWTAuthority("authority", ca.key.Public())
+
+	svid := newTestJWTSVID(t, ca, "spiffe://example.org/user", frontend.ID.String())
+	root, err := FromJWTSVID(svid, "")
+	if err != nil {
+		t.Fatalf("FromJWTSVID: %v", err)
+	}
+	if root.Token.Payload.Sub.CN != "spiffe://example.org/user" || root.Token.Payload.Aud.CN != frontend.ID.String() {
+		t.Errorf("unexpected root %s -> %s", root.Token.Payload.Sub.CN, root.Token.Payload.Aud.CN)
+	}
+	if _, err := FromJWTSVID(svid, backend.ID.String()); err == nil {
+		t.Error("root created for an audience not in the JWT-SVID")
+	}
+
+	lsvid := extend(t, root, ca.newPayload(t, frontend, backend.ID.String()), frontend)
+	if valid, err := Validate(lsvid.Token, WithJWTBundleSource(jwtBundle)); !valid {
+		t.Fatalf("LSVID rejected: %v", err)
+	}
+	if valid, _ := Validate(lsvid.Token); valid {
+		t.Error("JWT-SVID root accepted without JWT bundle")
+	}
+
+	other := jwtbundle.New(ca.td)
+	other.AddJWTAuthority("authority", newTestCA(t, "example.org").key.Public())
+	if valid, _ := Validate(lsvid.Token, WithJWTBundleSource(other)); valid {
+		t.Error("JWT-SVID root accepted with another authority")
+	}
+}
+
+func TestJWTSVIDRootInjectedClaims(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	frontend := ca.newSVID(t, "/frontend")
+	backend := ca.newSVID(t, "/backend")
+	jwtBundle := jwtbundle.New(ca.td)
+	jwtBundle.AddJWTAuthority("authority", ca.key.Public())
+	svid := newTestJWTSVID(t, ca, "spiffe://example.org/user", frontend.ID.String())
+
+	constraints, err := NewConstraints(10, nil, time.Now().Add(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name   string
+		inject func(payload *Payload)
+	}{
+		{"sub", func(payload *Payload) { payload.Sub.CN = "spiffe://example.org/admin" }},
+		{"iss", func(payload *Payload) { payload.Iss.CN = "spiffe://other.org" }},
+		{"iat", func(payload *Payload) { payload.Iat++ }},
+		{"sub key", func(payload *Payload) { payload.Sub.PK = []byte("key") }},
+		{"scp", func(payload *Payload) { payload.Scp = []string{"admin"} }},
+		{"cst", func(payload *Payload) { payload.Cst = constraints }},
+		{"blk", func(payload *Payload) { payload.Blk = &Block{Facts: []string{`right("admin")`}} }},
+		{"dpr", func(payload *Payload) { payload.Dpr = "alice" }},
+		{"sel", func(payload *Payload) { payload.Sel = map[string]interface{}{"uid": 0} }},
+		{"tpc", func(payload *Payload) { payload.Tpc = []*ThirdPartyCaveat{{}} }},
+		{"aud", func(payload *Payload) { payload.Auds = []*IDClaim{{CN: backend.ID.String()}} }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := FromJWTSVID(svid, "")
+			if err != nil {
+				t.Fatalf("FromJWTSVID: %v", err)
+			}
+			tt.inject(root.Token.Payload)
+			lsvid := extend(t, root, ca.newPayload(t, frontend, backend.ID.String()), frontend)
+			if valid, _ := Validate(lsvid.Token, WithJWTBundleSource(jwtBundle)); valid {
+				t.Errorf("JWT-SVID root with injected %s accepted", tt.name)
+			}
+		})
+	}
+}
+
+// newTestJWTSVID creates a JWT-SVID signed (ES256) by the CA key, with the kid "authority".
+func newTestJWTSVID(t *testing.T, ca *testCA, subject string, audience string) *jwtsvid.SVID {
+	encode := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	now := time.Now()
+	signingInput := encode(map[string]string{"alg": "ES256", "kid": "authority", "typ": "JWT"}) + "." +
+		encode(map[string]interface{}{"sub": subject, "aud": []string{audience}, "iat": now.Unix(), "exp": now.Add(time.Hour).Unix()})
+
+	hash := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, ca.key, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	svid, err := jwtsvid.ParseInsecure(signingInput+"."+base64.RawURLEncoding.EncodeToString(signature), []string{audience})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return svid
+}
@@ -0,0 +1,377 @@
+package lsvid
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	hash256 "crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// JWKThumbprint computes the RFC 7638 JWK thumbprint of the key (SHA-256, base64url).
+// It is a standard key reference, that IDClaims may carry (Kid) in place of the PKIX key.
+func JWKThumbprint(pub crypto.PublicKey) (string, error) {
+	jwk, err := jwkEncode(pub)
+	if err != nil {
+		return "", err
+	}
+	hash := hash256.Sum256([]byte(jwk))
+	return base64.RawURLEncoding.EncodeToString(hash[:]), nil
+}
+
+// KeyRef creates an IDClaim referencing the key by its JWK thumbprint.
+func KeyRef(cn string, pub crypto.PublicKey) (*IDClaim, error) {
+	kid, err := JWKThumbprint(pub)
+	if err != nil {
+		return nil, err
+	}
+	return &IDClaim{
+		CN:  cn,
+		Kid: kid,
+	}, nil
+}
+
+// jwkEncode encodes the required JWK members in lexicographic order (RFC 7638, Section 3.3),
+// like dasvid.JwkEncode. poclib is not imported since it requires cgo and OpenSSL.
+func jwkEncode(pub crypto.PublicKey) (string, error) {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`,
+			base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		), nil
+	case *ecdsa.PublicKey:
+		p := pub.Curve.Params()
+		n := (p.BitSize + 7) / 8
+		x := make([]byte, n)
+		y := make([]byte, n)
+		pub.X.FillBytes(x)
+		pub.Y.FillBytes(y)
+		return fmt.Sprintf(`{"crv":"%s","kty":"EC","x":"%s","y":"%s"}`,
+			p.Name,
+			base64.RawURLEncoding.EncodeToString(x),
+			base64.RawURLEncoding.EncodeToString(y),
+		), nil
+	}
+	return "", fmt.Errorf("Unsupported key type %T\n", pub)
+}
+
+// KeyResolver maps JWK thumbprints (IDClaim.Kid) to keys.
+type KeyResolver interface {
+	ResolveKey(kid string) (crypto.PublicKey, error)
+}
+
+// KeySet is a static KeyResolver.
+type KeySet map[string]crypto.PublicKey
+
+// NewKeySet creates a key set with the given keys.
+func NewKeySet(keys ...crypto.PublicKey) (KeySet, error) {
+	set := make(KeySet)
+	for _, key := range keys {
+		if err := set.Add(key); err != nil {
+			return nil, err
+		}
+	}
+	return set, nil
+}
+
+// Add adds a key to the set.
+func (s KeySet) Add(key crypto.PublicKey) error {
+	kid, err := JWKThumbprint(key)
+	if err != nil {
+		return err
+	}
+	s[kid] = key
+	return nil
+}
+
+// ResolveKey implements KeyResolver.
+func (s KeySet) ResolveKey(kid string) (crypto.PublicKey, error) {
+	key, ok := s[kid]
+	if !ok {
+		return nil, fmt.Errorf("Key %s not found\n", kid)
+	}
+	return key, nil
+}
+
+// KeysFromTrustBundle returns the authorities of the trust bundle.
+func KeysFromTrustBundle(bundle *TrustBundle) (KeySet, error) {
+	return NewKeySet(bundle.Authorities()...)
+}
+
+// KeysFromLSVID returns the subject keys of the issuer LSVIDs embedded in the chain.
+// They are only as trusted as the issuer LSVIDs: the resolver is only safe together
+// with WithBundleSource, so that the issuer LSVIDs are validated against the trust bundles.
+func KeysFromLSVID(token *Token) (KeySet, error) {
+	set := make(KeySet)
+	err := Walk(token, func(hop *Token, _ int) error {
+		if hop.Payload == nil || hop.Payload.Iss == nil || hop.Payload.Iss.ID == nil {
+			return nil
+		}
+		issLSVID := innermost(hop.Payload.Iss.ID)
+		if issLSVID.Payload == nil || issLSVID.Payload.Sub == nil || len(issLSVID.Payload.Sub.PK) == 0 {
+			return nil
+		}
+		key, err := x509.ParsePKIXPublicKey(issLSVID.Payload.Sub.PK)
+		if err != nil {
+			return fmt.Errorf("Failed to parse public key: %v\n", err)
+		}
+		return set.Add(key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// KeyResolvers tries each resolver in turn.
+type KeyResolvers []KeyResolver
+
+// ResolveKey implements KeyResolver.
+func (r KeyResolvers) ResolveKey(kid string) (crypto.PublicKey, error) {
+	for _, resolver := range r {
+		if key, err := resolver.ResolveKey(kid); err == nil {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("Key %s not found\n", kid)
+}
+
+// Minimum interval between two JWKS fetches, how long unknown keys are remembered,
+// and how many of them.
+const (
+	minJWKSRefresh = 10 * time.Second
+	jwksMissingTTL = time.Minute
+	maxJWKSMissing = 1024
+)
+
+// JWKSKeyResolver resolves keys from a key server publishing a JWK set
+// (e.g.: the asserting workload /keys endpoint). The set is refreshed after ttl,
+// or when an unknown key is requested. Refreshes are at least minJWKSRefresh
+// apart, and a key missing from the set is not refetched for jwksMissingTTL, so
+// tokens with random kids can not drive the fetches.
+type JWKSKeyResolver struct {
+	client *http.Client
+	url    string
+	ttl    time.Duration
+
+	mtx       sync.Mutex
+	keys      KeySet
+	fetched   time.Time
+	attempted time.Time
+	missing   map[string]time.Time
+	fetching  chan struct{} // closed when the fetch in progress ends
+}
+
+// NewJWKSKeyResolver creates a resolver of the keys published at url.
+func NewJWKSKeyResolver(client *http.Client, url string, ttl time.Duration) *JWKSKeyResolver {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &JWKSKeyResolver{
+		client:  client,
+		url:     url,
+		ttl:     ttl,
+		missing: make(map[string]time.Time),
+	}
+}
+
+// ResolveKey implements KeyResolver. The set is fetched without holding the
+// lock: keys already known are resolved during the fetch, other callers wait for it.
+func (r *JWKSKeyResolver) ResolveKey(kid string) (crypto.PublicKey, error) {
+	r.mtx.Lock()
+	for {
+		if key, ok := r.keys[kid]; ok && time.Since(r.fetched) < r.ttl {
+			r.mtx.Unlock()
+			return key, nil
+		}
+		if r.fetching == nil {
+			break
+		}
+		fetching := r.fetching
+		r.mtx.Unlock()
+		<-fetching
+		r.mtx.Lock()
+	}
+
+	if !r.refreshable(kid) {
+		// an expired key is kept until the set can be refreshed
+		key, ok := r.keys[kid]
+		r.mtx.Unlock()
+		if !ok {
+			return nil, fmt.Errorf("Key %s not found\n", kid)
+		}
+		return key, nil
+	}
+	fetching := make(chan struct{})
+	r.fetching, r.attempted = fetching, time.Now()
+	r.mtx.Unlock()
+
+	keys, err := r.fetch()
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.fetching = nil
+	close(fetching)
+	if err != nil {
+		return nil, err
+	}
+	r.keys, r.fetched = keys, time.Now()
+
+	for missing, since := range r.missing {
+		if _, ok := keys[missing]; ok || time.Since(since) >= jwksMissingTTL {
+			delete(r.missing, missing)
+		}
+	}
+	key, ok := keys[kid]
+	if !ok {
+		if len(r.missing) >= maxJWKSMissing {
+			r.missing = make(map[string]time.Time)
+		}
+		r.missing[kid] = time.Now()
+		return nil, fmt.Errorf("Key %s not found\n", kid)
+	}
+	return key, nil
+}
+
+// refreshable checks if the set may be fetched to resolve kid. Called with the lock held.
+func (r *JWKSKeyResolver) refreshable(kid string) bool {
+	if time.Since(r.attempted) < minJWKSRefresh {
+		return false
+	}
+	since, ok := r.missing[kid]
+	return !ok || time.Since(since) >= jwksMissingTTL
+}
+
+func (r *JWKSKeyResolver) fetch() (KeySet, error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, r.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to fetch JWKS: %v\n", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read JWKS: %v\n", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS endpoint returned %s\n", resp.Status)
+	}
+
+	var jwks struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := json.Unmarshal(body, &jwks); err != nil {
+		return nil, fmt.Errorf("Invalid JWKS: %v\n", err)
+	}
+
+	set := make(KeySet)
+	for _, raw := range jwks.Keys {
+		key, err := jwkDecode(raw)
+		if err != nil {
+			// skip unsupported keys
+			continue
+		}
+		if err := set.Add(key); err != nil {
+			return nil, err
+		}
+	}
+	return set, nil
+}
+
+// jwkDecode decodes an EC (P-256, P-384, P-521) or RSA public JWK.
+func jwkDecode(raw []byte) (crypto.PublicKey, error) {
+	var jwk struct {
+		Kty string `json:"kty"`
+		Crv string `json:"crv"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	}
+	if err := json.Unmarshal(raw, &jwk); err != nil {
+		return nil, err
+	}
+	decode := func(s string) *big.Int {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil || len(b) == 0 {
+			return nil
+		}
+		return new(big.Int).SetBytes(b)
+	}
+
+	switch jwk.Kty {
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", jwk.Crv)
+		}
+		x, y := decode(jwk.X), decode(jwk.Y)
+		if x == nil || y == nil || !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("invalid EC key")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "RSA":
+		n, e := decode(jwk.N), decode(jwk.E)
+		if n == nil || e == nil || !e.IsInt64() {
+			return nil, fmt.Errorf("invalid RSA key")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", jwk.Kty)
+}
+
+// claimKey returns the key of the claim, given by its PKIX key or its JWK thumbprint.
+// When both are present, they must match.
+func claimKey(claim *IDClaim, resolver KeyResolver) (crypto.PublicKey, error) {
+	var key crypto.PublicKey
+	switch {
+	case len(claim.PK) > 0:
+		var err error
+		if key, err = x509.ParsePKIXPublicKey(claim.PK); err != nil {
+			return nil, fmt.Errorf("Failed to parse public key: %v\n", err)
+		}
+	case claim.Kid != "":
+		if resolver == nil {
+			return nil, fmt.Errorf("No key resolver for key %s of %s\n", claim.Kid, claim.CN)
+		}
+		var err error
+		if key, err = resolver.ResolveKey(claim.Kid); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("No key found for %s\n", claim.CN)
+	}
+
+	if claim.Kid != "" {
+		kid, err := JWKThumbprint(key)
+		if err != nil {
+			return nil, err
+		}
+		if kid != claim.Kid {
+			return nil, fmt.Errorf("Key of %s does not match its thumbprint\n", claim.CN)
+		}
+	}
+	return key, nil
+}
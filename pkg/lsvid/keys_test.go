@@ -0,0 +1,335 @@
+package lsvid
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+)
+
+// RSA key of RFC 7638, Section 3.1.
+const (
+	rfc7638N = "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw"
+	rfc7638E = "AQAB"
+	// Thumbprint of the key, given in RFC 7638, Section 3.1.
+	rfc7638Thumbprint = "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"
+)
+
+func rfc7638Key(t *testing.T) *rsa.PublicKey {
+	key, err := jwkDecode([]byte(`{"kty":"RSA","n":"` + rfc7638N + `","e":"` + rfc7638E + `","alg":"RS256","kid":"2011-04-29"}`))
+	if err != nil {
+		t.Fatalf("jwkDecode: %v", err)
+	}
+	return key.(*rsa.PublicKey)
+}
+
+func TestJWKThumbprint(t *testing.T) {
+	kid, err := JWKThumbprint(rfc7638Key(t))
+	if err != nil {
+		t.Fatalf("JWKThumbprint: %v", err)
+	}
+	if kid != rfc7638Thumbprint {
+		t.Errorf("got thumbprint %s, want %s", kid, rfc7638Thumbprint)
+	}
+
+	if _, err := JWKThumbprint("not a key"); err == nil {
+		t.Error("thumbprint of unsupported key computed")
+	}
+}
+
+// The EC coordinates are padded to the curve size, whatever their value.
+func TestJWKEncodeECPadding(t *testing.T) {
+	for _, curve := range []elliptic.Curve{elliptic.P256(), elliptic.P384(), elliptic.P521()} {
+		key := &ecdsa.PublicKey{Curve: curve, X: big.NewInt(1), Y: big.NewInt(2)}
+		jwk, err := jwkEncode(key)
+		if err != nil {
+			t.Fatalf("jwkEncode: %v", err)
+		}
+		var members struct {
+			X string `json:"x"`
+			Y string `json:"y"`
+		}
+		if err := json.Unmarshal([]byte(jwk), &members); err != nil {
+			t.Fatal(err)
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		if want := (size*8 + 5) / 6; len(members.X) != want || len(members.Y) != want {
+			t.Errorf("%s: got coordinates of %d and %d characters, want %d", curve.Params().Name, len(members.X), len(members.Y), want)
+		}
+	}
+}
+
+func TestJWKRoundTrip(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pub := range []crypto.PublicKey{rfc7638Key(t), ecKey.Public()} {
+		jwk, err := jwkEncode(pub)
+		if err != nil {
+			t.Fatalf("jwkEncode: %v", err)
+		}
+		decoded, err := jwkDecode([]byte(jwk))
+		if err != nil {
+			t.Fatalf("jwkDecode: %v", err)
+		}
+		if !decoded.(interface{ Equal(crypto.PublicKey) bool }).Equal(pub) {
+			t.Errorf("%T changed by JWK round trip", pub)
+		}
+	}
+
+	for _, jwk := range []string{
+		`{"kty":"EC","crv":"P-256","x":"AQ","y":"Ag"}`,
+		`{"kty":"EC","crv":"secp256k1","x":"AQ","y":"Ag"}`,
+		`{"kty":"RSA","n":"` + rfc7638N + `"}`,
+		`{"kty":"oct","k":"AQ"}`,
+	} {
+		if _, err := jwkDecode([]byte(jwk)); err == nil {
+			t.Errorf("invalid JWK %s decoded", jwk)
+		}
+	}
+}
+
+func TestClaimKey(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, err := x509.MarshalPKIXPublicKey(ecKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	kid, err := JWKThumbprint(ecKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := NewKeySet(ecKey.Public())
+	if err != nil {
+		t.Fatalf("NewKeySet: %v", err)
+	}
+
+	for _, test := range []struct {
+		name     string
+		claim    *IDClaim
+		resolver KeyResolver
+		valid    bool
+	}{
+		{"pk", &IDClaim{CN: "a", PK: pk}, nil, true},
+		{"kid", &IDClaim{CN: "a", Kid: kid}, keys, true},
+		{"pk and kid", &IDClaim{CN: "a", PK: pk, Kid: kid}, nil, true},
+		{"pk and other kid", &IDClaim{CN: "a", PK: pk, Kid: rfc7638Thumbprint}, nil, false},
+		{"kid without resolver", &IDClaim{CN: "a", Kid: kid}, nil, false},
+		{"unknown kid", &IDClaim{CN: "a", Kid: rfc7638Thumbprint}, keys, false},
+		{"no key", &IDClaim{CN: "a"}, keys, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			key, err := claimKey(test.claim, test.resolver)
+			if !test.valid {
+				if err == nil {
+					t.Fatal("invalid claim key resolved")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("claimKey: %v", err)
+			}
+			if !ecKey.PublicKey.Equal(key) {
+				t.Error("resolved another key")
+			}
+		})
+	}
+}
+
+func TestKeyResolvers(t *testing.T) {
+	rsaKey := rfc7638Key(t)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKeys, err := NewKeySet(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKeys, err := NewKeySet(ecKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKid, err := JWKThumbprint(ecKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resolvers := KeyResolvers{rsaKeys, ecKeys}
+	if key, err := resolvers.ResolveKey(rfc7638Thumbprint); err != nil || !rsaKey.Equal(key) {
+		t.Errorf("RSA key not resolved: %v", err)
+	}
+	if key, err := resolvers.ResolveKey(ecKid); err != nil || !ecKey.PublicKey.Equal(key) {
+		t.Errorf("EC key not resolved: %v", err)
+	}
+	if _, err := resolvers.ResolveKey("unknown"); err == nil {
+		t.Error("unknown key resolved")
+	}
+}
+
+func TestKeysFromLSVID(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	svids := []*x509svid.SVID{ca.newSVID(t, "/a"), ca.newSVID(t, "/b"), ca.newSVID(t, "/c")}
+	lsvid := ca.newChain(t, svids, nil)
+
+	keys, err := KeysFromLSVID(lsvid.Token)
+	if err != nil {
+		t.Fatalf("KeysFromLSVID: %v", err)
+	}
+	// Issuer LSVIDs of the hops of /a and /b
+	for _, svid := range svids[:2] {
+		kid, err := JWKThumbprint(svid.PrivateKey.Public())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := keys.ResolveKey(kid); err != nil {
+			t.Errorf("key of %s not found", svid.ID)
+		}
+	}
+	if len(keys) != 2 {
+		t.Errorf("got %d keys, want 2", len(keys))
+	}
+}
+
+func TestJWKSKeyResolver(t *testing.T) {
+	rsaKey := rfc7638Key(t)
+	rsaJWK, err := jwkEncode(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecJWK, err := jwkEncode(ecKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKid, err := JWKThumbprint(ecKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The EC key is published after the first fetch, along with an unsupported key
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fetches.Add(1) == 1 {
+			w.Write([]byte(`{"keys":[` + rsaJWK + `]}`))
+			return
+		}
+		w.Write([]byte(`{"keys":[` + rsaJWK + `,` + ecJWK + `,{"kty":"oct","k":"AQ"}]}`))
+	}))
+	defer server.Close()
+
+	resolver := NewJWKSKeyResolver(server.Client(), server.URL, time.Hour)
+	if key, err := resolver.ResolveKey(rfc7638Thumbprint); err != nil || !rsaKey.Equal(key) {
+		t.Fatalf("RSA key not resolved: %v", err)
+	}
+	if _, err := resolver.ResolveKey(rfc7638Thumbprint); err != nil {
+		t.Fatalf("cached RSA key not resolved: %v", err)
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("got %d fetches, want 1", n)
+	}
+
+	// Unknown keys refresh the set, past the minimum refresh interval
+	resolver.attempted = resolver.attempted.Add(-minJWKSRefresh)
+	if key, err := resolver.ResolveKey(ecKid); err != nil || !ecKey.PublicKey.Equal(key) {
+		t.Fatalf("EC key not resolved: %v", err)
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("got %d fetches, want 2", n)
+	}
+	if _, err := resolver.ResolveKey("unknown"); err == nil {
+		t.Error("unknown key resolved")
+	}
+}
+
+func TestJWKSKeyResolverRefresh(t *testing.T) {
+	rsaKey := rfc7638Key(t)
+	rsaJWK, err := jwkEncode(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fetches atomic.Int32
+	entered, release := make(chan struct{}, 1), make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fetches.Add(1) > 1 {
+			entered <- struct{}{}
+			<-release
+		}
+		w.Write([]byte(`{"keys":[` + rsaJWK + `]}`))
+	}))
+	defer server.Close()
+
+	resolver := NewJWKSKeyResolver(server.Client(), server.URL, time.Hour)
+	if _, err := resolver.ResolveKey(rfc7638Thumbprint); err != nil {
+		t.Fatalf("RSA key not resolved: %v", err)
+	}
+
+	// unknown keys do not refresh the set within the minimum interval
+	for _, kid := range []string{"unknown-a", "unknown-b", "unknown-a"} {
+		if _, err := resolver.ResolveKey(kid); err == nil {
+			t.Errorf("key %s resolved", kid)
+		}
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("got %d fetches, want 1", n)
+	}
+
+	// known keys are resolved while the set is fetched
+	resolver.attempted = resolver.attempted.Add(-minJWKSRefresh)
+	done := make(chan error)
+	go func() {
+		_, err := resolver.ResolveKey("unknown-b")
+		done <- err
+	}()
+	<-entered
+	if _, err := resolver.ResolveKey(rfc7638Thumbprint); err != nil {
+		t.Errorf("RSA key not resolved during fetch: %v", err)
+	}
+	close(release)
+	if err := <-done; err == nil {
+		t.Error("unknown key resolved")
+	}
+
+	// keys missing from the fetched set are not refetched
+	resolver.attempted = resolver.attempted.Add(-minJWKSRefresh)
+	if _, err := resolver.ResolveKey("unknown-b"); err == nil {
+		t.Error("unknown key resolved")
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("got %d fetches, want 2", n)
+	}
+	if _, ok := resolver.missing["unknown-b"]; !ok {
+		t.Error("missing key not remembered")
+	}
+}
+
+func TestJWKSKeyResolverError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	resolver := NewJWKSKeyResolver(server.Client(), server.URL, time.Hour)
+	if _, err := resolver.ResolveKey(rfc7638Thumbprint); err == nil {
+		t.Error("key resolved from failed JWKS endpoint")
+	}
+}
@@ -0,0 +1,248 @@
+package lsvid
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+)
+
+// Severity of a lint finding.
+type Severity string
+
+const (
+	SeverityInfo    Severity = "info"
+	SeverityWarning Severity = "warning"
+	SeverityError   Severity = "error"
+)
+
+// Lint rules.
+const (
+	RuleLoop          = "loop"           // the same SPIFFE ID issues two hops of a path
+	RuleIatBackwards  = "iat-backwards"  // a hop is issued before its parent
+	RuleTrustDomain   = "unexpected-td"  // a hop is issued by an unexpected trust domain
+	RuleInvalidIssuer = "invalid-issuer" // the issuer is not a SPIFFE ID
+	RuleAlgMismatch   = "alg-mismatch"   // the alg claim does not match the issuer key
+	RuleOversized     = "oversized"      // too many hops, or too large encoded chain
+	RuleMissingDpr    = "missing-dpr"    // delegation without principal
+	RuleRedacted      = "redacted"       // the hop is redacted, and can not be linted
+)
+
+const (
+	defaultLintMaxHops = 10
+	defaultLintMaxSize = 64 * 1024
+)
+
+// Finding is a suspicious property of a chain, found by Lint. Hop is the depth
+// of the hop from the outer most one, or -1 for findings on the whole chain.
+type Finding struct {
+	Rule     string   `json:"rule"`
+	Severity Severity `json:"severity"`
+	Hop      int      `json:"hop"`
+	Issuer   string   `json:"iss,omitempty"`
+	Message  string   `json:"message"`
+}
+
+func (f *Finding) String() string {
+	if f.Hop < 0 {
+		return fmt.Sprintf("%s: %s: %s", f.Severity, f.Rule, f.Message)
+	}
+	return fmt.Sprintf("%s: %s: hop %d (%s): %s", f.Severity, f.Rule, f.Hop, f.Issuer, f.Message)
+}
+
+// LintOption is an option used when linting LSVIDs.
+type LintOption interface {
+	apply(config *lintConfig)
+}
+
+type lintConfig struct {
+	trustDomains []spiffeid.TrustDomain
+	maxHops      int
+	maxSize      int
+}
+
+type lintOption func(*lintConfig)
+
+func (fn lintOption) apply(config *lintConfig) {
+	fn(config)
+}
+
+// WithLintTrustDomains sets the trust domains expected to issue the hops.
+// If not used, any trust domain is accepted.
+func WithLintTrustDomains(tds ...spiffeid.TrustDomain) LintOption {
+	return lintOption(func(config *lintConfig) {
+		config.trustDomains = tds
+	})
+}
+
+// WithLintMaxHops sets the number of hops of a path above which the chain is
+// oversized (10 by default).
+func WithLintMaxHops(max int) LintOption {
+	return lintOption(func(config *lintConfig) {
+		config.maxHops = max
+	})
+}
+
+// WithLintMaxSize sets the encoded size, in bytes, above which the chain is
+// oversized (64 KiB by default).
+func WithLintMaxSize(max int) LintOption {
+	return lintOption(func(config *lintConfig) {
+		config.maxSize = max
+	})
+}
+
+// Lint flags suspicious chains, that may still be valid: loops, issue times going
+// backwards, unexpected trust domains, algorithms not matching the issuer keys,
+// oversized chains and delegations without principal (Dpr). It does not verify
+// signatures, so the chain should also be validated.
+func Lint(lsvid *Token, opts ...LintOption) []*Finding {
+	config := &lintConfig{
+		maxHops: defaultLintMaxHops,
+		maxSize: defaultLintMaxSize,
+	}
+	for _, opt := range opts {
+		opt.apply(config)
+	}
+
+	var findings []*Finding
+	report := func(rule string, severity Severity, hop int, token *Token, format string, args ...interface{}) {
+		finding := &Finding{
+			Rule:     rule,
+			Severity: severity,
+			Hop:      hop,
+			Message:  fmt.Sprintf(format, args...),
+		}
+		if token != nil && token.Payload != nil {
+			finding.Issuer = idClaimCN(token.Payload.Iss)
+		}
+		findings = append(findings, finding)
+	}
+
+	if tokenJSON, err := json.Marshal(lsvid); err == nil && len(tokenJSON) > config.maxSize {
+		report(RuleOversized, SeverityWarning, -1, nil, "encoded chain of %d bytes, above %d", len(tokenJSON), config.maxSize)
+	}
+
+	// hop checks, once per token
+	var delegated, principal bool
+	Walk(lsvid, func(token *Token, depth int) error {
+		payload := token.Payload
+		if payload == nil {
+			report(RuleRedacted, SeverityInfo, depth, token, "redacted hop")
+			return nil
+		}
+		if len(token.Parents()) > 0 {
+			delegated = true
+		}
+		if payload.Dpr != "" || len(payload.Sd) > 0 {
+			principal = true
+		}
+		if payload.Dpa != "" && payload.Dpr == "" && len(payload.Sd) == 0 {
+			report(RuleMissingDpr, SeverityError, depth, token, "delegation asserted by %s without principal", payload.Dpa)
+		}
+
+		if payload.Iss == nil {
+			report(RuleInvalidIssuer, SeverityError, depth, token, "hop has no issuer")
+			return nil
+		}
+		td, err := spiffeid.TrustDomainFromString(payload.Iss.CN)
+		if err != nil {
+			report(RuleInvalidIssuer, SeverityWarning, depth, token, "issuer is not a SPIFFE ID: %v", err)
+		} else if len(config.trustDomains) > 0 && indexOfTrustDomain(config.trustDomains, td) < 0 {
+			report(RuleTrustDomain, SeverityError, depth, token, "issued by unexpected trust domain %s", td)
+		}
+
+		if key := lintIssuerKey(token); key != nil {
+			if err := checkAlg(payload.Alg, key); err != nil {
+				report(RuleAlgMismatch, SeverityError, depth, token, "%v", err)
+			}
+		}
+		return nil
+	})
+	if delegated && !principal {
+		report(RuleMissingDpr, SeverityWarning, -1, nil, "delegated chain without principal (dpr)")
+	}
+
+	// path checks, from the outer most hop to each root
+	var path []*Token
+	var depthReported bool
+	var walkPath func(token *Token)
+	walkPath = func(token *Token) {
+		depth := len(path)
+		if depth+1 > config.maxHops && !depthReported {
+			report(RuleOversized, SeverityWarning, -1, nil, "path of more than %d hops", config.maxHops)
+			depthReported = true
+		}
+
+		if child := lastToken(path); child != nil && child.Payload != nil && token.Payload != nil {
+			if child.Payload.Iat != 0 && token.Payload.Iat != 0 && child.Payload.Iat < token.Payload.Iat {
+				report(RuleIatBackwards, SeverityWarning, depth-1, child, "issued at %d, before its parent hop (%d)", child.Payload.Iat, token.Payload.Iat)
+			}
+		}
+		if len(token.Parents()) > 0 && token.Payload != nil && token.Payload.Iss != nil {
+			for later, hop := range path {
+				if hop.Payload != nil && hop.Payload.Iss != nil && hop.Payload.Iss.CN == token.Payload.Iss.CN {
+					report(RuleLoop, SeverityWarning, depth, token, "%s also issues hop %d", token.Payload.Iss.CN, later)
+					break
+				}
+			}
+		}
+
+		path = append(path, token)
+		for _, parent := range token.Parents() {
+			walkPath(parent)
+		}
+		path = path[:len(path)-1]
+	}
+	walkPath(lsvid)
+
+	return findings
+}
+
+// lintIssuerKey returns the issuer key of the hop, if embedded in the chain.
+func lintIssuerKey(token *Token) crypto.PublicKey {
+	claim := token.Payload.Iss
+	if len(token.Parents()) > 0 {
+		if claim.ID == nil {
+			return nil
+		}
+		issLSVID := innermost(claim.ID)
+		if issLSVID.Payload == nil || issLSVID.Payload.Sub == nil {
+			return nil
+		}
+		claim = issLSVID.Payload.Sub
+	}
+	if len(claim.PK) == 0 {
+		return nil
+	}
+	key, err := claimKey(claim, nil)
+	if err != nil {
+		return nil
+	}
+	return key
+}
+
+// checkAlg checks the JWS algorithm name of the hop against the issuer key.
+func checkAlg(alg string, key crypto.PublicKey) error {
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		expected, err := keyAlgorithm(key)
+		if err != nil || alg != expected {
+			return fmt.Errorf("alg %q does not match %s key (%s)", alg, key.Curve.Params().Name, expected)
+		}
+	case *rsa.PublicKey:
+		if alg != "RS256" && alg != "PS256" {
+			return fmt.Errorf("alg %q does not match RSA key", alg)
+		}
+	}
+	return nil
+}
+
+func lastToken(tokens []*Token) *Token {
+	if len(tokens) == 0 {
+		return nil
+	}
+	return tokens[len(tokens)-1]
+}
@@ -0,0 +1,163 @@
+package lsvid
+
+import (
+	"testing"
+
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+)
+
+func TestLint(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	a, b, c := ca.newSVID(t, "/a"), ca.newSVID(t, "/b"), ca.newSVID(t, "/c")
+	// Chains delegated on behalf of a principal, unless edited
+	withDpr := func(edit func(hop int, payload *Payload)) func(hop int, payload *Payload) {
+		return func(hop int, payload *Payload) {
+			if hop == 0 {
+				payload.Dpr = "user"
+			}
+			if edit != nil {
+				edit(hop, payload)
+			}
+		}
+	}
+
+	for _, test := range []struct {
+		name     string
+		svids    []*x509svid.SVID
+		edit     func(hop int, payload *Payload)
+		opts     []LintOption
+		rule     string
+		severity Severity
+		hop      int
+	}{
+		{
+			name:     "loop",
+			svids:    []*x509svid.SVID{a, b, a, c},
+			rule:     RuleLoop,
+			severity: SeverityWarning,
+			hop:      2,
+		},
+		{
+			name:  "iat backwards",
+			svids: []*x509svid.SVID{a, b, c},
+			edit: func(hop int, payload *Payload) {
+				if hop == 1 {
+					payload.Iat -= 60
+				}
+			},
+			rule:     RuleIatBackwards,
+			severity: SeverityWarning,
+			hop:      0,
+		},
+		{
+			name:     "unexpected trust domain",
+			svids:    []*x509svid.SVID{a, b, c},
+			opts:     []LintOption{WithLintTrustDomains(spiffeid.RequireTrustDomainFromString("partner.org"))},
+			rule:     RuleTrustDomain,
+			severity: SeverityError,
+			hop:      0,
+		},
+		{
+			name:  "alg mismatch",
+			svids: []*x509svid.SVID{a, b, c},
+			edit: func(hop int, payload *Payload) {
+				if hop == 1 {
+					payload.Alg = "ES384"
+				}
+			},
+			rule:     RuleAlgMismatch,
+			severity: SeverityError,
+			hop:      0,
+		},
+		{
+			name:     "too many hops",
+			svids:    []*x509svid.SVID{a, b, c},
+			opts:     []LintOption{WithLintMaxHops(2)},
+			rule:     RuleOversized,
+			severity: SeverityWarning,
+			hop:      -1,
+		},
+		{
+			name:     "too large",
+			svids:    []*x509svid.SVID{a, b, c},
+			opts:     []LintOption{WithLintMaxSize(1024)},
+			rule:     RuleOversized,
+			severity: SeverityWarning,
+			hop:      -1,
+		},
+		{
+			name:  "delegation without principal",
+			svids: []*x509svid.SVID{a, b, c},
+			edit: func(hop int, payload *Payload) {
+				if hop == 1 {
+					payload.Dpr, payload.Dpa = "", "spiffe://example.org/b"
+				}
+			},
+			rule:     RuleMissingDpr,
+			severity: SeverityError,
+			hop:      0,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			lsvid := ca.newChain(t, test.svids, withDpr(test.edit))
+			var found bool
+			for _, finding := range Lint(lsvid.Token, test.opts...) {
+				if finding.Rule == test.rule && finding.Severity == test.severity && finding.Hop == test.hop {
+					found = true
+				} else if finding.Rule != test.rule && finding.Severity == SeverityError {
+					t.Errorf("unexpected finding %s", finding)
+				}
+			}
+			if !found {
+				t.Errorf("no %s %s finding on hop %d", test.severity, test.rule, test.hop)
+			}
+		})
+	}
+
+	t.Run("chain without principal", func(t *testing.T) {
+		lsvid := ca.newChain(t, []*x509svid.SVID{a, b, c}, nil)
+		findings := Lint(lsvid.Token)
+		if len(findings) != 1 || findings[0].Rule != RuleMissingDpr || findings[0].Severity != SeverityWarning || findings[0].Hop != -1 {
+			t.Errorf("got findings %v, want a chain missing-dpr warning", findings)
+		}
+	})
+}
+
+func TestLintValidChain(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	svids := []*x509svid.SVID{ca.newSVID(t, "/a"), ca.newSVID(t, "/b"), ca.newSVID(t, "/c"), ca.newSVID(t, "/d")}
+	lsvid := ca.newChain(t, svids, func(hop int, payload *Payload) {
+		if hop == 0 {
+			payload.Dpr = "user"
+		}
+	})
+	if valid, err := Validate(lsvid.Token, WithBundleSource(newTestTrustBundle(t, ca))); !valid {
+		t.Fatalf("LSVID rejected: %v", err)
+	}
+
+	for _, finding := range Lint(lsvid.Token, WithLintTrustDomains(ca.td)) {
+		t.Errorf("unexpected finding %s", finding)
+	}
+}
+
+func TestCheckAlg(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	if err := checkAlg("ES256", ca.key.Public()); err != nil {
+		t.Errorf("ES256 rejected for P-256 key: %v", err)
+	}
+	for _, alg := range []string{"ES384", "RS256", ""} {
+		if err := checkAlg(alg, ca.key.Public()); err == nil {
+			t.Errorf("%q accepted for P-256 key", alg)
+		}
+	}
+	rsaKey := rfc7638Key(t)
+	for _, alg := range []string{"RS256", "PS256"} {
+		if err := checkAlg(alg, rsaKey); err != nil {
+			t.Errorf("%s rejected for RSA key: %v", alg, err)
+		}
+	}
+	if err := checkAlg("ES256", rsaKey); err == nil {
+		t.Error("ES256 accepted for RSA key")
+	}
+}
@@ -2,6 +2,16 @@
 //
 // to provide, extend and validate Lightweight SVID (LSVID).
 // Specification document <https://docs.google.com/document/d/15rfAkzNTQa1ycs-fn9hyIYV5HbznPBsxB-f0vxhNJ24/>
+//
+// A tier forwarding an LSVID can skip the hops it already verified with a VerificationCache
+// (WithVerificationCache), e.g.: the one shared by the handlers of the process
+// (SharedVerificationCache). The cache keeps the nested tokens verified by Validate, keyed
+// by their digest and by the scope given by the caller for its trust sources (bundle source,
+// JWT bundle source and key resolver), so a token verified under one trust configuration is
+// verified again under another. Tokens are also verified again when a versioned source
+// (e.g.: BundleSet) is updated, or after VerificationCache.Purge. The verification of a
+// token covers its signature and its parents; constraints, caveats, status and policies
+// are still checked on the whole chain.
 package lsvid
 
 import (
@@ -9,6 +19,7 @@ import (
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/rand"
+	"crypto/rsa"
 	hash256 "crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
@@ -17,6 +28,8 @@ import (
 	"log"
 	"time"
 
+	"github.com/spiffe/go-spiffe/v2/bundle/jwtbundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
 	"github.com/spiffe/go-spiffe/v2/svid/jwtsvid"
 	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
 	"github.com/spiffe/go-spiffe/v2/workloadapi"
@@ -25,12 +38,30 @@ import (
 type LSVID struct {
 	Token  *Token `json:"token"`  // The workload LSVID document
 	Bundle *Token `json:"bundle"` // The Trust bundle document
+
+	// Disclosures of the concealed claims. They are not signed, so any forwarder
+	// can drop them. Check Conceal.
+	Disclosures []string `json:"disclosures,omitempty"`
+
+	// Discharges of the third party caveats. Check Discharge.
+	Discharges []*Token `json:"discharges,omitempty"`
+
+	// Inclusion proofs of the hops recorded in a transparency log. Check TransparencyLog.
+	Inclusions []*InclusionProof `json:"inclusions,omitempty"`
 }
 
 type Token struct {
 	Nested    *Token   `json:"nested,omitempty"`
+	Joined    []*Token `json:"joined,omitempty"` // parent chains of a join hop. Check Join.
 	Payload   *Payload `json:"payload"`
 	Signature []byte   `json:"signature"`
+
+	// JWT holds a standard JWT-SVID when the token is a JWT-SVID root.
+	// Check FromJWTSVID.
+	JWT string `json:"jwt,omitempty"`
+
+	// Commit replaces the payload of a redacted hop. Check Redact.
+	Commit []byte `json:"commit,omitempty"`
 }
 
 // The set of claims and any existing previous token a given signature refers to.
@@ -53,6 +84,54 @@ type Payload struct {
 	Dpa string                 `json:"dpa,omitempty"`
 	Dpr string                 `json:"dpr,omitempty"`
 	Sel map[string]interface{} `json:"sel,omitempty"`
+
+	// Additional audiences of fan-out hops, that call more than one workload.
+	Auds []*IDClaim `json:"auds,omitempty"`
+
+	// Salted digests of the concealed claims. Check Conceal.
+	Sd []string `json:"_sd,omitempty"`
+
+	// Claims encrypted to the audience. Check EncryptClaims.
+	Enc *EncryptedClaims `json:"enc,omitempty"`
+
+	// Random nonce, hiding the payload of redacted hops. Check Redact.
+	Nce []byte `json:"nce,omitempty"`
+
+	// Constraints on the later hops, set by the originator. Check Constraints.
+	Cst *Constraints `json:"cst,omitempty"`
+
+	// Scope granted to the audience. Later hops can only narrow it. Check EffectiveScope.
+	Scp []string `json:"scp,omitempty"`
+
+	// Biscuit-compatible Datalog block. Check Authorizer.
+	Blk *Block `json:"blk,omitempty"`
+
+	// Third party caveats, and the binding of discharge LSVIDs. Check Discharge.
+	Tpc []*ThirdPartyCaveat `json:"tpc,omitempty"`
+	Dch *DischargeBinding   `json:"dch,omitempty"`
+
+	// Status of the hop, and the claim of signed status lists. Check StatusList.
+	Sts *StatusRef       `json:"sts,omitempty"`
+	Lst *StatusListClaim `json:"lst,omitempty"`
+
+	// Tree head claim of signed tree heads. Check TransparencyLog.
+	Sth *TreeHead `json:"sth,omitempty"`
+
+	// Request the hop was sent with. Check BindRequest.
+	Req *RequestBinding `json:"req,omitempty"`
+
+	// Hop timing, in Unix milliseconds. Check SetHopTiming.
+	Rcv int64 `json:"rcv,omitempty"` // when the issuer received the request it delegates
+	Fwd int64 `json:"fwd,omitempty"` // when the issuer forwarded the hop
+
+	// W3C Trace Context of the hop request. Check InjectTrace.
+	Trc *TraceContext `json:"trc,omitempty"`
+
+	// Trust bundle claims, only present in bundle LSVIDs.
+	// Check NewBundle.
+	Seq uint64   `json:"seq,omitempty"` // bundle sequence number
+	Rfh int64    `json:"rfh,omitempty"` // refresh hint, in seconds
+	Aut [][]byte `json:"aut,omitempty"` // PKIX DER encoded authority keys
 }
 
 // Identity claims encapsulates uniquely involved actors
@@ -62,6 +141,9 @@ type IDClaim struct {
 	CN string `json:"cn,omitempty"` // e.g.: spiffe://example.org/workload
 	PK []byte `json:"pk,omitempty"` // e.g.: VGhpcyBpcyBteSBQdWJsaWMgS2V5
 	ID *Token `json:"id,omitempty"` // e.g.: a complete LSVID
+
+	// JWK thumbprint (RFC 7638) of the key, in place of or along with PK. Check KeyRef.
+	Kid string `json:"kid,omitempty"`
 }
 
 //	Encode encodes an LSVID struct into a string.
@@ -112,6 +194,9 @@ func Decode(encLSVID string) (*LSVID, error) {
 // This function takes an existing LSVID, a new payload, and a cryptographic signer, and
 // creates an extended LSVID by nesting the existing token and the new payload. It then
 // marshals the extended token to JSON, signs it, and encodes the signed LSVID to a string.
+// If the new payload version is VerHashLinked, the hop is signed as in signingHash.
+// The disclosures and discharges of lsvid are kept. Disclosures of claims concealed in the new
+// payload must be appended to lsvid.Disclosures before extending.
 func Extend(lsvid *LSVID, newPayload *Payload, key crypto.Signer) (string, error) {
 	// TODO: Modify the payload struct to support custom claims (maybe using map[string]{interface})
 	// Create the extended LSVID structure
@@ -120,15 +205,20 @@ func Extend(lsvid *LSVID, newPayload *Payload, key crypto.Signer) (string, error
 		Payload: newPayload,
 	}
 
-	// Marshal to JSON
-	tmpToSign, err := json.Marshal(token)
-	if err != nil {
-		return "", fmt.Errorf("Error generating json: %v\n", err)
+	// Hash linked hops can be redacted, so a nonce hides their payload
+	if newPayload.Ver == VerHashLinked && len(newPayload.Nce) == 0 {
+		newPayload.Nce = make([]byte, 16)
+		if _, err := rand.Read(newPayload.Nce); err != nil {
+			return "", fmt.Errorf("Error generating nonce: %v\n", err)
+		}
 	}
 
 	// Sign extlSVID
-	hash := hash256.Sum256(tmpToSign)
-	s, err := key.Sign(rand.Reader, hash[:], crypto.SHA256)
+	hash, err := signingHash(token)
+	if err != nil {
+		return "", err
+	}
+	s, err := key.Sign(rand.Reader, hash, crypto.SHA256)
 	if err != nil {
 		return "", fmt.Errorf("Error generating signed assertion: %v\n", err)
 	}
@@ -138,8 +228,11 @@ func Extend(lsvid *LSVID, newPayload *Payload, key crypto.Signer) (string, error
 
 	// Create the extended LSVID
 	extLSVID := &LSVID{
-		Token:  token,
-		Bundle: lsvid.Bundle,
+		Token:       token,
+		Bundle:      lsvid.Bundle,
+		Disclosures: lsvid.Disclosures,
+		Discharges:  lsvid.Discharges,
+		Inclusions:  lsvid.Inclusions,
 	}
 
 	// Encode signed LSVID
@@ -151,88 +244,486 @@ func Extend(lsvid *LSVID, newPayload *Payload, key crypto.Signer) (string, error
 	return outLSVID, nil
 }
 
+// signRoot signs the payload as the inner most (root) token of an LSVID,
+// as expected by Validate.
+func signRoot(payload *Payload, key crypto.Signer) (*Token, error) {
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("Error generating json: %v\n", err)
+	}
+
+	hash := hash256.Sum256(payloadJSON)
+	s, err := key.Sign(rand.Reader, hash[:], crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("Error signing LSVID: %v\n", err)
+	}
+
+	return &Token{
+		Payload:   payload,
+		Signature: s,
+	}, nil
+}
+
+// ValidateOption is an option used when validating LSVIDs.
+type ValidateOption interface {
+	apply(config *validateConfig)
+}
+
+type validateConfig struct {
+	bundleSource    BundleSource
+	jwtBundleSource jwtbundle.Source
+	tdPolicy        TrustDomainPolicy
+	redactionPolicy RedactionPolicy
+	disclosures     []string
+	requiredScope   []string
+	authorizer      *Authorizer
+	discharges      []*Token
+	statusLists     *StatusListCache
+	keyResolver     KeyResolver
+	verifyCache     *VerificationCache
+	verifyScope     string
+}
+
+type validateOption func(*validateConfig)
+
+func (fn validateOption) apply(config *validateConfig) {
+	fn(config)
+}
+
+// WithBundleSource sets the trust bundles used to validate the root LSVIDs.
+// The inner most token, and the LSVID of each issuer, must be signed by
+// an authority of the bundle of its trust domain, resolved from the Iss claim.
+// If not used, the root key is taken from the LSVID itself.
+func WithBundleSource(source BundleSource) ValidateOption {
+	return validateOption(func(config *validateConfig) {
+		config.bundleSource = source
+	})
+}
+
+// WithJWTBundleSource sets the JWT bundles used to validate JWT-SVID roots.
+// It is required to validate LSVIDs created with FromJWTSVID.
+func WithJWTBundleSource(source jwtbundle.Source) ValidateOption {
+	return validateOption(func(config *validateConfig) {
+		config.jwtBundleSource = source
+	})
+}
+
+// WithDisclosures sets the disclosures presented with the LSVID.
+// Each one must match a digest signed by one of the chain hops.
+func WithDisclosures(disclosures []string) ValidateOption {
+	return validateOption(func(config *validateConfig) {
+		config.disclosures = disclosures
+	})
+}
+
+// WithRedactionPolicy sets the policy authorizing redacted hops (see Redact).
+// If not used, LSVIDs with redacted hops are rejected.
+func WithRedactionPolicy(policy RedactionPolicy) ValidateOption {
+	return validateOption(func(config *validateConfig) {
+		config.redactionPolicy = policy
+	})
+}
+
+// WithRequiredScope requires the effective scope of the LSVID (see EffectiveScope)
+// to include every given scope. Unscoped LSVIDs are rejected.
+func WithRequiredScope(scopes ...string) ValidateOption {
+	return validateOption(func(config *validateConfig) {
+		config.requiredScope = scopes
+	})
+}
+
+// WithAuthorizer evaluates the LSVID blocks with the given authorizer,
+// once the LSVID is valid.
+func WithAuthorizer(authorizer *Authorizer) ValidateOption {
+	return validateOption(func(config *validateConfig) {
+		config.authorizer = authorizer
+	})
+}
+
+// WithDischarges sets the discharges presented with the LSVID (LSVID.Discharges).
+// Every third party caveat of the chain requires a valid discharge.
+func WithDischarges(discharges []*Token) ValidateOption {
+	return validateOption(func(config *validateConfig) {
+		config.discharges = discharges
+	})
+}
+
+// WithStatusListCache checks the status of the hops that reference a status list,
+// rejecting the revoked ones. The lists are fetched and cached by cache, and
+// their issuer LSVIDs are validated against the bundle source, that is required.
+func WithStatusListCache(cache *StatusListCache) ValidateOption {
+	return validateOption(func(config *validateConfig) {
+		config.statusLists = cache
+	})
+}
+
+// WithKeyResolver sets the resolver of the keys referenced by JWK thumbprint (IDClaim.Kid).
+// Root keys referenced by thumbprint are also resolved from the trust bundles.
+func WithKeyResolver(resolver KeyResolver) ValidateOption {
+	return validateOption(func(config *validateConfig) {
+		config.keyResolver = resolver
+	})
+}
+
+// WithVerificationCache skips the verification of the nested tokens (and issuer LSVIDs)
+// already verified in the scope, kept in the cache. The scope names the trust sources
+// of the caller (e.g.: "target-wl"): validations with other bundle sources, JWT bundle
+// sources or key resolvers must use another scope. Check VerificationCache.
+func WithVerificationCache(cache *VerificationCache, scope string) ValidateOption {
+	return validateOption(func(config *validateConfig) {
+		config.verifyCache, config.verifyScope = cache, scope
+	})
+}
+
+// WithTrustDomainPolicy sets the policy authorizing the trust domains
+// that may appear in the chain, and in what order.
+func WithTrustDomainPolicy(policy TrustDomainPolicy) ValidateOption {
+	return validateOption(func(config *validateConfig) {
+		config.tdPolicy = policy
+	})
+}
+
 // Validate verifies the validity of a nested token structure.
 //
 // This function takes a token, verifies the linkage between the audience (Aud) and issuer (Iss)
 // claims for each nested token, and validates the signatures using the public keys. It returns
 // a boolean indicating whether the validation was successful and any error encountered during
 // the validation process.
-// TODO: include the root (trust bundle) LSVID as parameter, to validate the inner signature
-// TODO: With the bundle being part of LSVID, now it is possible to validate the root.
-// TODO: Must include the bundle in validate parameters and the necessary verifications.
-func Validate(lsvid *Token) (bool, error) {
+// When a bundle source is given (WithBundleSource), the root (trust bundle) signatures are
+// also validated, each one against the bundle of its own trust domain.
+// JWT-SVID roots (FromJWTSVID) are validated against the JWT bundles given by WithJWTBundleSource.
+// Constraints set in the chain hops are always enforced, as well as scopes only narrowing
+// and third party caveats. Discharges and status lists are only accepted with a bundle source.
+func Validate(lsvid *Token, opts ...ValidateOption) (bool, error) {
+	config := &validateConfig{}
+	for _, opt := range opts {
+		opt.apply(config)
+	}
 
-	for lsvid.Nested != nil {
+	valid, err := validate(lsvid, config)
+	if err != nil || !valid {
+		return valid, err
+	}
+	if _, err := MatchDisclosures(lsvid, config.disclosures); err != nil {
+		return false, err
+	}
+	if err := checkConstraints(lsvid, time.Now()); err != nil {
+		return false, err
+	}
+	if err := checkCaveats(lsvid, config.discharges, config); err != nil {
+		return false, err
+	}
+	if config.statusLists != nil {
+		if err := config.statusLists.checkStatus(context.Background(), lsvid, config); err != nil {
+			return false, err
+		}
+	}
+	scope, scoped, err := EffectiveScope(lsvid)
+	if err != nil {
+		return false, err
+	}
+	if len(config.requiredScope) > 0 {
+		if !scoped {
+			return false, fmt.Errorf("LSVID has no scope\n")
+		}
+		for _, required := range config.requiredScope {
+			if !containsString(scope, required) {
+				return false, fmt.Errorf("Scope %q not granted\n", required)
+			}
+		}
+	}
+	if config.authorizer != nil {
+		if err := config.authorizer.Authorize(lsvid); err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func validate(lsvid *Token, config *validateConfig) (bool, error) {
+	// redacted hops, indexed from the outer most hop
+	var opaque []int
+
+	tdPaths, valid, err := validateHop(lsvid, 0, config, &opaque)
+	if err != nil || !valid {
+		return valid, err
+	}
+
+	return checkChainPolicies(config, tdPaths, opaque)
+}
+
+// validateHop validates the token and, recursively, its parents.
+// It returns the trust domains of the issuers of every path from the token
+// to a root, ordered from the token to the root.
+func validateHop(lsvid *Token, hop int, config *validateConfig, opaque *[]int) ([][]spiffeid.TrustDomain, bool, error) {
+	// Redacted hops are not cached, as the outer most hop can not be redacted
+	if config.verifyCache != nil && lsvid.Payload != nil {
+		return config.verifyCache.validateHop(lsvid, hop, config, opaque)
+	}
+	return validateHopUncached(lsvid, hop, config, opaque)
+}
 
-		// Check Aud -> Iss link
-		if lsvid.Payload.Iss.CN != lsvid.Nested.Payload.Aud.CN {
-			return false, fmt.Errorf("Aud -> Iss link validation failed\n")
+func validateHopUncached(lsvid *Token, hop int, config *validateConfig, opaque *[]int) ([][]spiffeid.TrustDomain, bool, error) {
+	parents := lsvid.Parents()
+	if lsvid.Nested != nil && len(lsvid.Joined) > 0 {
+		return nil, false, fmt.Errorf("Token can not be both nested and joined\n")
+	}
+	if len(lsvid.Joined) == 1 {
+		return nil, false, fmt.Errorf("Join hop must have at least two parents\n")
+	}
+	if len(parents) == 0 {
+		return validateRoot(lsvid, config)
+	}
+
+	if lsvid.Payload == nil {
+		// The redacted hop is covered by the signature of the outer hop,
+		// but its issuer and audience are unknown.
+		if hop == 0 {
+			return nil, false, fmt.Errorf("Outer most hop can not be redacted\n")
 		}
-		fmt.Printf("Aud -> Iss link validation successful!\n")
+		if len(lsvid.Commit) != hash256.Size {
+			return nil, false, fmt.Errorf("Invalid commitment of redacted hop %d\n", hop)
+		}
+		log.Printf("Hop %d is redacted (opaque)\n", hop)
+		*opaque = append(*opaque, hop)
+		return validateParents(parents, hop, config, opaque, nil)
+	}
+	hopTD, valid, err := verifyHop(lsvid, hop, config)
+	if err != nil || !valid {
+		return nil, valid, err
+	}
 
-		// Marshal the LSVID struct into JSON
-		tmpLSVID := &Token{
-			Nested:  lsvid.Nested,
-			Payload: lsvid.Payload,
+	// jump to the parent tokens
+	return validateParents(parents, hop, config, opaque, &hopTD)
+}
+
+// verifyHop verifies a hop with parents, without validating them: the Aud -> Iss
+// links of its parents, the issuer LSVID binding to the hop issuer and, with a
+// bundle source, to its trust domain, and the hop signature. It returns the
+// trust domain of the hop issuer.
+func verifyHop(lsvid *Token, hop int, config *validateConfig) (spiffeid.TrustDomain, bool, error) {
+	if lsvid.Payload.Iss == nil {
+		return spiffeid.TrustDomain{}, false, fmt.Errorf("Issuer of hop %d not found\n", hop)
+	}
+
+	// Check Aud -> Iss link of each parent, unless the parent is redacted
+	for _, parent := range lsvid.Parents() {
+		if parent.Payload == nil {
+			continue
 		}
-		lsvidJSON, err := json.Marshal(tmpLSVID)
-		if err != nil {
-			return false, fmt.Errorf("error marshaling LSVID to JSON: %v\n", err)
+		if !parent.Payload.HasAudience(lsvid.Payload.Iss.CN) {
+			return spiffeid.TrustDomain{}, false, fmt.Errorf("Aud -> Iss link validation failed\n")
 		}
-		hash := hash256.Sum256(lsvidJSON)
-
-		// Parse the public key
-		// TODO: Currently the pk is extracted from the iss lsvid that MUST be present
-		// and we are still not validating the trust bundle.
-		var issLSVID *Token
-		issLSVID = lsvid.Payload.Iss.ID
-		for issLSVID.Nested != nil {
-			// fmt.Printf("Issuer nested LSVID found! %v\n", issLSVID.Nested)
-			issLSVID = issLSVID.Nested
+		fmt.Printf("Aud -> Iss link validation successful!\n")
+	}
+
+	hopTD, err := spiffeid.TrustDomainFromString(lsvid.Payload.Iss.CN)
+	if err != nil && (config.bundleSource != nil || config.tdPolicy != nil) {
+		return spiffeid.TrustDomain{}, false, fmt.Errorf("Invalid issuer SPIFFE ID %q: %v\n", lsvid.Payload.Iss.CN, err)
+	}
+
+	// Compute the signed hash, according to the hop version
+	hash, err := signingHash(lsvid)
+	if err != nil {
+		return spiffeid.TrustDomain{}, false, err
+	}
+
+	// Parse the public key
+	// The pk is extracted from the iss lsvid that MUST be present.
+	if lsvid.Payload.Iss.ID == nil {
+		return spiffeid.TrustDomain{}, false, fmt.Errorf("Issuer LSVID not found for %s\n", lsvid.Payload.Iss.CN)
+	}
+	issLSVID := innermost(lsvid.Payload.Iss.ID)
+	if issLSVID.Payload == nil || issLSVID.Payload.Sub == nil {
+		return spiffeid.TrustDomain{}, false, fmt.Errorf("Invalid issuer LSVID for %s\n", lsvid.Payload.Iss.CN)
+	}
+	if issLSVID.JWT != "" {
+		// JWT-SVIDs do not bind a key to the subject
+		return spiffeid.TrustDomain{}, false, fmt.Errorf("Issuer LSVID of %s must not be a JWT-SVID root\n", lsvid.Payload.Iss.CN)
+	}
+	// the issuer LSVID must be issued to the issuer
+	if issLSVID.Payload.Sub.CN != lsvid.Payload.Iss.CN {
+		return spiffeid.TrustDomain{}, false, fmt.Errorf("Issuer LSVID subject does not match %s\n", lsvid.Payload.Iss.CN)
+	}
+	if config.bundleSource != nil {
+		// by its own trust domain
+		if issLSVID.Payload.Iss == nil || issLSVID.Payload.Iss.CN != hopTD.IDString() {
+			return spiffeid.TrustDomain{}, false, fmt.Errorf("Issuer LSVID of %s not issued by %s\n", lsvid.Payload.Iss.CN, hopTD.IDString())
 		}
-		issLSSubPk, err := x509.ParsePKIXPublicKey(issLSVID.Payload.Sub.PK)
+
+		// validate the issuer LSVID against its trust bundle
+		valid, err := validate(lsvid.Payload.Iss.ID, &validateConfig{
+			bundleSource:    config.bundleSource,
+			jwtBundleSource: config.jwtBundleSource,
+			keyResolver:     config.keyResolver,
+			verifyCache:     config.verifyCache,
+			verifyScope:     config.verifyScope,
+		})
 		if err != nil {
-			return false, fmt.Errorf("Failed to parse public key: %v\n", err)
+			return spiffeid.TrustDomain{}, false, fmt.Errorf("Error validating issuer LSVID: %v\n", err)
+		}
+		if !valid {
+			return spiffeid.TrustDomain{}, false, nil
 		}
+	}
+	issLSSubPk, err := claimKey(issLSVID.Payload.Sub, config.keyResolver)
+	if err != nil {
+		return spiffeid.TrustDomain{}, false, err
+	}
+
+	// validate the signature
+	log.Printf("Verifying signature created by %s\n", lsvid.Payload.Iss.CN)
+	verify := verifyDigest(issLSSubPk, hash[:], lsvid.Signature)
+	if verify == false {
+		fmt.Printf("\nSignature validation failed!\n\n")
+		return spiffeid.TrustDomain{}, false, nil
+	}
+	log.Printf("Signature validation successful!\n")
 
-		// validate the signature
-		log.Printf("Verifying signature created by %s\n", lsvid.Payload.Iss.CN)
-		verify := ecdsa.VerifyASN1(issLSSubPk.(*ecdsa.PublicKey), hash[:], lsvid.Signature)
-		if verify == false {
-			fmt.Printf("\nSignature validation failed!\n\n")
-			return false, nil
+	return hopTD, true, nil
+}
+
+// validateParents validates the parents of a hop, prefixing their trust domain
+// paths with the hop trust domain, if any.
+func validateParents(parents []*Token, hop int, config *validateConfig, opaque *[]int, hopTD *spiffeid.TrustDomain) ([][]spiffeid.TrustDomain, bool, error) {
+	var tdPaths [][]spiffeid.TrustDomain
+	for _, parent := range parents {
+		parentPaths, valid, err := validateHop(parent, hop+1, config, opaque)
+		if err != nil || !valid {
+			return nil, valid, err
+		}
+		for _, path := range parentPaths {
+			if hopTD != nil {
+				path = append([]spiffeid.TrustDomain{*hopTD}, path...)
+			}
+			tdPaths = append(tdPaths, path)
 		}
-		log.Printf("Signature validation successful!\n")
+	}
+	return tdPaths, true, nil
+}
 
-		// jump to nested token
-		lsvid = lsvid.Nested
+// validateRoot validates the inner most (root) token of a path.
+func validateRoot(lsvid *Token, config *validateConfig) ([][]spiffeid.TrustDomain, bool, error) {
+	if lsvid.Payload == nil {
+		return nil, false, fmt.Errorf("Root token can not be redacted\n")
+	}
+	if lsvid.JWT != "" {
+		rootTD, err := validateJWTRoot(lsvid, config.jwtBundleSource)
+		if err != nil {
+			return nil, false, err
+		}
+		return [][]spiffeid.TrustDomain{{rootTD}}, true, nil
+	}
+	if lsvid.Payload.Iss == nil {
+		return nil, false, fmt.Errorf("Root issuer not found\n")
 	}
 
-	// reached the inner most LSVID.
 	// Marshal the LSVID struct into JSON
 	lsvidJSON, err := json.Marshal(lsvid.Payload)
 	if err != nil {
-		return false, fmt.Errorf("error marshaling LSVID to JSON: %v\n", err)
+		return nil, false, fmt.Errorf("error marshaling LSVID to JSON: %v\n", err)
 	}
 	hash := hash256.Sum256(lsvidJSON)
 
+	rootTD, err := spiffeid.TrustDomainFromString(lsvid.Payload.Iss.CN)
+	if err != nil && (config.bundleSource != nil || config.tdPolicy != nil) {
+		return nil, false, fmt.Errorf("Invalid root issuer trust domain: %v\n", err)
+	}
+
+	var bundle *TrustBundle
+	resolver := config.keyResolver
+	if config.bundleSource != nil {
+		bundle, err = config.bundleSource.GetTrustBundleForTrustDomain(rootTD)
+		if err != nil {
+			return nil, false, fmt.Errorf("Unable to get trust bundle: %v\n", err)
+		}
+		bundleKeys, err := KeysFromTrustBundle(bundle)
+		if err != nil {
+			return nil, false, err
+		}
+		resolver = KeyResolvers{bundleKeys, config.keyResolver}
+		if config.keyResolver == nil {
+			resolver = bundleKeys
+		}
+	}
+
 	// Parse the public key
-	issPk, err := x509.ParsePKIXPublicKey(lsvid.Payload.Iss.PK)
+	issPk, err := claimKey(lsvid.Payload.Iss, resolver)
 	if err != nil {
-		return false, fmt.Errorf("Failed to parse public key: %v\n", err)
+		return nil, false, err
 	}
 	log.Printf("Public key to be used: %s", issPk)
 
+	// Check the root key against the trust bundle
+	if bundle != nil {
+		if !bundle.HasAuthority(issPk) {
+			return nil, false, fmt.Errorf("Root key is not an authority of %s bundle\n", rootTD)
+		}
+	}
+
 	log.Printf("Verifying signature created by %s\n", lsvid.Payload.Iss.CN)
-	verify := ecdsa.VerifyASN1(issPk.(*ecdsa.PublicKey), hash[:], lsvid.Signature)
+	verify := verifyDigest(issPk, hash[:], lsvid.Signature)
 	if verify == false {
 		fmt.Printf("\nSignature validation failed!\n\n")
-		return false, nil
+		return nil, false, nil
+	}
+
+	return [][]spiffeid.TrustDomain{{rootTD}}, true, nil
+}
+
+// checkChainPolicies applies the redaction policy to the redacted hops and the
+// trust domain policy to the trust domains of every path of the chain, given
+// from the outer most hop to the root.
+func checkChainPolicies(config *validateConfig, tdPaths [][]spiffeid.TrustDomain, opaque []int) (bool, error) {
+	if len(opaque) > 0 {
+		if config.redactionPolicy == nil {
+			return false, fmt.Errorf("Redacted hops are not allowed\n")
+		}
+		if err := config.redactionPolicy(opaque); err != nil {
+			return false, fmt.Errorf("Redaction policy validation failed: %v\n", err)
+		}
+	}
+
+	if config.tdPolicy == nil {
+		return true, nil
+	}
+
+	for _, tdPath := range tdPaths {
+		// policies evaluate the path from the root to the outer most hop
+		for i, j := 0, len(tdPath)-1; i < j; i, j = i+1, j-1 {
+			tdPath[i], tdPath[j] = tdPath[j], tdPath[i]
+		}
+		if err := config.tdPolicy(tdPath); err != nil {
+			return false, fmt.Errorf("Trust domain policy validation failed: %v\n", err)
+		}
 	}
 
 	return true, nil
 }
 
+// innermost returns the inner most (root) token of a nested token structure.
+func innermost(lsvid *Token) *Token {
+	for lsvid.Nested != nil {
+		lsvid = lsvid.Nested
+	}
+	return lsvid
+}
+
+// verifyDigest verifies a signature over a SHA-256 digest.
+// Supports ECDSA and RSA (PKCS #1 v1.5) keys.
+func verifyDigest(pk crypto.PublicKey, hash []byte, signature []byte) bool {
+	switch pk := pk.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(pk, hash, signature)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(pk, crypto.SHA256, hash, signature) == nil
+	default:
+		log.Printf("Unsupported public key type %T\n", pk)
+		return false
+	}
+}
+
 // FetchLSVID retrieves a JWT-SVID (LSVID) from a workload API.
 //
 // This function connects to the SPIRE agent using the provided socket path, fetches
@@ -279,6 +770,13 @@ func Cert2LSR(ctx context.Context, socketPath string, cert *x509.Certificate, au
 	}
 	clientID := clientSVID.ID.String()
 
+	return cert2Payload(clientID, cert, audience)
+}
+
+// cert2Payload creates a root LSVID payload issued by issuer to the
+// SPIFFE ID and public key of the given x509 certificate.
+func cert2Payload(issuer string, cert *x509.Certificate, audience string) (*Payload, error) {
+
 	// generate encoded public key
 	tmppk, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
 	if err != nil {
@@ -287,8 +785,8 @@ func Cert2LSR(ctx context.Context, socketPath string, cert *x509.Certificate, au
 	// pubkey :=  base64.RawURLEncoding.EncodeToString(tmppk)
 
 	// Versioning needs TBD. For poc, considering vr = 1
-	if cert.URIs[0] == nil {
-		return &Payload{}, fmt.Errorf("No certificate URI: %v", err)
+	if len(cert.URIs) == 0 || cert.URIs[0] == nil {
+		return &Payload{}, fmt.Errorf("No certificate URI\n")
 	}
 	sub := cert.URIs[0].String()
 	// Create LSVID payload
@@ -297,7 +795,7 @@ func Cert2LSR(ctx context.Context, socketPath string, cert *x509.Certificate, au
 		Alg: "ES256",
 		Iat: time.Now().Round(0).Unix(),
 		Iss: &IDClaim{
-			CN: clientID,
+			CN: issuer,
 		},
 		Sub: &IDClaim{
 			CN: sub,
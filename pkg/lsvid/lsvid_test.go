@@ -0,0 +1,171 @@
+package lsvid
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/bundle/x509bundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+)
+
+// Fixtures shared by the tests: a CA issuing X509-SVIDs and root LSVIDs, and
+// chains extended from them.
+
+type testCA struct {
+	td   spiffeid.TrustDomain
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, td string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trustDomain := spiffeid.RequireTrustDomainFromString(td)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "CA"},
+		URIs:                  []*url.URL{trustDomain.ID().URL()},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{td: trustDomain, cert: cert, key: key}
+}
+
+func (ca *testCA) newSVID(t *testing.T, path string) *x509svid.SVID {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := spiffeid.RequireFromPath(ca.td, path)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		URIs:         []*url.URL{id.URL()},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &x509svid.SVID{ID: id, Certificates: []*x509.Certificate{cert}, PrivateKey: key}
+}
+
+// newLSVID issues the root LSVID of the SVID, signed by the CA key.
+func (ca *testCA) newLSVID(t *testing.T, svid *x509svid.SVID) *Token {
+	payload, err := cert2Payload(ca.td.IDString(), svid.Certificates[0], svid.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload.Iss.PK, err = x509.MarshalPKIXPublicKey(ca.key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := signRoot(payload, crypto.Signer(ca.key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// bundle returns the X.509 bundle of the CA trust domain.
+func (ca *testCA) bundle() *x509bundle.Bundle {
+	return x509bundle.FromX509Authorities(ca.td, []*x509.Certificate{ca.cert})
+}
+
+// newPayload returns the payload of a hop issued by the SVID to the audience.
+func (ca *testCA) newPayload(t *testing.T, svid *x509svid.SVID, audience string) *Payload {
+	return &Payload{
+		Ver: 1,
+		Alg: "ES256",
+		Iat: time.Now().Unix(),
+		Iss: &IDClaim{CN: svid.ID.String(), ID: ca.newLSVID(t, svid)},
+		Aud: &IDClaim{CN: audience},
+	}
+}
+
+// extend extends the LSVID with the payload signed by the SVID key, and decodes the result.
+func extend(t *testing.T, lsvid *LSVID, payload *Payload, svid *x509svid.SVID) *LSVID {
+	encLSVID, err := Extend(lsvid, payload, svid.PrivateKey)
+	if err != nil {
+		t.Fatalf("Extend: %v", err)
+	}
+	extended, err := Decode(encLSVID)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	return extended
+}
+
+// newChain issues the chain where each SVID extends the LSVID to the next one, from the
+// root LSVID of the first SVID. If not nil, edit is called on each hop payload before signing.
+func (ca *testCA) newChain(t *testing.T, svids []*x509svid.SVID, edit func(hop int, payload *Payload)) *LSVID {
+	lsvid := &LSVID{Token: ca.newLSVID(t, svids[0])}
+	for hop := 0; hop < len(svids)-1; hop++ {
+		payload := ca.newPayload(t, svids[hop], svids[hop+1].ID.String())
+		if edit != nil {
+			edit(hop, payload)
+		}
+		lsvid = extend(t, lsvid, payload, svids[hop])
+	}
+	return lsvid
+}
+
+// newPath issues the X509-SVIDs of the workloads at the given paths, in the order of the chain.
+func (ca *testCA) newPath(t *testing.T, paths ...string) []*x509svid.SVID {
+	svids := make([]*x509svid.SVID, 0, len(paths))
+	for _, path := range paths {
+		svids = append(svids, ca.newSVID(t, path))
+	}
+	return svids
+}
+
+func newTestTrustBundle(t *testing.T, ca *testCA) *TrustBundle {
+	bundle, err := BundleFromX509Bundle(ca.bundle(), 1, 0, ca.key)
+	if err != nil {
+		t.Fatalf("BundleFromX509Bundle: %v", err)
+	}
+	trustBundle, err := ParseBundle(bundle)
+	if err != nil {
+		t.Fatalf("ParseBundle: %v", err)
+	}
+	return trustBundle
+}
+
+// checkValidate validates the LSVID with the options, and checks the result.
+func checkValidate(t *testing.T, lsvid *Token, want bool, opts ...ValidateOption) {
+	t.Helper()
+	valid, err := Validate(lsvid, opts...)
+	if want && !valid {
+		t.Errorf("LSVID rejected: %v", err)
+	}
+	if !want && valid {
+		t.Error("LSVID accepted")
+	}
+}
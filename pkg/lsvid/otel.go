@@ -0,0 +1,346 @@
+package lsvid
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Span is a hop of a validated chain, as OpenTelemetry span data. Check ChainSpans.
+type Span struct {
+	TraceID      string // 32 lowercase hex digits
+	SpanID       string // 16 lowercase hex digits
+	ParentSpanID string // span of the nested hop, empty for roots
+	Name         string
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]interface{} // string, int64, bool or []string values
+	Links        []*SpanLink
+}
+
+// SpanLink links a hop span to another span: the other parents of a join hop, or the
+// application span the hop was sent with (Trc).
+type SpanLink struct {
+	TraceID string
+	SpanID  string
+}
+
+// ChainSpans converts the chain, that should be validated first, into one span per hop.
+// Span IDs are derived from the token digests, and the parent of each span is its nested
+// hop (the first parent of join hops, the others being linked). The trace ID is the one
+// of the outer most hop trace context, or derived from its digest.
+func ChainSpans(lsvid *Token) ([]*Span, error) {
+	digest, err := tokenDigest(lsvid)
+	if err != nil {
+		return nil, err
+	}
+	traceID := hex.EncodeToString(digest[:16])
+	if lsvid.Payload != nil && lsvid.Payload.Trc != nil {
+		traceID = lsvid.Payload.Trc.Tid
+	}
+
+	spanIDs := make(map[*Token]string)
+	spanID := func(token *Token) (string, error) {
+		if id, ok := spanIDs[token]; ok {
+			return id, nil
+		}
+		digest, err := tokenDigest(token)
+		if err != nil {
+			return "", err
+		}
+		spanIDs[token] = hex.EncodeToString(digest[:8])
+		return spanIDs[token], nil
+	}
+
+	var spans []*Span
+	err = Walk(lsvid, func(token *Token, depth int) error {
+		span := &Span{
+			TraceID:    traceID,
+			Name:       "lsvid.hop",
+			Attributes: hopAttributes(token.Payload),
+		}
+		span.Attributes["lsvid.depth"] = int64(depth)
+
+		var err error
+		if span.SpanID, err = spanID(token); err != nil {
+			return err
+		}
+		for i, parent := range token.Parents() {
+			parentID, err := spanID(parent)
+			if err != nil {
+				return err
+			}
+			if i == 0 {
+				span.ParentSpanID = parentID
+				continue
+			}
+			span.Links = append(span.Links, &SpanLink{TraceID: traceID, SpanID: parentID})
+		}
+		switch {
+		case len(token.Joined) > 0:
+			span.Name = "lsvid.join"
+		case token.Nested == nil:
+			span.Name = "lsvid.root"
+		}
+
+		if payload := token.Payload; payload != nil {
+			span.Start = time.Unix(payload.Iat, 0)
+			if payload.Rcv != 0 {
+				span.Start = time.UnixMilli(payload.Rcv)
+			}
+			span.End = span.Start
+			if payload.Fwd != 0 {
+				span.End = time.UnixMilli(payload.Fwd)
+			}
+			if payload.Trc != nil {
+				span.Links = append(span.Links, &SpanLink{TraceID: payload.Trc.Tid, SpanID: payload.Trc.Sid})
+			}
+		}
+
+		spans = append(spans, span)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return spans, nil
+}
+
+// hopAttributes converts the hop claims into span attributes.
+func hopAttributes(payload *Payload) map[string]interface{} {
+	attrs := make(map[string]interface{})
+	if payload == nil {
+		attrs["lsvid.redacted"] = true
+		return attrs
+	}
+
+	attrs["lsvid.version"] = int64(payload.Ver)
+	setString := func(key, value string) {
+		if value != "" {
+			attrs[key] = value
+		}
+	}
+	setString("lsvid.alg", payload.Alg)
+	if payload.Iat != 0 {
+		attrs["lsvid.iat"] = payload.Iat
+	}
+	if payload.Iss != nil {
+		setString("lsvid.issuer", payload.Iss.CN)
+	}
+	if payload.Sub != nil {
+		setString("lsvid.subject", payload.Sub.CN)
+	}
+	var audiences []string
+	for _, aud := range payload.Audiences() {
+		audiences = append(audiences, aud.CN)
+	}
+	if len(audiences) > 0 {
+		attrs["lsvid.audience"] = audiences
+	}
+	setString("lsvid.dpa", payload.Dpa)
+	setString("lsvid.dpr", payload.Dpr)
+	for key, value := range payload.Sel {
+		attrs["lsvid.selectors."+key] = fmt.Sprintf("%v", value)
+	}
+	if len(payload.Scp) > 0 {
+		attrs["lsvid.scope"] = payload.Scp
+	}
+	if payload.Req != nil {
+		attrs["lsvid.request.components"] = payload.Req.Com
+	}
+	if payload.Sts != nil {
+		attrs["lsvid.status.uri"] = payload.Sts.Uri
+		attrs["lsvid.status.idx"] = int64(payload.Sts.Idx)
+	}
+	if len(payload.Sd) > 0 {
+		attrs["lsvid.concealed"] = int64(len(payload.Sd))
+	}
+	return attrs
+}
+
+// OTLPExporter writes spans as OTLP JSON, one trace export request per line, as read
+// by the OpenTelemetry collector (e.g.: otlpjsonfile receiver).
+type OTLPExporter struct {
+	serviceName string
+
+	mtx    sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+// NewOTLPExporter creates an exporter writing to w, with the service.name resource attribute.
+func NewOTLPExporter(w io.Writer, serviceName string) *OTLPExporter {
+	return &OTLPExporter{
+		serviceName: serviceName,
+		w:           w,
+	}
+}
+
+// NewOTLPStdoutExporter creates an exporter writing to the standard output.
+func NewOTLPStdoutExporter(serviceName string) *OTLPExporter {
+	return NewOTLPExporter(os.Stdout, serviceName)
+}
+
+// NewOTLPFileExporter creates an exporter appending to the file at path.
+func NewOTLPFileExporter(path string, serviceName string) (*OTLPExporter, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open OTLP file: %v\n", err)
+	}
+	e := NewOTLPExporter(file, serviceName)
+	e.closer = file
+	return e, nil
+}
+
+// ExportChain exports the spans of the chain. Check ChainSpans.
+func (e *OTLPExporter) ExportChain(lsvid *Token) error {
+	spans, err := ChainSpans(lsvid)
+	if err != nil {
+		return err
+	}
+	return e.Export(spans)
+}
+
+// Export writes the spans as a single export request.
+func (e *OTLPExporter) Export(spans []*Span) error {
+	otlpSpans := make([]*otlpSpan, 0, len(spans))
+	for _, span := range spans {
+		otlpSpans = append(otlpSpans, span.otlp())
+	}
+	request := &otlpTraces{
+		ResourceSpans: []*otlpResourceSpans{{
+			Resource: &otlpResource{
+				Attributes: []*otlpKeyValue{{Key: "service.name", Value: otlpValue(e.serviceName)}},
+			},
+			ScopeSpans: []*otlpScopeSpans{{
+				Scope: &otlpScope{Name: "github.com/hpe-usp-spire/signed-assertions/lsvid"},
+				Spans: otlpSpans,
+			}},
+		}},
+	}
+
+	requestJSON, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("Error generating json: %v\n", err)
+	}
+
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	if _, err := e.w.Write(append(requestJSON, '\n')); err != nil {
+		return fmt.Errorf("Unable to write spans: %v\n", err)
+	}
+	return nil
+}
+
+// Close closes the file of file exporters.
+func (e *OTLPExporter) Close() error {
+	if e.closer == nil {
+		return nil
+	}
+	return e.closer.Close()
+}
+
+// OTLP JSON encoding (opentelemetry-proto, ExportTraceServiceRequest). IDs are hex
+// encoded, and 64 bit integers are strings.
+type otlpTraces struct {
+	ResourceSpans []*otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   *otlpResource     `json:"resource"`
+	ScopeSpans []*otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []*otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope *otlpScope  `json:"scope"`
+	Spans []*otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []*otlpKeyValue `json:"attributes,omitempty"`
+	Links             []*otlpLink     `json:"links,omitempty"`
+}
+
+type otlpLink struct {
+	TraceID string `json:"traceId"`
+	SpanID  string `json:"spanId"`
+}
+
+type otlpKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+// SPAN_KIND_INTERNAL
+const otlpSpanKindInternal = 1
+
+func (s *Span) otlp() *otlpSpan {
+	span := &otlpSpan{
+		TraceID:           s.TraceID,
+		SpanID:            s.SpanID,
+		ParentSpanID:      s.ParentSpanID,
+		Name:              s.Name,
+		Kind:              otlpSpanKindInternal,
+		StartTimeUnixNano: otlpTime(s.Start),
+		EndTimeUnixNano:   otlpTime(s.End),
+	}
+
+	keys := make([]string, 0, len(s.Attributes))
+	for key := range s.Attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		span.Attributes = append(span.Attributes, &otlpKeyValue{Key: key, Value: otlpValue(s.Attributes[key])})
+	}
+	for _, link := range s.Links {
+		span.Links = append(span.Links, &otlpLink{TraceID: link.TraceID, SpanID: link.SpanID})
+	}
+	return span
+}
+
+func otlpTime(t time.Time) string {
+	if t.IsZero() {
+		return "0"
+	}
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// otlpValue encodes an attribute value (AnyValue).
+func otlpValue(value interface{}) map[string]interface{} {
+	switch value := value.(type) {
+	case bool:
+		return map[string]interface{}{"boolValue": value}
+	case int64:
+		return map[string]interface{}{"intValue": strconv.FormatInt(value, 10)}
+	case []string:
+		values := make([]map[string]interface{}, 0, len(value))
+		for _, v := range value {
+			values = append(values, otlpValue(v))
+		}
+		return map[string]interface{}{"arrayValue": map[string]interface{}{"values": values}}
+	}
+	return map[string]interface{}{"stringValue": fmt.Sprintf("%v", value)}
+}
@@ -0,0 +1,160 @@
+package lsvid
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestChainSpans(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	asserting := ca.newSVID(t, "/asserting-wl")
+	middle := ca.newSVID(t, "/middle-tier")
+	target := ca.newSVID(t, "/target-wl")
+
+	// asserting-wl -> middle-tier, correlated with an application trace
+	root := ca.newLSVID(t, asserting)
+	received := time.Now()
+	payload := &Payload{
+		Ver: 1,
+		Alg: "ES256",
+		Iat: received.Unix(),
+		Iss: &IDClaim{CN: asserting.ID.String(), ID: root},
+		Aud: &IDClaim{CN: middle.ID.String()},
+		Dpr: "alice",
+		Scp: []string{"deposit"},
+	}
+	request, err := http.NewRequest(http.MethodPost, "https://middle-tier/deposit", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trace, err := InjectTrace(payload, request, nil)
+	if err != nil {
+		t.Fatalf("InjectTrace: %v", err)
+	}
+	SetHopTiming(payload, received)
+	encLSVID, err := Extend(&LSVID{Token: root}, payload, asserting.PrivateKey)
+	if err != nil {
+		t.Fatalf("Extend: %v", err)
+	}
+	lsvid, err := Decode(encLSVID)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	// middle-tier -> target-wl
+	extPayload := &Payload{
+		Ver: 1,
+		Alg: "ES256",
+		Iat: time.Now().Unix(),
+		Iss: &IDClaim{CN: middle.ID.String(), ID: ca.newLSVID(t, middle)},
+		Aud: &IDClaim{CN: target.ID.String()},
+		Trc: &TraceContext{Tid: trace.Tid, Sid: "00f067aa0ba902b7", Flg: 1},
+	}
+	encLSVID, err = Extend(lsvid, extPayload, middle.PrivateKey)
+	if err != nil {
+		t.Fatalf("Extend: %v", err)
+	}
+	extended, err := Decode(encLSVID)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if valid, err := Validate(extended.Token); !valid {
+		t.Fatalf("LSVID rejected: %v", err)
+	}
+
+	spans, err := ChainSpans(extended.Token)
+	if err != nil {
+		t.Fatalf("ChainSpans: %v", err)
+	}
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans, want 3", len(spans))
+	}
+	outer, hop, rootSpan := spans[0], spans[1], spans[2]
+
+	// parents follow the nesting, in the trace of the outer most hop
+	for _, span := range spans {
+		if span.TraceID != trace.Tid {
+			t.Errorf("span %s in trace %s, want %s", span.SpanID, span.TraceID, trace.Tid)
+		}
+	}
+	if outer.ParentSpanID != hop.SpanID || hop.ParentSpanID != rootSpan.SpanID || rootSpan.ParentSpanID != "" {
+		t.Error("span parents do not follow the nesting")
+	}
+	if rootSpan.Name != "lsvid.root" || hop.Name != "lsvid.hop" {
+		t.Errorf("unexpected span names %q, %q", rootSpan.Name, hop.Name)
+	}
+	if len(hop.Links) != 1 || hop.Links[0].SpanID != trace.Sid {
+		t.Error("hop span not linked to its application span")
+	}
+
+	// claims become attributes, and the hop timing the span times
+	if hop.Attributes["lsvid.issuer"] != asserting.ID.String() || hop.Attributes["lsvid.dpr"] != "alice" {
+		t.Errorf("unexpected hop attributes %v", hop.Attributes)
+	}
+	if audience, ok := outer.Attributes["lsvid.audience"].([]string); !ok || len(audience) != 1 || audience[0] != target.ID.String() {
+		t.Errorf("unexpected outer audience %v", outer.Attributes["lsvid.audience"])
+	}
+	if hop.Start.UnixMilli() != received.UnixMilli() || hop.End.Before(hop.Start) {
+		t.Errorf("unexpected hop times %s, %s", hop.Start, hop.End)
+	}
+
+	// the exporter writes one OTLP JSON request per line
+	path := filepath.Join(t.TempDir(), "spans.json")
+	exporter, err := NewOTLPFileExporter(path, "target-wl")
+	if err != nil {
+		t.Fatalf("NewOTLPFileExporter: %v", err)
+	}
+	if err := exporter.ExportChain(extended.Token); err != nil {
+		t.Fatalf("ExportChain: %v", err)
+	}
+	if err := exporter.ExportChain(lsvid.Token); err != nil {
+		t.Fatalf("ExportChain: %v", err)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines int
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		lines++
+		var traces struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []struct {
+						TraceID           string `json:"traceId"`
+						SpanID            string `json:"spanId"`
+						StartTimeUnixNano string `json:"startTimeUnixNano"`
+						Attributes        []struct {
+							Key string `json:"key"`
+						} `json:"attributes"`
+					} `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &traces); err != nil {
+			t.Fatalf("invalid OTLP JSON: %v", err)
+		}
+		if len(traces.ResourceSpans) != 1 || len(traces.ResourceSpans[0].ScopeSpans) != 1 {
+			t.Fatalf("unexpected OTLP request %s", scanner.Text())
+		}
+		for _, span := range traces.ResourceSpans[0].ScopeSpans[0].Spans {
+			if len(span.TraceID) != 32 || len(span.SpanID) != 16 || span.StartTimeUnixNano == "" || len(span.Attributes) == 0 {
+				t.Errorf("unexpected OTLP span %+v", span)
+			}
+		}
+	}
+	if lines != 2 {
+		t.Errorf("got %d exported requests, want 2", lines)
+	}
+}
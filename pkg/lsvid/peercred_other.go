@@ -0,0 +1,13 @@
+//go:build !linux
+
+package lsvid
+
+import (
+	"fmt"
+	"net"
+)
+
+// peerCred is only supported on Linux (SO_PEERCRED).
+func peerCred(conn net.Conn) (*PeerCred, error) {
+	return nil, fmt.Errorf("peer credentials not supported on this platform")
+}
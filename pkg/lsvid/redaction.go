@@ -0,0 +1,186 @@
+package lsvid
+
+import (
+	hash256 "crypto/sha256"
+	"encoding/json"
+	"fmt"
+)
+
+// VerHashLinked is the version of hops signed over hash commitments (see signingHash),
+// instead of the JSON of the whole nested token. Only hash linked hops can be redacted.
+const VerHashLinked int8 = 2
+
+// RedactionPolicy authorizes the redacted hops of an LSVID chain.
+// The hops are indexed from the outer most one, which is 0.
+type RedactionPolicy func(opaque []int) error
+
+// AllowRedaction allows up to max redacted hops. If max is negative, any number of hops is allowed.
+func AllowRedaction(max int) RedactionPolicy {
+	return func(opaque []int) error {
+		if max >= 0 && len(opaque) > max {
+			return fmt.Errorf("%d redacted hops, at most %d allowed", len(opaque), max)
+		}
+		return nil
+	}
+}
+
+// ForbidRedaction rejects any redacted hop. It is the default behavior of Validate.
+func ForbidRedaction() RedactionPolicy {
+	return func(opaque []int) error {
+		if len(opaque) > 0 {
+			return fmt.Errorf("redacted hops are not allowed")
+		}
+		return nil
+	}
+}
+
+// Redact replaces the payload of the given intermediate hops by their commitment,
+// hiding which workloads the request passed through. The hops are indexed from
+// the outer most one, which is 0.
+//
+// The outer signatures still verify, given that the redacted hops and every
+// hop outside them are hash linked (VerHashLinked). The outer most hop and the
+// root can not be redacted, nor the hops beyond a join hop. Disclosures of the redacted payloads are dropped.
+// The LSVID is modified in place.
+func Redact(lsvid *LSVID, hops ...int) error {
+	tokens := chainTokens(lsvid.Token)
+
+	for _, hop := range hops {
+		if hop <= 0 || hop >= len(tokens)-1 {
+			return fmt.Errorf("Hop %d is not an intermediate hop\n", hop)
+		}
+		if payload := tokens[hop].Payload; payload != nil && (payload.Blk != nil || payload.Cst != nil || len(payload.Scp) > 0 || len(payload.Tpc) > 0 || payload.Sts != nil) {
+			// hiding them would drop their enforcement
+			return fmt.Errorf("Hop %d has constraints, scope, caveats, status or Datalog block and can not be redacted\n", hop)
+		}
+		for i := 0; i <= hop; i++ {
+			if tokens[i].Payload != nil && tokens[i].Payload.Ver != VerHashLinked {
+				return fmt.Errorf("Hop %d is not hash linked, hop %d can not be redacted\n", i, hop)
+			}
+		}
+	}
+
+	for _, hop := range hops {
+		token := tokens[hop]
+		if token.Payload == nil {
+			continue
+		}
+
+		commit, err := payloadCommitment(token.Payload)
+		if err != nil {
+			return err
+		}
+		var disclosures []string
+		for _, encoded := range lsvid.Disclosures {
+			if !containsString(token.Payload.Sd, disclosureDigest(encoded)) {
+				disclosures = append(disclosures, encoded)
+			}
+		}
+		lsvid.Disclosures = disclosures
+
+		token.Commit = commit
+		token.Payload = nil
+	}
+
+	return nil
+}
+
+// OpaqueHops returns the redacted hops of the LSVID, indexed from the outer most one.
+// In joined chains, the index is the depth where the hop is first found (see Walk).
+func OpaqueHops(lsvid *Token) []int {
+	var opaque []int
+	Walk(lsvid, func(token *Token, depth int) error {
+		if token.Payload == nil {
+			opaque = append(opaque, depth)
+		}
+		return nil
+	})
+	return opaque
+}
+
+// signingHash returns the hash signed by the token issuer.
+//
+// Root tokens sign the hash of the payload JSON, that is also the payload commitment.
+// Hash linked hops sign sha256(digest(nested) || commitment(payload)), or the
+// digests of every parent in order for join hops, where
+// digest(token) is sha256(signingHash(token) || signature || jwt), so hops can be
+// redacted by keeping only their commitment.
+// Other hops sign the hash of the JSON of the whole token, without the signature.
+func signingHash(token *Token) ([]byte, error) {
+	parents := token.Parents()
+	if len(parents) == 0 {
+		return tokenCommitment(token)
+	}
+
+	if token.Payload == nil || token.Payload.Ver == VerHashLinked {
+		h := hash256.New()
+		for _, parent := range parents {
+			parentDigest, err := tokenDigest(parent)
+			if err != nil {
+				return nil, err
+			}
+			h.Write(parentDigest)
+		}
+		commit, err := tokenCommitment(token)
+		if err != nil {
+			return nil, err
+		}
+		h.Write(commit)
+		return h.Sum(nil), nil
+	}
+
+	tokenJSON, err := json.Marshal(&Token{
+		Nested:  token.Nested,
+		Joined:  token.Joined,
+		Payload: token.Payload,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling LSVID to JSON: %v\n", err)
+	}
+	hash := hash256.Sum256(tokenJSON)
+	return hash[:], nil
+}
+
+// tokenDigest binds the token content and signature, as referenced by hash linked hops.
+func tokenDigest(token *Token) ([]byte, error) {
+	hash, err := signingHash(token)
+	if err != nil {
+		return nil, err
+	}
+
+	h := hash256.New()
+	h.Write(hash)
+	h.Write(token.Signature)
+	h.Write([]byte(token.JWT))
+	return h.Sum(nil), nil
+}
+
+// tokenCommitment returns the payload commitment, or the commitment kept by a redacted token.
+func tokenCommitment(token *Token) ([]byte, error) {
+	if token.Payload == nil {
+		if len(token.Commit) != hash256.Size {
+			return nil, fmt.Errorf("Invalid commitment of redacted token\n")
+		}
+		return token.Commit, nil
+	}
+	return payloadCommitment(token.Payload)
+}
+
+func payloadCommitment(payload *Payload) ([]byte, error) {
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling LSVID to JSON: %v\n", err)
+	}
+	hash := hash256.Sum256(payloadJSON)
+	return hash[:], nil
+}
+
+// chainTokens returns the tokens of the chain, from the outer most one to the root.
+// It stops at join hops, that are returned as the last token.
+func chainTokens(lsvid *Token) []*Token {
+	var tokens []*Token
+	for token := lsvid; token != nil; token = token.Nested {
+		tokens = append(tokens, token)
+	}
+	return tokens
+}
@@ -0,0 +1,77 @@
+package lsvid
+
+import (
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+
+	// asserting-wl -> gateway -> middle-tier -> target-wl, hash linked
+	path := ca.newPath(t, "/asserting-wl", "/gateway", "/middle-tier", "/target-wl")
+	lsvid := ca.newChain(t, path, func(_ int, payload *Payload) {
+		payload.Ver = VerHashLinked
+	})
+	checkValidate(t, lsvid.Token, true)
+
+	// the gateway hop is hidden, and the outer signatures still verify
+	if err := Redact(lsvid, 2); err != nil {
+		t.Fatalf("Redact: %v", err)
+	}
+	if opaque := OpaqueHops(lsvid.Token); len(opaque) != 1 || opaque[0] != 2 {
+		t.Fatalf("got opaque hops %v, want [2]", opaque)
+	}
+	encLSVID, err := Encode(lsvid)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	redacted, err := Decode(encLSVID)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	checkValidate(t, redacted.Token, true, WithRedactionPolicy(AllowRedaction(1)))
+
+	// redaction is rejected by default, or above the policy maximum
+	checkValidate(t, redacted.Token, false)
+	checkValidate(t, redacted.Token, false, WithRedactionPolicy(ForbidRedaction()))
+	if err := Redact(redacted, 1); err != nil {
+		t.Fatalf("Redact: %v", err)
+	}
+	checkValidate(t, redacted.Token, false, WithRedactionPolicy(AllowRedaction(1)))
+	checkValidate(t, redacted.Token, true, WithRedactionPolicy(AllowRedaction(-1)))
+
+	// the commitment is covered by the outer signatures
+	redacted.Token.Nested.Commit[0] ^= 0xff
+	checkValidate(t, redacted.Token, false, WithRedactionPolicy(AllowRedaction(-1)))
+}
+
+func TestRedactRejected(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	path := ca.newPath(t, "/asserting-wl", "/gateway", "/middle-tier")
+
+	chain := func(outer int8, scope []string) *LSVID {
+		return ca.newChain(t, path, func(hop int, payload *Payload) {
+			payload.Ver = VerHashLinked
+			if hop == 0 {
+				payload.Scp = scope
+			} else {
+				payload.Ver = outer
+			}
+		})
+	}
+
+	lsvid := chain(VerHashLinked, nil)
+	for _, hop := range []int{0, 2, 3} {
+		if err := Redact(lsvid, hop); err == nil {
+			t.Errorf("hop %d of 3 redacted", hop)
+		}
+	}
+	if err := Redact(chain(VerHashLinked, []string{"deposit"}), 1); err == nil {
+		t.Error("hop with scope redacted")
+	}
+
+	// hops signed over the JSON of the nested token are not hash linked
+	if err := Redact(chain(1, nil), 1); err == nil {
+		t.Error("hop redacted under a hop that is not hash linked")
+	}
+}
@@ -0,0 +1,227 @@
+package lsvid
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Verification status of the rendered chain and hops.
+const (
+	HopValid    = "valid"
+	HopInvalid  = "invalid"
+	HopRedacted = "redacted"
+)
+
+// ChainView is a readable view of an LSVID chain, with the verification status of
+// the chain and of each hop, rendered as an indented tree (Tree), a JSON document
+// (JSON) or a Graphviz DOT graph (DOT).
+type ChainView struct {
+	Status string   `json:"status"`
+	Error  string   `json:"error,omitempty"`
+	Hop    *HopView `json:"hop"`
+	nodes  []*HopView
+}
+
+// HopView is a hop of a ChainView. The hop status covers its signature and its
+// links to the parents; the issuer LSVID has its own status.
+type HopView struct {
+	Kind      string     `json:"kind"` // root, jwt-root, hop, join or redacted
+	Depth     int        `json:"depth"`
+	Issuer    string     `json:"iss,omitempty"`
+	Subject   string     `json:"sub,omitempty"`
+	Audiences []string   `json:"aud,omitempty"`
+	Alg       string     `json:"alg,omitempty"`
+	Iat       int64      `json:"iat,omitempty"`
+	Status    string     `json:"status"`
+	Error     string     `json:"error,omitempty"`
+	IssLSVID  *HopView   `json:"iss_lsvid,omitempty"`  // embedded issuer identity token (Iss.ID)
+	IssStatus string     `json:"iss_status,omitempty"` // status of the whole issuer LSVID
+	Parents   []*HopView `json:"parents,omitempty"`
+
+	id string // DOT node ID
+}
+
+// NewChainView validates the chain with the given options, and verifies each of
+// its hops to build the view.
+func NewChainView(lsvid *Token, opts ...ValidateOption) *ChainView {
+	config := &validateConfig{}
+	for _, opt := range opts {
+		opt.apply(config)
+	}
+
+	view := &ChainView{Status: HopValid}
+	if valid, err := Validate(lsvid, opts...); !valid {
+		view.Status = statusOf(valid, err)
+		if err != nil {
+			view.Error = strings.TrimSpace(err.Error())
+		}
+	}
+
+	views := make(map[*Token]*HopView)
+	var newView func(token *Token, depth int, root bool) *HopView
+	newView = func(token *Token, depth int, root bool) *HopView {
+		if hop, ok := views[token]; ok {
+			return hop
+		}
+		hop := &HopView{
+			Depth: depth,
+			id:    fmt.Sprintf("n%d", len(view.nodes)),
+		}
+		views[token] = hop
+		view.nodes = append(view.nodes, hop)
+
+		var err error
+		var valid bool
+		switch {
+		case token.Payload == nil:
+			hop.Kind, hop.Status = "redacted", HopRedacted
+		case len(token.Parents()) == 0 && token.JWT != "":
+			hop.Kind = "jwt-root"
+			valid, err = verifyRootHop(token, config)
+		case len(token.Parents()) == 0:
+			hop.Kind = "root"
+			valid, err = verifyRootHop(token, config)
+		case len(token.Joined) > 0:
+			hop.Kind = "join"
+			_, valid, err = verifyHop(token, depth, config)
+		default:
+			hop.Kind = "hop"
+			_, valid, err = verifyHop(token, depth, config)
+		}
+		if token.Payload != nil {
+			hop.Status = statusOf(valid, err)
+			if err != nil {
+				hop.Error = strings.TrimSpace(err.Error())
+			}
+			hop.Alg, hop.Iat = token.Payload.Alg, token.Payload.Iat
+			hop.Issuer = idClaimCN(token.Payload.Iss)
+			if token.Payload.Sub != nil {
+				hop.Subject = token.Payload.Sub.CN
+			}
+			for _, aud := range token.Payload.Audiences() {
+				hop.Audiences = append(hop.Audiences, aud.CN)
+			}
+			if iss := token.Payload.Iss; iss != nil && iss.ID != nil && !root {
+				hop.IssLSVID = newView(iss.ID, 0, true)
+				hop.IssStatus = statusOf(validate(iss.ID, &validateConfig{
+					bundleSource:    config.bundleSource,
+					jwtBundleSource: config.jwtBundleSource,
+					keyResolver:     config.keyResolver,
+					verifyCache:     config.verifyCache,
+					verifyScope:     config.verifyScope,
+				}))
+			}
+		}
+
+		for _, parent := range token.Parents() {
+			hop.Parents = append(hop.Parents, newView(parent, depth+1, root))
+		}
+		return hop
+	}
+
+	view.Hop = newView(lsvid, 0, false)
+	return view
+}
+
+// verifyRootHop verifies the root token, against the trust bundles if configured.
+func verifyRootHop(token *Token, config *validateConfig) (bool, error) {
+	_, valid, err := validateRoot(token, config)
+	return valid, err
+}
+
+func statusOf(valid bool, err error) string {
+	if !valid || err != nil {
+		return HopInvalid
+	}
+	return HopValid
+}
+
+// Tree renders the chain as an indented tree, from the outer most hop.
+func (v *ChainView) Tree() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "LSVID chain [%s]", v.Status)
+	if v.Error != "" {
+		fmt.Fprintf(&b, " %s", v.Error)
+	}
+	b.WriteString("\n")
+
+	var tree func(hop *HopView, indent string)
+	tree = func(hop *HopView, indent string) {
+		fmt.Fprintf(&b, "%s[%d] %s [%s]", indent, hop.Depth, hop.Kind, hop.Status)
+		if hop.Kind != "redacted" {
+			fmt.Fprintf(&b, " iss %s", hop.Issuer)
+			if hop.Subject != "" {
+				fmt.Fprintf(&b, " sub %s", hop.Subject)
+			}
+			fmt.Fprintf(&b, " aud %s alg %s iat %d", strings.Join(hop.Audiences, ","), hop.Alg, hop.Iat)
+		}
+		if hop.Error != "" {
+			fmt.Fprintf(&b, " (%s)", hop.Error)
+		}
+		b.WriteString("\n")
+
+		if hop.IssLSVID != nil {
+			fmt.Fprintf(&b, "%s    issuer LSVID [%s]:\n", indent, hop.IssStatus)
+			tree(hop.IssLSVID, indent+"      ")
+		}
+		for _, parent := range hop.Parents {
+			tree(parent, indent+"  ")
+		}
+	}
+
+	tree(v.Hop, "")
+	return b.String()
+}
+
+// JSON renders the chain as an indented JSON document.
+func (v *ChainView) JSON() ([]byte, error) {
+	viewJSON, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("Error generating json: %v\n", err)
+	}
+	return viewJSON, nil
+}
+
+// DOT renders the chain as a Graphviz graph. Edges go from each hop to the hop
+// it delegates to, and from the issuer LSVIDs (dashed) to the hops they sign.
+// Valid hops are green, invalid ones red and redacted ones gray.
+func (v *ChainView) DOT() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph lsvid {\n")
+	fmt.Fprintf(&b, "  rankdir=LR;\n")
+	fmt.Fprintf(&b, "  label=%s;\n", dotQuote("LSVID chain ["+v.Status+"]"))
+	fmt.Fprintf(&b, "  node [shape=box, style=filled];\n")
+
+	colors := map[string]string{
+		HopValid:    "palegreen",
+		HopInvalid:  "lightcoral",
+		HopRedacted: "lightgray",
+	}
+	for _, hop := range v.nodes {
+		label := hop.Kind + " [" + hop.Status + "]"
+		if hop.Kind != "redacted" {
+			label += "\\niss " + hop.Issuer
+			if hop.Subject != "" {
+				label += "\\nsub " + hop.Subject
+			}
+			label += "\\naud " + strings.Join(hop.Audiences, ", ")
+		}
+		fmt.Fprintf(&b, "  %s [label=%s, fillcolor=%s];\n", hop.id, dotQuote(label), colors[hop.Status])
+	}
+	for _, hop := range v.nodes {
+		for _, parent := range hop.Parents {
+			fmt.Fprintf(&b, "  %s -> %s;\n", parent.id, hop.id)
+		}
+		if hop.IssLSVID != nil {
+			fmt.Fprintf(&b, "  %s -> %s [style=dashed, label=\"iss\"];\n", hop.IssLSVID.id, hop.id)
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// dotQuote quotes a DOT string, keeping the \n line breaks.
+func dotQuote(s string) string {
+	return "\"" + strings.ReplaceAll(s, "\"", "\\\"") + "\""
+}
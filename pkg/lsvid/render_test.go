@@ -0,0 +1,192 @@
+package lsvid
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+)
+
+// renderChain returns the hash linked chain a -> b -> c -> d, with the hop of b redacted.
+func renderChain(t *testing.T) (*testCA, *LSVID) {
+	ca := newTestCA(t, "example.org")
+	svids := []*x509svid.SVID{ca.newSVID(t, "/a"), ca.newSVID(t, "/b"), ca.newSVID(t, "/c"), ca.newSVID(t, "/d")}
+	lsvid := ca.newChain(t, svids, func(_ int, payload *Payload) {
+		payload.Ver = VerHashLinked
+	})
+	if err := Redact(lsvid, 1); err != nil {
+		t.Fatalf("Redact: %v", err)
+	}
+	return ca, lsvid
+}
+
+func TestChainViewTree(t *testing.T) {
+	ca, lsvid := renderChain(t)
+	view := NewChainView(lsvid.Token, WithBundleSource(newTestTrustBundle(t, ca)), WithRedactionPolicy(AllowRedaction(1)))
+	if view.Status != HopValid {
+		t.Fatalf("got status %s: %s", view.Status, view.Error)
+	}
+
+	tree := view.Tree()
+	for _, line := range []string{
+		"LSVID chain [valid]\n",
+		"[0] hop [valid] iss spiffe://example.org/c aud spiffe://example.org/d alg ES256",
+		"    issuer LSVID [valid]:\n",
+		"  [1] redacted [redacted]\n",
+		"    [2] hop [valid] iss spiffe://example.org/a aud spiffe://example.org/b",
+		"      [3] root [valid] iss spiffe://example.org sub spiffe://example.org/a",
+	} {
+		if !strings.Contains(tree, line) {
+			t.Errorf("tree has no line %q:\n%s", line, tree)
+		}
+	}
+
+	// The redacted hop is rejected by default
+	view = NewChainView(lsvid.Token, WithBundleSource(newTestTrustBundle(t, ca)))
+	if view.Status != HopInvalid || !strings.HasPrefix(view.Tree(), "LSVID chain [invalid] ") {
+		t.Errorf("got status %s, want %s with its error", view.Status, HopInvalid)
+	}
+}
+
+func TestChainViewJSON(t *testing.T) {
+	ca, lsvid := renderChain(t)
+	viewJSON, err := NewChainView(lsvid.Token, WithBundleSource(newTestTrustBundle(t, ca)), WithRedactionPolicy(AllowRedaction(1))).JSON()
+	if err != nil {
+		t.Fatalf("JSON: %v", err)
+	}
+
+	var view ChainView
+	if err := json.Unmarshal(viewJSON, &view); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	want := []struct{ kind, status, issuer string }{
+		{"hop", HopValid, "spiffe://example.org/c"},
+		{"redacted", HopRedacted, ""},
+		{"hop", HopValid, "spiffe://example.org/a"},
+		{"root", HopValid, "spiffe://example.org"},
+	}
+	hop := view.Hop
+	for depth, w := range want {
+		if hop == nil {
+			t.Fatalf("hop %d not found", depth)
+		}
+		if hop.Depth != depth || hop.Kind != w.kind || hop.Status != w.status || hop.Issuer != w.issuer {
+			t.Errorf("hop %d: got %s %s %s, want %s %s %s", depth, hop.Kind, hop.Status, hop.Issuer, w.kind, w.status, w.issuer)
+		}
+		if (hop.Kind == "hop") != (hop.IssLSVID != nil) || (hop.IssLSVID != nil && hop.IssStatus != HopValid) {
+			t.Errorf("hop %d: got issuer LSVID %v [%s]", depth, hop.IssLSVID, hop.IssStatus)
+		}
+		if len(hop.Parents) > 0 {
+			hop = hop.Parents[0]
+		} else {
+			hop = nil
+		}
+	}
+}
+
+func TestChainViewDOT(t *testing.T) {
+	ca, lsvid := renderChain(t)
+	dot := NewChainView(lsvid.Token, WithBundleSource(newTestTrustBundle(t, ca)), WithRedactionPolicy(AllowRedaction(1))).DOT()
+
+	if !strings.HasPrefix(dot, "digraph lsvid {\n") || !strings.HasSuffix(dot, "}\n") {
+		t.Fatalf("invalid graph:\n%s", dot)
+	}
+	for _, s := range []string{
+		`label="LSVID chain [valid]";`,
+		`[label="redacted [redacted]", fillcolor=lightgray];`,
+		`[label="hop [valid]\niss spiffe://example.org/c\naud spiffe://example.org/d", fillcolor=palegreen];`,
+		`[label="root [valid]\niss spiffe://example.org\nsub spiffe://example.org/a\naud spiffe://example.org/a", fillcolor=palegreen];`,
+	} {
+		if !strings.Contains(dot, s) {
+			t.Errorf("graph has no %s:\n%s", s, dot)
+		}
+	}
+	// 3 delegation edges, and the issuer LSVIDs of the 2 visible hops
+	if n := strings.Count(dot, "->"); n != 5 {
+		t.Errorf("got %d edges, want 5:\n%s", n, dot)
+	}
+	if n := strings.Count(dot, "[style=dashed, label=\"iss\"]"); n != 2 {
+		t.Errorf("got %d issuer edges, want 2", n)
+	}
+}
+
+func TestChainViewInvalidHop(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	svids := []*x509svid.SVID{ca.newSVID(t, "/a"), ca.newSVID(t, "/b"), ca.newSVID(t, "/c")}
+	lsvid := ca.newChain(t, svids, nil)
+	lsvid.Token.Signature[len(lsvid.Token.Signature)-1] ^= 0xff
+
+	view := NewChainView(lsvid.Token, WithBundleSource(newTestTrustBundle(t, ca)))
+	if view.Status != HopInvalid || view.Hop.Status != HopInvalid {
+		t.Errorf("got chain %s and hop %s, want %s", view.Status, view.Hop.Status, HopInvalid)
+	}
+	if nested := view.Hop.Parents[0]; nested.Status != HopValid {
+		t.Errorf("got nested hop %s, want %s", nested.Status, HopValid)
+	}
+	if !strings.Contains(view.DOT(), "fillcolor=lightcoral") {
+		t.Error("invalid hop not colored")
+	}
+}
+
+// Hops without issuer are invalid, and do not stop the rendering nor the validation.
+func TestChainViewNoIssuer(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	svids := []*x509svid.SVID{ca.newSVID(t, "/a"), ca.newSVID(t, "/b"), ca.newSVID(t, "/c")}
+
+	lsvid := ca.newChain(t, svids, nil)
+	lsvid.Token.Payload.Iss = nil
+	view := NewChainView(lsvid.Token, WithBundleSource(newTestTrustBundle(t, ca)))
+	if view.Status != HopInvalid || view.Hop.Status != HopInvalid || view.Hop.Error == "" {
+		t.Errorf("hop without issuer rendered %s (%s)", view.Hop.Status, view.Hop.Error)
+	}
+	if !strings.Contains(view.Tree(), "[0] hop [invalid] iss -") {
+		t.Errorf("unexpected tree:\n%s", view.Tree())
+	}
+
+	lsvid = ca.newChain(t, svids, nil)
+	root := lsvid.Token.Nested.Nested
+	root.Payload.Iss = nil
+	if valid, _ := Validate(lsvid.Token, WithBundleSource(newTestTrustBundle(t, ca))); valid {
+		t.Error("root without issuer accepted")
+	}
+	view = NewChainView(lsvid.Token)
+	if hop := view.Hop.Parents[0].Parents[0]; hop.Kind != "root" || hop.Status != HopInvalid {
+		t.Errorf("root without issuer rendered %s [%s]", hop.Kind, hop.Status)
+	}
+}
+
+// Hops are verified as in Validate: the issuer LSVID must be issued to the hop
+// issuer, by its trust domain.
+func TestChainViewIssuerBinding(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	other := newTestCA(t, "other.org")
+	svids := []*x509svid.SVID{ca.newSVID(t, "/a"), ca.newSVID(t, "/b"), ca.newSVID(t, "/c")}
+	bundles := NewBundleSet(newTestTrustBundle(t, ca), newTestTrustBundle(t, other))
+
+	for _, tt := range []struct {
+		name   string
+		issuer func(payload *Payload) *x509svid.SVID
+	}{
+		{"issued to another workload", func(payload *Payload) *x509svid.SVID {
+			payload.Iss.ID = ca.newLSVID(t, svids[0])
+			return svids[0]
+		}},
+		{"issued by another trust domain", func(payload *Payload) *x509svid.SVID {
+			payload.Iss.ID = other.newLSVID(t, svids[1])
+			return svids[1]
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			lsvid := ca.newChain(t, svids, nil)
+			payload := ca.newPayload(t, svids[1], svids[2].ID.String())
+			signJoin(t, lsvid.Token, payload, tt.issuer(payload))
+
+			checkValidate(t, lsvid.Token, false, WithBundleSource(bundles))
+			view := NewChainView(lsvid.Token, WithBundleSource(bundles))
+			if view.Hop.Status != HopInvalid || view.Hop.Error == "" {
+				t.Errorf("got hop %s (%s), want %s", view.Hop.Status, view.Hop.Error, HopInvalid)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package lsvid
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EffectiveScope computes the scope granted at the outer most hop.
+//
+// The scope is set by the hop creating the delegation (e.g., from the OAuth token
+// scopes), the one extending a root LSVID, and inherited by the later hops, that
+// may set a narrower scope but never a wider one. Join hops inherit the scopes
+// common to all parents. Redacted hops do not change the scope. It returns false
+// if the delegation sets no scope, and an error if a hop widens its inherited
+// scope or sets one in an unscoped delegation.
+func EffectiveScope(lsvid *Token) ([]string, bool, error) {
+	var effective func(token *Token) ([]string, bool, error)
+	effective = func(token *Token) ([]string, bool, error) {
+		parents := token.Parents()
+		if len(parents) == 0 {
+			return nil, false, nil
+		}
+
+		var inherited []string
+		var scoped bool
+		for _, parent := range parents {
+			scope, ok, err := effective(parent)
+			if err != nil {
+				return nil, false, err
+			}
+			if !ok {
+				continue
+			}
+			if !scoped {
+				inherited, scoped = scope, true
+				continue
+			}
+			inherited = intersectScopes(inherited, scope)
+		}
+
+		if token.Payload == nil || len(token.Payload.Scp) == 0 {
+			return inherited, scoped, nil
+		}
+		if token.Nested != nil && len(token.Nested.Parents()) == 0 {
+			// the hop creating the delegation
+			return token.Payload.Scp, true, nil
+		}
+		if !scoped {
+			return nil, false, fmt.Errorf("Hop by %s sets a scope not granted by the delegation\n", idClaimCN(token.Payload.Iss))
+		}
+		for _, s := range token.Payload.Scp {
+			if !containsString(inherited, s) {
+				return nil, false, fmt.Errorf("Hop by %s widens the scope with %q\n", idClaimCN(token.Payload.Iss), s)
+			}
+		}
+		return token.Payload.Scp, true, nil
+	}
+
+	return effective(lsvid)
+}
+
+// ParseScope parses an OAuth scope claim, either a space separated string
+// ("scope") or a list ("scp").
+func ParseScope(claim interface{}) []string {
+	switch claim := claim.(type) {
+	case string:
+		return strings.Fields(claim)
+	case []string:
+		return claim
+	case []interface{}:
+		var scopes []string
+		for _, s := range claim {
+			if s, ok := s.(string); ok {
+				scopes = append(scopes, s)
+			}
+		}
+		return scopes
+	default:
+		return nil
+	}
+}
+
+func intersectScopes(a, b []string) []string {
+	var scopes []string
+	for _, s := range a {
+		if containsString(b, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
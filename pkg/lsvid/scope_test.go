@@ -0,0 +1,87 @@
+package lsvid
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEffectiveScope(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	path := ca.newPath(t, "/asserting-wl", "/middle-tier", "/target-wl")
+
+	scopes := func(scopes ...[]string) func(int, *Payload) {
+		return func(hop int, payload *Payload) {
+			payload.Scp = scopes[hop]
+		}
+	}
+	for _, tt := range []struct {
+		name   string
+		edit   func(int, *Payload)
+		scope  []string
+		scoped bool
+		valid  bool
+	}{
+		{"unscoped", nil, nil, false, true},
+		{"inherited", scopes([]string{"deposit", "balance"}, nil), []string{"deposit", "balance"}, true, true},
+		{"narrowed", scopes([]string{"deposit", "balance"}, []string{"balance"}), []string{"balance"}, true, true},
+		{"widened", scopes([]string{"balance"}, []string{"balance", "deposit"}), nil, false, false},
+		{"introduced", scopes(nil, []string{"balance"}), nil, false, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			lsvid := ca.newChain(t, path, tt.edit)
+			scope, scoped, err := EffectiveScope(lsvid.Token)
+			if tt.valid != (err == nil) {
+				t.Fatalf("EffectiveScope: %v", err)
+			}
+			if scoped != tt.scoped || !reflect.DeepEqual(scope, tt.scope) {
+				t.Errorf("got scope %v (%v), want %v (%v)", scope, scoped, tt.scope, tt.scoped)
+			}
+			checkValidate(t, lsvid.Token, tt.valid)
+		})
+	}
+}
+
+func TestRequiredScope(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	path := ca.newPath(t, "/asserting-wl", "/middle-tier", "/target-wl")
+
+	lsvid := ca.newChain(t, path, func(hop int, payload *Payload) {
+		if hop == 0 {
+			payload.Scp = []string{"deposit", "balance"}
+		} else {
+			payload.Scp = []string{"balance"}
+		}
+	})
+	checkValidate(t, lsvid.Token, true, WithRequiredScope("balance"))
+	// scope dropped by the middle tier
+	checkValidate(t, lsvid.Token, false, WithRequiredScope("deposit"))
+	// unscoped LSVID
+	checkValidate(t, ca.newChain(t, path[:2], nil).Token, false, WithRequiredScope("balance"))
+	// scope introduced after the delegation
+	introduced := ca.newChain(t, path, func(hop int, payload *Payload) {
+		if hop == 1 {
+			payload.Scp = []string{"balance"}
+		}
+	})
+	checkValidate(t, introduced.Token, false, WithRequiredScope("balance"))
+
+	// the middle tier can not drop its narrowing hop scope
+	lsvid.Token.Payload.Scp = nil
+	checkValidate(t, lsvid.Token, false, WithRequiredScope("deposit"))
+}
+
+func TestParseScope(t *testing.T) {
+	for _, tt := range []struct {
+		claim interface{}
+		scope []string
+	}{
+		{"deposit  balance", []string{"deposit", "balance"}},
+		{[]string{"deposit"}, []string{"deposit"}},
+		{[]interface{}{"deposit", 1, "balance"}, []string{"deposit", "balance"}},
+		{42, nil},
+	} {
+		if scope := ParseScope(tt.claim); !reflect.DeepEqual(scope, tt.scope) {
+			t.Errorf("ParseScope(%v) = %v, want %v", tt.claim, scope, tt.scope)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package lsvid
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// SignerService signs on behalf of the local workloads, so they never hold the hop keys.
+// It is served over a Unix socket (Serve), and used through a SignerClient.
+type SignerService struct {
+	key       crypto.Signer
+	authorize PeerAuthorizer
+	mux       *http.ServeMux
+}
+
+type signRequest struct {
+	Digest []byte      `json:"digest"`
+	Hash   crypto.Hash `json:"hash"`
+}
+
+type signResponse struct {
+	Signature []byte `json:"signature"`
+}
+
+type extendRequest struct {
+	LSVID   string   `json:"lsvid"`
+	Payload *Payload `json:"payload"`
+}
+
+type extendResponse struct {
+	LSVID string `json:"lsvid"`
+}
+
+// NewSignerService creates a signer holding key, serving the processes allowed by authorize.
+func NewSignerService(key crypto.Signer, authorize PeerAuthorizer) *SignerService {
+	s := &SignerService{
+		key:       key,
+		authorize: authorize,
+		mux:       http.NewServeMux(),
+	}
+	s.mux.HandleFunc("/public", s.handlePublic)
+	s.mux.HandleFunc("/sign", s.handleSign)
+	s.mux.HandleFunc("/extend", s.handleExtend)
+
+	return s
+}
+
+// Serve serves the signer on a Unix socket listener.
+func (s *SignerService) Serve(l net.Listener) error {
+	return servePeers(l, s)
+}
+
+// ServeHTTP authorizes the peer process before serving the request.
+func (s *SignerService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cred, ok := r.Context().Value(peerCredKey{}).(*PeerCred)
+	if !ok {
+		http.Error(w, "peer credentials not available", http.StatusForbidden)
+		return
+	}
+	if err := s.authorize(cred); err != nil {
+		log.Printf("Signer request from pid %d denied: %v\n", cred.PID, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *SignerService) handlePublic(w http.ResponseWriter, r *http.Request) {
+	der, err := x509.MarshalPKIXPublicKey(s.key.Public())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(der)
+}
+
+func (s *SignerService) handleSign(w http.ResponseWriter, r *http.Request) {
+	var req signRequest
+	if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&req) != nil {
+		http.Error(w, "invalid sign request", http.StatusBadRequest)
+		return
+	}
+	if !req.Hash.Available() || len(req.Digest) != req.Hash.Size() {
+		http.Error(w, "invalid digest", http.StatusBadRequest)
+		return
+	}
+
+	signature, err := s.key.Sign(nil, req.Digest, req.Hash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(&signResponse{Signature: signature})
+}
+
+func (s *SignerService) handleExtend(w http.ResponseWriter, r *http.Request) {
+	var req extendRequest
+	if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&req) != nil || req.Payload == nil {
+		http.Error(w, "invalid extend request", http.StatusBadRequest)
+		return
+	}
+	decLSVID, err := Decode(req.LSVID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	extLSVID, err := Extend(decLSVID, req.Payload, s.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(&extendResponse{LSVID: extLSVID})
+}
+
+// SignerClient is a crypto.Signer backed by a SignerService, so existing code
+// (e.g.: Extend, dasvid.NewECDSAencode) can use it in place of the SVID private key.
+type SignerClient struct {
+	client *http.Client
+
+	mtx    sync.Mutex
+	public crypto.PublicKey
+}
+
+// NewSignerClient connects to the signer listening on socketPath (e.g.: unix:///tmp/lsvid-signer/api.sock).
+func NewSignerClient(ctx context.Context, socketPath string) (*SignerClient, error) {
+	socketPath = strings.TrimPrefix(socketPath, "unix://")
+
+	c := &SignerClient{
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socketPath)
+				},
+			},
+		},
+	}
+
+	var err error
+	if c.public, err = c.fetchPublic(ctx); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Public returns the current public key of the signer, queried on each call so it
+// follows the rotation of the signer key (e.g.: the X509-SVID key of lsvid-signer).
+// If the signer can not be reached, the last known key is returned.
+func (c *SignerClient) Public() crypto.PublicKey {
+	public, err := c.fetchPublic(context.Background())
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if err != nil {
+		log.Printf("Unable to refresh signer public key: %v", err)
+		return c.public
+	}
+	c.public = public
+	return public
+}
+
+func (c *SignerClient) fetchPublic(ctx context.Context) (crypto.PublicKey, error) {
+	der, err := c.call(ctx, "/public", nil)
+	if err != nil {
+		return nil, err
+	}
+	public, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse public key: %v\n", err)
+	}
+	return public, nil
+}
+
+// Sign signs the digest with the signer key. Only hash options are supported (e.g.: no RSA-PSS).
+func (c *SignerClient) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	hash, ok := opts.(crypto.Hash)
+	if !ok {
+		return nil, fmt.Errorf("Unsupported signer options %T\n", opts)
+	}
+
+	resp, err := c.call(context.Background(), "/sign", &signRequest{Digest: digest, Hash: hash})
+	if err != nil {
+		return nil, err
+	}
+	var signed signResponse
+	if err := json.Unmarshal(resp, &signed); err != nil {
+		return nil, fmt.Errorf("Invalid signer response: %v\n", err)
+	}
+	return signed.Signature, nil
+}
+
+// Extend extends the LSVID with the new payload, signed by the signer.
+func (c *SignerClient) Extend(ctx context.Context, lsvid *LSVID, newPayload *Payload) (string, error) {
+	encLSVID, err := Encode(lsvid)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := c.call(ctx, "/extend", &extendRequest{LSVID: encLSVID, Payload: newPayload})
+	if err != nil {
+		return "", err
+	}
+	var extended extendResponse
+	if err := json.Unmarshal(resp, &extended); err != nil {
+		return "", fmt.Errorf("Invalid signer response: %v\n", err)
+	}
+	return extended.LSVID, nil
+}
+
+func (c *SignerClient) call(ctx context.Context, path string, in interface{}) ([]byte, error) {
+	method, body := http.MethodGet, []byte(nil)
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return nil, fmt.Errorf("Error generating json: %v\n", err)
+		}
+		method = http.MethodPost
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, "http://lsvid-signer"+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to reach signer: %v\n", err)
+	}
+	defer resp.Body.Close()
+
+	out, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read signer response: %v\n", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Signer returned %s: %s\n", resp.Status, strings.TrimSpace(string(out)))
+	}
+	return out, nil
+}
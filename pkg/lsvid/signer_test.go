@@ -0,0 +1,195 @@
+package lsvid
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	hash256 "crypto/sha256"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+)
+
+// serveTestSigner serves the signer on a Unix socket, returning the socket path.
+func serveTestSigner(t *testing.T, s *SignerService) string {
+	socketPath := filepath.Join(t.TempDir(), "api.sock")
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go s.Serve(l)
+	return "unix://" + socketPath
+}
+
+func TestSignerService(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials only supported on Linux")
+	}
+
+	ca := newTestCA(t, "example.org")
+	asserting := ca.newSVID(t, "/asserting-wl")
+	middle := ca.newSVID(t, "/middle-tier")
+	target := ca.newSVID(t, "/target-wl")
+
+	ctx := context.Background()
+	socketPath := serveTestSigner(t, NewSignerService(middle.PrivateKey, AuthorizeUIDs(uint32(os.Getuid()))))
+	signer, err := NewSignerClient(ctx, socketPath)
+	if err != nil {
+		t.Fatalf("NewSignerClient: %v", err)
+	}
+	pub, ok := signer.Public().(*ecdsa.PublicKey)
+	if !ok || !pub.Equal(middle.PrivateKey.Public()) {
+		t.Fatalf("got public key %v", signer.Public())
+	}
+
+	digest := hash256.Sum256([]byte("hop"))
+	signature, err := signer.Sign(nil, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if !ecdsa.VerifyASN1(pub, digest[:], signature) {
+		t.Error("invalid signature")
+	}
+	if _, err := signer.Sign(nil, digest[:16], crypto.SHA256); err == nil {
+		t.Error("digest of the wrong size signed")
+	}
+
+	// the middle tier extends LSVIDs without holding the key
+	received := ca.newChain(t, []*x509svid.SVID{asserting, middle}, nil)
+	encLSVID, err := signer.Extend(ctx, received, ca.newPayload(t, middle, target.ID.String()))
+	if err != nil {
+		t.Fatalf("Extend: %v", err)
+	}
+	extended, err := Decode(encLSVID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if valid, err := Validate(extended.Token); !valid {
+		t.Errorf("LSVID extended by the signer rejected: %v", err)
+	}
+	if encLSVID, err = Extend(received, ca.newPayload(t, middle, target.ID.String()), signer); err != nil {
+		t.Fatalf("Extend with SignerClient: %v", err)
+	}
+	if extended, err = Decode(encLSVID); err != nil {
+		t.Fatal(err)
+	}
+	if valid, err := Validate(extended.Token); !valid {
+		t.Errorf("LSVID signed by the signer rejected: %v", err)
+	}
+}
+
+// rotatingSigner signs with its current key, as the X509-SVID signer of lsvid-signer.
+type rotatingSigner struct {
+	mtx sync.Mutex
+	key crypto.Signer
+}
+
+func (s *rotatingSigner) current() crypto.Signer {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.key
+}
+
+func (s *rotatingSigner) rotate(key crypto.Signer) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.key = key
+}
+
+func (s *rotatingSigner) Public() crypto.PublicKey {
+	return s.current().Public()
+}
+
+func (s *rotatingSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return s.current().Sign(rand, digest, opts)
+}
+
+func TestSignerClientRotation(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials only supported on Linux")
+	}
+
+	ca := newTestCA(t, "example.org")
+	key := &rotatingSigner{key: ca.newSVID(t, "/middle-tier").PrivateKey}
+	socketPath := serveTestSigner(t, NewSignerService(key, AuthorizeUIDs(uint32(os.Getuid()))))
+	signer, err := NewSignerClient(context.Background(), socketPath)
+	if err != nil {
+		t.Fatalf("NewSignerClient: %v", err)
+	}
+
+	// the X509-SVID of the signer is rotated
+	rotated := ca.newSVID(t, "/middle-tier").PrivateKey
+	key.rotate(rotated)
+	pub, ok := signer.Public().(*ecdsa.PublicKey)
+	if !ok || !pub.Equal(rotated.Public()) {
+		t.Fatalf("got public key %v after rotation", signer.Public())
+	}
+	digest := hash256.Sum256([]byte("hop"))
+	signature, err := signer.Sign(nil, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if !ecdsa.VerifyASN1(pub, digest[:], signature) {
+		t.Error("signature not verified by the rotated key")
+	}
+}
+
+func TestSignerServiceUnauthorized(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials only supported on Linux")
+	}
+
+	ca := newTestCA(t, "example.org")
+	middle := ca.newSVID(t, "/middle-tier")
+
+	// processes of other users are denied
+	socketPath := serveTestSigner(t, NewSignerService(middle.PrivateKey, AuthorizeUIDs(uint32(os.Getuid()+1))))
+	if _, err := NewSignerClient(context.Background(), socketPath); err == nil {
+		t.Error("signer used by an unauthorized user")
+	}
+
+	// and requests without peer credentials (e.g.: not over a Unix socket)
+	server := httptest.NewServer(NewSignerService(middle.PrivateKey, AuthorizeUIDs(uint32(os.Getuid()))))
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/public")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("request without peer credentials got status %d", resp.StatusCode)
+	}
+}
+
+func TestAuthorizeExecutables(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("/proc/<pid>/exe only available on Linux")
+	}
+
+	exe, err := os.Readlink("/proc/self/exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred := &PeerCred{PID: int32(os.Getpid()), UID: uint32(os.Getuid())}
+	if err := AuthorizeExecutables("/usr/bin/other", exe)(cred); err != nil {
+		t.Errorf("AuthorizeExecutables: %v", err)
+	}
+	if err := AuthorizeExecutables("/usr/bin/other")(cred); err == nil {
+		t.Error("executable not in the list authorized")
+	}
+	if err := AuthorizeExecutables(exe)(&PeerCred{PID: -1}); err == nil {
+		t.Error("process not attested authorized")
+	}
+	if err := AuthorizeUIDs(0, cred.UID)(cred); err != nil {
+		t.Errorf("AuthorizeUIDs: %v", err)
+	}
+}
@@ -0,0 +1,348 @@
+package lsvid
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Maximum size of a decompressed status list (8M hops), and of its encoded LSVID.
+const (
+	maxStatusListSize  = 1 << 20
+	maxStatusListToken = 4 << 20
+)
+
+// StatusRef references the status of a hop in the status list of its issuer.
+type StatusRef struct {
+	Idx int    `json:"idx"`
+	Uri string `json:"uri"` // e.g.: https://asserting-wl:8443/status
+}
+
+// StatusListClaim is the signed status list: bit i set means the hop with index i is revoked.
+type StatusListClaim struct {
+	Bits []byte `json:"bits"`          // zlib compressed bitstring
+	Uri  string `json:"uri"`           // where the list is published
+	Ttl  int64  `json:"ttl,omitempty"` // seconds verifiers may cache the list
+}
+
+// StatusList is the issuer side of a status list. It assigns indexes to the
+// issued hops and tracks the revoked ones.
+type StatusList struct {
+	mtx  sync.Mutex
+	uri  string
+	ttl  time.Duration
+	size int
+	bits []byte
+}
+
+// statusListState is the persisted status list.
+type statusListState struct {
+	Size int    `json:"size"`
+	Bits []byte `json:"bits"`
+}
+
+// NewStatusList creates an empty status list published at uri.
+func NewStatusList(uri string, ttl time.Duration) *StatusList {
+	return &StatusList{
+		uri: uri,
+		ttl: ttl,
+	}
+}
+
+// LoadStatusList loads a status list saved at path, or creates an empty one if path does not exist.
+func LoadStatusList(path string, uri string, ttl time.Duration) (*StatusList, error) {
+	list := NewStatusList(uri, ttl)
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return list, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading status list: %v\n", err)
+	}
+	var state statusListState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("Error unmarshaling status list: %v\n", err)
+	}
+	list.size, list.bits = state.Size, state.Bits
+
+	return list, nil
+}
+
+// Save saves the status list at path.
+func (l *StatusList) Save(path string) error {
+	l.mtx.Lock()
+	data, err := json.Marshal(&statusListState{Size: l.size, Bits: l.bits})
+	l.mtx.Unlock()
+	if err != nil {
+		return fmt.Errorf("Error generating json: %v\n", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("Error writing status list: %v\n", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// Allocate assigns the next index of the list, to be set in the hop payload (Payload.Sts).
+func (l *StatusList) Allocate() *StatusRef {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	idx := l.size
+	l.size++
+	for len(l.bits)*8 < l.size {
+		l.bits = append(l.bits, 0)
+	}
+
+	return &StatusRef{
+		Idx: idx,
+		Uri: l.uri,
+	}
+}
+
+// Revoke revokes the hop with the given index.
+func (l *StatusList) Revoke(idx int) error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if idx < 0 || idx >= l.size {
+		return fmt.Errorf("Status index %d not allocated\n", idx)
+	}
+	l.bits[idx/8] |= 1 << (idx % 8)
+	return nil
+}
+
+// Revoked checks if the hop with the given index is revoked.
+func (l *StatusList) Revoked(idx int) bool {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	return revokedBit(l.bits, idx)
+}
+
+// Sign creates the signed status list token, issued by the hop issuer.
+func (l *StatusList) Sign(issuer *IDClaim, key crypto.Signer) (*Token, error) {
+	l.mtx.Lock()
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	_, err := zw.Write(l.bits)
+	l.mtx.Unlock()
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error compressing status list: %v\n", err)
+	}
+
+	alg, err := keyAlgorithm(key.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	payload := &Payload{
+		Ver: 1,
+		Alg: alg,
+		Iat: time.Now().Round(0).Unix(),
+		Iss: issuer,
+		Lst: &StatusListClaim{
+			Bits: compressed.Bytes(),
+			Uri:  l.uri,
+			Ttl:  int64(l.ttl / time.Second),
+		},
+	}
+	return signRoot(payload, key)
+}
+
+func revokedBit(bits []byte, idx int) bool {
+	if idx < 0 || idx/8 >= len(bits) {
+		return false
+	}
+	return bits[idx/8]&(1<<(idx%8)) != 0
+}
+
+// StatusListCache fetches and caches the status lists referenced by the LSVID hops.
+// Lists are published over HTTPS, encoded as LSVIDs. As the URIs are set by the
+// hop issuers, other locations (e.g.: http:// or file:// URIs) must be allowed
+// when creating the cache.
+type StatusListCache struct {
+	client  *http.Client
+	ttl     time.Duration
+	allowed []string
+
+	mtx   sync.Mutex
+	lists map[string]*cachedStatusList
+}
+
+type cachedStatusList struct {
+	issuer  string
+	bits    []byte
+	expires time.Time
+}
+
+// NewStatusListCache creates a cache using client to fetch the lists. Lists are kept
+// for their own TTL, or ttl if they have none.
+// If allowed URI prefixes are given (e.g.: https://asserting-wl:8443/ or
+// file:///data/), lists are only fetched from them. Otherwise, any https URI is allowed.
+func NewStatusListCache(client *http.Client, ttl time.Duration, allowed ...string) *StatusListCache {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &StatusListCache{
+		client:  client,
+		ttl:     ttl,
+		allowed: allowed,
+		lists:   make(map[string]*cachedStatusList),
+	}
+}
+
+// allows checks if a status list may be fetched from uri.
+func (c *StatusListCache) allows(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || strings.Contains(u.Path, "..") {
+		return false
+	}
+	if len(c.allowed) == 0 {
+		return u.Scheme == "https"
+	}
+	for _, prefix := range c.allowed {
+		if strings.HasPrefix(uri, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkStatus rejects the revoked hops of the chain.
+func (c *StatusListCache) checkStatus(ctx context.Context, chain *Token, config *validateConfig) error {
+	return Walk(chain, func(token *Token, _ int) error {
+		if token.Payload == nil || token.Payload.Sts == nil {
+			return nil
+		}
+		issuer := idClaimCN(token.Payload.Iss)
+
+		list, err := c.get(ctx, token.Payload.Sts.Uri, config)
+		if err != nil {
+			return err
+		}
+		if list.issuer != issuer {
+			return fmt.Errorf("Status list of %s issued by %s\n", issuer, list.issuer)
+		}
+		if revokedBit(list.bits, token.Payload.Sts.Idx) {
+			return fmt.Errorf("Hop by %s was revoked\n", issuer)
+		}
+		return nil
+	})
+}
+
+func (c *StatusListCache) get(ctx context.Context, uri string, config *validateConfig) (*cachedStatusList, error) {
+	c.mtx.Lock()
+	cached, ok := c.lists[uri]
+	c.mtx.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached, nil
+	}
+
+	encLSVID, err := c.fetch(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	lsvid, err := Decode(strings.TrimSpace(encLSVID))
+	if err != nil {
+		return nil, err
+	}
+	token := lsvid.Token
+	if token == nil || token.Payload == nil || token.Payload.Lst == nil || token.Payload.Iss == nil {
+		return nil, fmt.Errorf("Invalid status list at %s\n", uri)
+	}
+	if token.Payload.Lst.Uri != uri {
+		return nil, fmt.Errorf("Status list published at %s, not at %s\n", token.Payload.Lst.Uri, uri)
+	}
+	if err := verifyIssuedToken(token, token.Payload.Iss.CN, config); err != nil {
+		return nil, fmt.Errorf("Status list validation failed: %v\n", err)
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(token.Payload.Lst.Bits))
+	if err != nil {
+		return nil, fmt.Errorf("Error decompressing status list: %v\n", err)
+	}
+	bits, err := readLimited(zr, maxStatusListSize)
+	if err != nil {
+		return nil, fmt.Errorf("Error decompressing status list: %v\n", err)
+	}
+
+	ttl := c.ttl
+	if token.Payload.Lst.Ttl > 0 {
+		ttl = time.Duration(token.Payload.Lst.Ttl) * time.Second
+	}
+	cached = &cachedStatusList{
+		issuer:  token.Payload.Iss.CN,
+		bits:    bits,
+		expires: time.Now().Add(ttl),
+	}
+	c.mtx.Lock()
+	c.lists[uri] = cached
+	c.mtx.Unlock()
+
+	return cached, nil
+}
+
+func (c *StatusListCache) fetch(ctx context.Context, uri string) (string, error) {
+	if !c.allows(uri) {
+		return "", fmt.Errorf("Status list location %s not allowed\n", uri)
+	}
+	if path := strings.TrimPrefix(uri, "file://"); path != uri {
+		f, err := os.Open(path)
+		if err != nil {
+			return "", fmt.Errorf("Error reading status list: %v\n", err)
+		}
+		defer f.Close()
+		data, err := readLimited(f, maxStatusListToken)
+		if err != nil {
+			return "", fmt.Errorf("Error reading status list: %v\n", err)
+		}
+		return string(data), nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("Unable to fetch status list: %v\n", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := readLimited(resp.Body, maxStatusListToken)
+	if err != nil {
+		return "", fmt.Errorf("Unable to read status list: %v\n", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Status list endpoint returned %s\n", resp.Status)
+	}
+	return string(body), nil
+}
+
+// readLimited reads r up to max bytes, failing if it has more.
+func readLimited(r io.Reader, max int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > max {
+		return nil, fmt.Errorf("more than %d bytes", max)
+	}
+	return data, nil
+}
@@ -0,0 +1,220 @@
+package lsvid
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+)
+
+func TestStatusList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status.list")
+	list, err := LoadStatusList(path, "https://asserting-wl/status", 0)
+	if err != nil {
+		t.Fatalf("LoadStatusList: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if ref := list.Allocate(); ref.Idx != i || ref.Uri != "https://asserting-wl/status" {
+			t.Fatalf("got status %+v, want index %d", ref, i)
+		}
+	}
+	if err := list.Revoke(9); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if err := list.Revoke(10); err == nil {
+		t.Error("status index not allocated revoked")
+	}
+	if err := list.Save(path); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	loaded, err := LoadStatusList(path, "https://asserting-wl/status", 0)
+	if err != nil {
+		t.Fatalf("LoadStatusList: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if loaded.Revoked(i) != (i == 9) {
+			t.Errorf("status index %d revoked: %v", i, loaded.Revoked(i))
+		}
+	}
+	if ref := loaded.Allocate(); ref.Idx != 10 {
+		t.Errorf("got index %d after reload, want 10", ref.Idx)
+	}
+}
+
+func TestStatusListCache(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	asserting := ca.newSVID(t, "/asserting-wl")
+	middle := ca.newSVID(t, "/middle-tier")
+	target := ca.newSVID(t, "/target-wl")
+	trustBundle := newTestTrustBundle(t, ca)
+
+	// the asserting workload publishes its status list
+	issuer := &IDClaim{CN: asserting.ID.String(), ID: ca.newLSVID(t, asserting)}
+	key := asserting.PrivateKey
+	var list *StatusList
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := list.Sign(issuer, key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		encList, err := Encode(&LSVID{Token: token})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, encList)
+	}))
+	defer server.Close()
+	list = NewStatusList(server.URL+"/status", 0)
+
+	var sts *StatusRef
+	lsvid := ca.newChain(t, []*x509svid.SVID{asserting, middle, target}, func(hop int, payload *Payload) {
+		if hop == 0 {
+			list.Allocate()
+			sts = list.Allocate()
+			payload.Sts = sts
+		}
+	})
+	cache := NewStatusListCache(server.Client(), 0)
+	if valid, err := Validate(lsvid.Token, WithBundleSource(trustBundle), WithStatusListCache(cache)); !valid {
+		t.Fatalf("LSVID rejected: %v", err)
+	}
+	if valid, _ := Validate(lsvid.Token, WithStatusListCache(cache)); valid {
+		t.Error("status list accepted without bundle source")
+	}
+
+	if err := list.Revoke(sts.Idx - 1); err != nil {
+		t.Fatal(err)
+	}
+	if valid, err := Validate(lsvid.Token, WithBundleSource(trustBundle), WithStatusListCache(cache)); !valid {
+		t.Fatalf("LSVID rejected after revoking another hop: %v", err)
+	}
+	if err := list.Revoke(sts.Idx); err != nil {
+		t.Fatal(err)
+	}
+	if valid, _ := Validate(lsvid.Token, WithBundleSource(trustBundle), WithStatusListCache(cache)); valid {
+		t.Error("revoked LSVID accepted")
+	}
+
+	// a list signed with a self-made issuer LSVID is rejected
+	attacker := newTestCA(t, "example.org")
+	impostor := attacker.newSVID(t, "/asserting-wl")
+	list = NewStatusList(server.URL+"/status", 0)
+	issuer, key = &IDClaim{CN: impostor.ID.String(), ID: attacker.newLSVID(t, impostor)}, impostor.PrivateKey
+	if valid, _ := Validate(lsvid.Token, WithBundleSource(trustBundle), WithStatusListCache(cache)); valid {
+		t.Error("forged status list accepted")
+	}
+}
+
+func TestStatusListLocation(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	asserting := ca.newSVID(t, "/asserting-wl")
+	middle := ca.newSVID(t, "/middle-tier")
+	trustBundle := newTestTrustBundle(t, ca)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "status.lsvid")
+	list := NewStatusList("file://"+path, 0)
+	lsvid := ca.newChain(t, []*x509svid.SVID{asserting, middle}, func(hop int, payload *Payload) {
+		payload.Sts = list.Allocate()
+	})
+	token, err := list.Sign(&IDClaim{CN: asserting.ID.String(), ID: ca.newLSVID(t, asserting)}, asserting.PrivateKey)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	encList, err := Encode(&LSVID{Token: token})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(encList), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		allowed []string
+		valid   bool
+	}{
+		{"https only", nil, false},
+		{"allowed", []string{"file://" + dir + "/"}, true},
+		{"other prefix", []string{"file:///data/"}, false},
+	} {
+		cache := NewStatusListCache(nil, 0, tt.allowed...)
+		if valid, err := Validate(lsvid.Token, WithBundleSource(trustBundle), WithStatusListCache(cache)); valid != tt.valid {
+			t.Errorf("%s: got valid %v, want %v: %v", tt.name, valid, tt.valid, err)
+		}
+	}
+
+	cache := NewStatusListCache(nil, 0, "file://"+dir+"/")
+	for _, uri := range []string{"file://" + dir + "/../status.lsvid", "http://asserting-wl/status"} {
+		if _, err := cache.get(context.Background(), uri, &validateConfig{bundleSource: trustBundle}); err == nil {
+			t.Errorf("status list fetched from %s", uri)
+		}
+	}
+}
+
+func TestStatusListLimits(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	path := ca.newPath(t, "/asserting-wl", "/middle-tier")
+	trustBundle := newTestTrustBundle(t, ca)
+	config := &validateConfig{bundleSource: trustBundle}
+
+	// a list decompressing above the maximum size (a few KiB compressed)
+	dir := t.TempDir()
+	listPath := filepath.Join(dir, "status.lsvid")
+	list := NewStatusList("file://"+listPath, 0)
+	list.bits = make([]byte, maxStatusListSize+1)
+	token, err := list.Sign(&IDClaim{CN: path[0].ID.String(), ID: ca.newLSVID(t, path[0])}, path[0].PrivateKey)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	encList, err := Encode(&LSVID{Token: token})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(encList) > maxStatusListToken/64 {
+		t.Fatalf("compressed list of %d bytes", len(encList))
+	}
+	if err := os.WriteFile(listPath, []byte(encList), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cache := NewStatusListCache(nil, 0, "file://"+dir+"/")
+	if _, err := cache.get(context.Background(), "file://"+listPath, config); err == nil {
+		t.Error("oversized status list decompressed")
+	}
+
+	// a response above the maximum token size
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, maxStatusListToken+1))
+	}))
+	defer server.Close()
+	cache = NewStatusListCache(server.Client(), 0)
+	if _, err := cache.fetch(context.Background(), server.URL+"/status"); err == nil {
+		t.Error("oversized status list response read")
+	}
+}
+
+// The list alg follows the key of the issuer.
+func TestStatusListSignAlg(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := NewStatusList("https://asserting-wl/status", 0).Sign(&IDClaim{CN: "spiffe://example.org/asserting-wl"}, key)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if token.Payload.Alg != "ES384" {
+		t.Errorf("got alg %s for P-384 key, want ES384", token.Payload.Alg)
+	}
+}
@@ -0,0 +1,123 @@
+package lsvid
+
+import (
+	"fmt"
+	"time"
+)
+
+// SetHopTiming sets the timing claims of a hop payload, right before extending:
+// the time the issuer received the request it delegates (if not zero), and now
+// as the time it forwards the hop.
+func SetHopTiming(payload *Payload, received time.Time) {
+	if !received.IsZero() {
+		payload.Rcv = received.UnixMilli()
+	}
+	payload.Fwd = time.Now().UnixMilli()
+}
+
+// HopLatency is the timing of a hop. Times not recorded by the hop are zero.
+type HopLatency struct {
+	Issuer     string
+	Received   time.Time
+	Forwarded  time.Time
+	Transit    time.Duration // from the previous hop forwarded to this hop received
+	Processing time.Duration // from this hop received to forwarded
+}
+
+// PathLatency is the timing of the path of a chain.
+type PathLatency struct {
+	Hops    []*HopLatency // from the root to the outer most hop
+	Transit time.Duration // from the outer most hop forwarded to received
+	Total   time.Duration // from the first to the last recorded time
+}
+
+// Latency computes the per hop latency and the total path time of the chain, that
+// should be validated first. received is the time the chain was received by the
+// caller, and may be zero. Join hops follow the parent forwarded last (the critical
+// path). Hops are timed by different hosts, so durations include their clock skew.
+func Latency(lsvid *Token, received time.Time) (*PathLatency, error) {
+	var path []*Token
+	for token := lsvid; token != nil; token = latestParent(token) {
+		path = append([]*Token{token}, path...)
+	}
+
+	latency := &PathLatency{}
+	var first, last, prevFwd time.Time
+	record := func(t time.Time) {
+		if t.IsZero() {
+			return
+		}
+		if first.IsZero() {
+			first = t
+		}
+		last = t
+	}
+
+	for _, token := range path {
+		hop := &HopLatency{}
+		if token.Payload != nil {
+			hop.Issuer = idClaimCN(token.Payload.Iss)
+			hop.Received = millisTime(token.Payload.Rcv)
+			hop.Forwarded = millisTime(token.Payload.Fwd)
+		}
+		if !prevFwd.IsZero() && !hop.Received.IsZero() {
+			hop.Transit = hop.Received.Sub(prevFwd)
+		}
+		if !hop.Received.IsZero() && !hop.Forwarded.IsZero() {
+			hop.Processing = hop.Forwarded.Sub(hop.Received)
+		}
+		record(hop.Received)
+		record(hop.Forwarded)
+
+		prevFwd = hop.Forwarded
+		latency.Hops = append(latency.Hops, hop)
+	}
+
+	if !prevFwd.IsZero() && !received.IsZero() {
+		latency.Transit = received.Sub(prevFwd)
+	}
+	record(received)
+
+	if first.Equal(last) {
+		return nil, fmt.Errorf("LSVID has no hop timing claims\n")
+	}
+	latency.Total = last.Sub(first)
+
+	return latency, nil
+}
+
+// String formats the latency, one hop per line.
+func (l *PathLatency) String() string {
+	var s string
+	for _, hop := range l.Hops {
+		issuer := hop.Issuer
+		if issuer == "" {
+			issuer = "(redacted)"
+		}
+		s += fmt.Sprintf("%s: transit %s, processing %s\n", issuer, hop.Transit, hop.Processing)
+	}
+	s += fmt.Sprintf("transit %s, total %s\n", l.Transit, l.Total)
+	return s
+}
+
+// latestParent returns the parent forwarded last, or the first one if none is timed.
+func latestParent(token *Token) *Token {
+	parents := token.Parents()
+	if len(parents) == 0 {
+		return nil
+	}
+	latest := parents[0]
+	for _, parent := range parents[1:] {
+		if parent.Payload != nil && (latest.Payload == nil || parent.Payload.Fwd > latest.Payload.Fwd) {
+			latest = parent
+		}
+	}
+	return latest
+}
+
+func millisTime(ms int64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
@@ -0,0 +1,131 @@
+package lsvid
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// timedHop returns a hop of issuer nesting the parent, received and forwarded at the given ms.
+func timedHop(issuer string, rcv, fwd int64, parent *Token) *Token {
+	return &Token{
+		Nested:  parent,
+		Payload: &Payload{Iss: &IDClaim{CN: issuer}, Rcv: rcv, Fwd: fwd},
+	}
+}
+
+func TestSetHopTiming(t *testing.T) {
+	payload := &Payload{}
+	received := time.Now().Add(-time.Second)
+	SetHopTiming(payload, received)
+	if payload.Rcv != received.UnixMilli() {
+		t.Errorf("got rcv %d, want %d", payload.Rcv, received.UnixMilli())
+	}
+	if payload.Fwd < payload.Rcv || payload.Fwd > time.Now().UnixMilli() {
+		t.Errorf("got fwd %d, want now", payload.Fwd)
+	}
+
+	payload = &Payload{}
+	SetHopTiming(payload, time.Time{})
+	if payload.Rcv != 0 || payload.Fwd == 0 {
+		t.Errorf("got rcv %d and fwd %d, want only fwd", payload.Rcv, payload.Fwd)
+	}
+}
+
+func TestLatency(t *testing.T) {
+	root := &Token{Payload: &Payload{Sub: &IDClaim{CN: "spiffe://example.org/a"}}}
+	a := timedHop("spiffe://example.org/a", 0, 1000, root)
+	b := timedHop("spiffe://example.org/b", 1010, 1100, a)
+	c := timedHop("spiffe://example.org/c", 1130, 1400, b)
+
+	latency, err := Latency(c, time.UnixMilli(1450))
+	if err != nil {
+		t.Fatalf("Latency: %v", err)
+	}
+	if len(latency.Hops) != 4 {
+		t.Fatalf("got %d hops, want 4", len(latency.Hops))
+	}
+	want := []HopLatency{
+		{Issuer: "-"},
+		{Issuer: "spiffe://example.org/a"},
+		{Issuer: "spiffe://example.org/b", Transit: 10 * time.Millisecond, Processing: 90 * time.Millisecond},
+		{Issuer: "spiffe://example.org/c", Transit: 30 * time.Millisecond, Processing: 270 * time.Millisecond},
+	}
+	for i, hop := range latency.Hops {
+		if hop.Issuer != want[i].Issuer || hop.Transit != want[i].Transit || hop.Processing != want[i].Processing {
+			t.Errorf("hop %d: got %+v, want %+v", i, *hop, want[i])
+		}
+	}
+	if latency.Transit != 50*time.Millisecond {
+		t.Errorf("got transit %s, want 50ms", latency.Transit)
+	}
+	if latency.Total != 450*time.Millisecond {
+		t.Errorf("got total %s, want 450ms", latency.Total)
+	}
+
+	// Without the receiving time, the path ends at the last hop forwarded
+	latency, err = Latency(c, time.Time{})
+	if err != nil {
+		t.Fatalf("Latency: %v", err)
+	}
+	if latency.Transit != 0 || latency.Total != 400*time.Millisecond {
+		t.Errorf("got transit %s and total %s, want 0s and 400ms", latency.Transit, latency.Total)
+	}
+}
+
+// Join hops follow the parent forwarded last.
+func TestLatencyJoin(t *testing.T) {
+	root := &Token{Payload: &Payload{}}
+	fast := timedHop("spiffe://example.org/fast", 0, 1000, root)
+	slow := timedHop("spiffe://example.org/slow", 0, 1200, root)
+	join := &Token{
+		Joined:  []*Token{fast, slow},
+		Payload: &Payload{Iss: &IDClaim{CN: "spiffe://example.org/join"}, Rcv: 1250, Fwd: 1300},
+	}
+
+	latency, err := Latency(join, time.Time{})
+	if err != nil {
+		t.Fatalf("Latency: %v", err)
+	}
+	if len(latency.Hops) != 3 || latency.Hops[1].Issuer != "spiffe://example.org/slow" {
+		t.Fatalf("critical path does not follow the slow parent: %s", latency)
+	}
+	if latency.Hops[2].Transit != 50*time.Millisecond {
+		t.Errorf("got join transit %s, want 50ms", latency.Hops[2].Transit)
+	}
+	if latency.Total != 100*time.Millisecond {
+		t.Errorf("got total %s, want 100ms", latency.Total)
+	}
+}
+
+func TestLatencyUntimed(t *testing.T) {
+	root := &Token{Payload: &Payload{}}
+	hop := timedHop("spiffe://example.org/a", 0, 0, root)
+	if _, err := Latency(hop, time.Time{}); err == nil {
+		t.Error("latency of untimed LSVID computed")
+	}
+	// A single time is not a path either
+	if _, err := Latency(hop, time.Now()); err == nil {
+		t.Error("latency of LSVID with a single time computed")
+	}
+}
+
+func TestPathLatencyString(t *testing.T) {
+	latency := &PathLatency{
+		Hops: []*HopLatency{
+			{},
+			{Issuer: "spiffe://example.org/a", Transit: time.Millisecond, Processing: 2 * time.Millisecond},
+		},
+		Transit: 3 * time.Millisecond,
+		Total:   6 * time.Millisecond,
+	}
+	want := "(redacted): transit 0s, processing 0s\n" +
+		"spiffe://example.org/a: transit 1ms, processing 2ms\n" +
+		"transit 3ms, total 6ms\n"
+	if s := latency.String(); s != want {
+		t.Errorf("got\n%s\nwant\n%s", s, want)
+	}
+	if !strings.HasSuffix(latency.String(), "\n") {
+		t.Error("missing new line")
+	}
+}
@@ -0,0 +1,164 @@
+package lsvid
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// TraceparentHeader is the W3C Trace Context header (https://www.w3.org/TR/trace-context/).
+const TraceparentHeader = "traceparent"
+
+// TraceContext ties a hop to the OpenTelemetry span of the request it is sent with.
+// The span ID is the one of the sender, sent as parent-id in the traceparent header.
+type TraceContext struct {
+	Tid string `json:"tid"`           // trace ID, 32 lowercase hex digits
+	Sid string `json:"sid"`           // span ID, 16 lowercase hex digits
+	Flg uint8  `json:"flg,omitempty"` // trace flags (e.g.: 1, sampled)
+}
+
+type traceContextKey struct{}
+
+// ParseTraceparent parses a version 00 traceparent header value.
+func ParseTraceparent(header string) (*TraceContext, error) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return nil, fmt.Errorf("Invalid traceparent %q\n", header)
+	}
+	if !isTraceID(parts[1], 32) || !isTraceID(parts[2], 16) {
+		return nil, fmt.Errorf("Invalid traceparent %q\n", header)
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || len(flags) != 1 || parts[3] != strings.ToLower(parts[3]) {
+		return nil, fmt.Errorf("Invalid traceparent %q\n", header)
+	}
+
+	return &TraceContext{
+		Tid: parts[1],
+		Sid: parts[2],
+		Flg: flags[0],
+	}, nil
+}
+
+// Traceparent formats the trace context as a version 00 traceparent header value.
+func (tc *TraceContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", tc.Tid, tc.Sid, tc.Flg)
+}
+
+// TraceFromRequest returns the trace context of the received request.
+func TraceFromRequest(r *http.Request) (*TraceContext, error) {
+	header := r.Header.Get(TraceparentHeader)
+	if header == "" {
+		return nil, fmt.Errorf("Request has no trace context\n")
+	}
+	return ParseTraceparent(header)
+}
+
+// TraceFromContext returns the trace context verified by TraceMiddleware.
+func TraceFromContext(ctx context.Context) (*TraceContext, bool) {
+	tc, ok := ctx.Value(traceContextKey{}).(*TraceContext)
+	return tc, ok
+}
+
+// InjectTrace starts a child span of parent (or a new trace, if parent is nil) for the
+// outgoing request, setting it in the hop payload before extending, and in the request
+// traceparent header.
+func InjectTrace(payload *Payload, r *http.Request, parent *TraceContext) (*TraceContext, error) {
+	span := make([]byte, 8)
+	if _, err := rand.Read(span); err != nil {
+		return nil, fmt.Errorf("Error generating span ID: %v\n", err)
+	}
+	tc := &TraceContext{
+		Sid: hex.EncodeToString(span),
+		Flg: 1,
+	}
+	if parent != nil {
+		tc.Tid, tc.Flg = parent.Tid, parent.Flg
+	} else {
+		trace := make([]byte, 16)
+		if _, err := rand.Read(trace); err != nil {
+			return nil, fmt.Errorf("Error generating trace ID: %v\n", err)
+		}
+		tc.Tid = hex.EncodeToString(trace)
+	}
+
+	payload.Trc = tc
+	r.Header.Set(TraceparentHeader, tc.Traceparent())
+
+	return tc, nil
+}
+
+// VerifyTrace checks that the outermost hop of the LSVID carries the trace context of
+// the received request, and that the traced hops of the chain belong to the same trace.
+func VerifyTrace(token *Token, r *http.Request) (*TraceContext, error) {
+	if token == nil || token.Payload == nil || token.Payload.Trc == nil {
+		return nil, fmt.Errorf("LSVID has no trace context\n")
+	}
+	received, err := TraceFromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	hop := token.Payload.Trc
+	if hop.Tid != received.Tid || hop.Sid != received.Sid {
+		return nil, fmt.Errorf("Request trace context does not match the LSVID (%s)\n", hop.Traceparent())
+	}
+
+	err = Walk(token, func(t *Token, _ int) error {
+		if t.Payload != nil && t.Payload.Trc != nil && t.Payload.Trc.Tid != hop.Tid {
+			return fmt.Errorf("Hop by %s belongs to trace %s\n", idClaimCN(t.Payload.Iss), t.Payload.Trc.Tid)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return received, nil
+}
+
+// TraceMiddleware verifies the trace context of the requests before calling next, that
+// gets it with TraceFromContext. extract returns the LSVID sent with the request, unless
+// an outer middleware already extracted it; the request body is restored for next.
+func TraceMiddleware(extract func(r *http.Request) (*LSVID, error), next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		restore, ok := bufferBody(w, r)
+		if !ok {
+			return
+		}
+
+		lsvid, err := extractLSVID(r, extract)
+		if err != nil {
+			log.Printf("Error extracting LSVID: %v", err)
+			http.Error(w, "invalid LSVID", http.StatusUnauthorized)
+			return
+		}
+		restore()
+
+		tc, err := VerifyTrace(lsvid.Token, r)
+		if err != nil {
+			log.Printf("Error verifying trace context: %v", err)
+			http.Error(w, "invalid trace context", http.StatusUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), lsvidContextKey{}, lsvid)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, traceContextKey{}, tc)))
+	})
+}
+
+// isTraceID checks a lowercase hex ID of n digits, that is not all zeros.
+func isTraceID(id string, n int) bool {
+	if len(id) != n || id != strings.ToLower(id) {
+		return false
+	}
+	b, err := hex.DecodeString(id)
+	if err != nil {
+		return false
+	}
+	return !bytes.Equal(b, make([]byte, len(b)))
+}
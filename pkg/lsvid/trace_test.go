@@ -0,0 +1,206 @@
+package lsvid
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+)
+
+const testTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestParseTraceparent(t *testing.T) {
+	tc, err := ParseTraceparent(testTraceparent)
+	if err != nil {
+		t.Fatalf("ParseTraceparent: %v", err)
+	}
+	if tc.Tid != "4bf92f3577b34da6a3ce929d0e0e4736" || tc.Sid != "00f067aa0ba902b7" || tc.Flg != 1 {
+		t.Errorf("got %+v", tc)
+	}
+	if tc.Traceparent() != testTraceparent {
+		t.Errorf("got traceparent %s, want %s", tc.Traceparent(), testTraceparent)
+	}
+
+	// Later versions may add fields
+	if _, err := ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"); err != nil {
+		t.Errorf("traceparent of later version rejected: %v", err)
+	}
+
+	for _, header := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"0-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473g-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-1",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0A",
+	} {
+		if _, err := ParseTraceparent(header); err == nil {
+			t.Errorf("invalid traceparent %q parsed", header)
+		}
+	}
+}
+
+func TestInjectTrace(t *testing.T) {
+	parent, err := ParseTraceparent(testTraceparent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := &Payload{}
+	r := httptest.NewRequest(http.MethodPost, "https://target-wl/deposit", nil)
+	tc, err := InjectTrace(payload, r, parent)
+	if err != nil {
+		t.Fatalf("InjectTrace: %v", err)
+	}
+	if tc.Tid != parent.Tid || tc.Sid == parent.Sid || tc.Flg != parent.Flg {
+		t.Errorf("got %+v, want a child span of %+v", tc, parent)
+	}
+	if payload.Trc != tc || r.Header.Get(TraceparentHeader) != tc.Traceparent() {
+		t.Error("trace context not set in payload and request")
+	}
+
+	// Without parent, a new trace is started
+	tc, err = InjectTrace(&Payload{}, r, nil)
+	if err != nil {
+		t.Fatalf("InjectTrace: %v", err)
+	}
+	if _, err := ParseTraceparent(tc.Traceparent()); err != nil || tc.Tid == parent.Tid {
+		t.Errorf("got invalid new trace %+v: %v", tc, err)
+	}
+}
+
+// tracedChain returns a traced chain and the request sent with its outermost hop.
+func tracedChain(t *testing.T, edit func(hop int, payload *Payload)) (*LSVID, *http.Request) {
+	ca := newTestCA(t, "example.org")
+	svids := []*x509svid.SVID{ca.newSVID(t, "/a"), ca.newSVID(t, "/b"), ca.newSVID(t, "/c")}
+	r := httptest.NewRequest(http.MethodPost, "https://c/deposit", strings.NewReader("deposit=10"))
+	var parent *TraceContext
+	lsvid := ca.newChain(t, svids, func(hop int, payload *Payload) {
+		tc, err := InjectTrace(payload, r, parent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parent = tc
+		if edit != nil {
+			edit(hop, payload)
+		}
+	})
+	return lsvid, r
+}
+
+func TestVerifyTrace(t *testing.T) {
+	lsvid, r := tracedChain(t, nil)
+	tc, err := VerifyTrace(lsvid.Token, r)
+	if err != nil {
+		t.Fatalf("VerifyTrace: %v", err)
+	}
+	if tc.Tid != lsvid.Token.Payload.Trc.Tid || tc.Sid != lsvid.Token.Payload.Trc.Sid {
+		t.Errorf("got %+v, want the outermost hop trace context", tc)
+	}
+
+	t.Run("other span", func(t *testing.T) {
+		other := r.Clone(r.Context())
+		other.Header.Set(TraceparentHeader, "00-"+tc.Tid+"-00f067aa0ba902b7-01")
+		if _, err := VerifyTrace(lsvid.Token, other); err == nil {
+			t.Error("request of another span verified")
+		}
+	})
+	t.Run("other trace", func(t *testing.T) {
+		other := r.Clone(r.Context())
+		other.Header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+tc.Sid+"-01")
+		if _, err := VerifyTrace(lsvid.Token, other); err == nil {
+			t.Error("request of another trace verified")
+		}
+	})
+	t.Run("no header", func(t *testing.T) {
+		other := r.Clone(r.Context())
+		other.Header.Del(TraceparentHeader)
+		if _, err := VerifyTrace(lsvid.Token, other); err == nil {
+			t.Error("request without trace context verified")
+		}
+	})
+	t.Run("untraced", func(t *testing.T) {
+		ca := newTestCA(t, "example.org")
+		untraced := ca.newChain(t, []*x509svid.SVID{ca.newSVID(t, "/a"), ca.newSVID(t, "/b")}, nil)
+		if _, err := VerifyTrace(untraced.Token, r); err == nil {
+			t.Error("LSVID without trace context verified")
+		}
+	})
+	t.Run("hop of another trace", func(t *testing.T) {
+		mixed, r := tracedChain(t, func(hop int, payload *Payload) {
+			if hop == 0 {
+				payload.Trc = &TraceContext{Tid: "4bf92f3577b34da6a3ce929d0e0e4736", Sid: payload.Trc.Sid}
+			}
+		})
+		if _, err := VerifyTrace(mixed.Token, r); err == nil {
+			t.Error("LSVID with a hop of another trace verified")
+		}
+	})
+}
+
+func TestTraceMiddleware(t *testing.T) {
+	lsvid, r := tracedChain(t, nil)
+	extracted := 0
+	extract := func(r *http.Request) (*LSVID, error) {
+		extracted++
+		// The extractor reads the body, as ReceivedLSVID does
+		if _, err := io.ReadAll(r.Body); err != nil {
+			return nil, err
+		}
+		return lsvid, nil
+	}
+
+	var called bool
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		body, err := io.ReadAll(r.Body)
+		if err != nil || string(body) != "deposit=10" {
+			http.Error(w, "body not restored", http.StatusInternalServerError)
+			return
+		}
+		if tc, ok := TraceFromContext(r.Context()); !ok || tc.Sid != lsvid.Token.Payload.Trc.Sid {
+			http.Error(w, "trace context not set", http.StatusInternalServerError)
+		}
+		if received, ok := LSVIDFromContext(r.Context()); !ok || received != lsvid {
+			http.Error(w, "LSVID not set", http.StatusInternalServerError)
+		}
+	})
+
+	w := httptest.NewRecorder()
+	TraceMiddleware(extract, next).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+
+	// The LSVID extracted by an outer middleware is not extracted again
+	extracted = 0
+	stacked := httptest.NewRequest(http.MethodPost, r.URL.String(), strings.NewReader("deposit=10"))
+	stacked.Header = r.Header.Clone()
+	stacked = stacked.WithContext(context.WithValue(stacked.Context(), lsvidContextKey{}, lsvid))
+	w = httptest.NewRecorder()
+	TraceMiddleware(extract, next).ServeHTTP(w, stacked)
+	if w.Code != http.StatusOK || extracted != 0 {
+		t.Errorf("got status %d and %d extractions: %s", w.Code, extracted, w.Body)
+	}
+
+	// Requests of another span do not reach next
+	called = false
+	other := httptest.NewRequest(http.MethodPost, "https://c/deposit", strings.NewReader("deposit=10"))
+	other.Header.Set(TraceparentHeader, testTraceparent)
+	w = httptest.NewRecorder()
+	TraceMiddleware(extract, next).ServeHTTP(w, other)
+	if w.Code != http.StatusUnauthorized || called {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
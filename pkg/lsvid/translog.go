@@ -0,0 +1,419 @@
+package lsvid
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	hash256 "crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// TreeHead is the claim of a signed tree head: the root hash of the
+// transparency log Merkle tree (RFC 6962) with its first Size entries.
+type TreeHead struct {
+	Size uint64 `json:"size"`
+	Root []byte `json:"root"`
+}
+
+// InclusionProof proves that a leaf is entry Index of the log tree of size Size.
+// LSVIDs carry the proofs of their logged hops (LSVID.Inclusions), with the
+// digest of the hop token as leaf.
+type InclusionProof struct {
+	Leaf   []byte   `json:"leaf"`
+	Index  uint64   `json:"index"`
+	Size   uint64   `json:"size"`
+	Hashes [][]byte `json:"hashes"`
+}
+
+// TransparencyLog is an append-only log of the issued tokens, kept in a Merkle tree.
+// Entries are persisted (hex encoded, one per line) if the log was opened with a path.
+type TransparencyLog struct {
+	mtx     sync.Mutex
+	path    string
+	entries [][]byte
+	leaves  [][]byte // leaf hashes of the entries
+}
+
+// NewTransparencyLog creates an empty in-memory transparency log.
+func NewTransparencyLog() *TransparencyLog {
+	return &TransparencyLog{}
+}
+
+// OpenTransparencyLog opens the transparency log persisted at path, creating it if it does not exist.
+func OpenTransparencyLog(path string) (*TransparencyLog, error) {
+	tlog := &TransparencyLog{path: path}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return tlog, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error opening transparency log: %v\n", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		entry, err := hex.DecodeString(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("Invalid transparency log entry %d: %v\n", len(tlog.entries), err)
+		}
+		tlog.entries = append(tlog.entries, entry)
+		tlog.leaves = append(tlog.leaves, leafHash(entry))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Error reading transparency log: %v\n", err)
+	}
+
+	return tlog, nil
+}
+
+// Append appends an entry to the log, returning its index.
+func (l *TransparencyLog) Append(entry []byte) (uint64, error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	return l.append(entry)
+}
+
+// AppendToken logs the outermost hop of the LSVID, and adds its inclusion
+// proof in the current tree to the LSVID (LSVID.Inclusions).
+func (l *TransparencyLog) AppendToken(lsvid *LSVID) error {
+	leaf, err := tokenDigest(lsvid.Token)
+	if err != nil {
+		return err
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	index, err := l.append(leaf)
+	if err != nil {
+		return err
+	}
+	lsvid.Inclusions = append(lsvid.Inclusions, &InclusionProof{
+		Leaf:   leaf,
+		Index:  index,
+		Size:   index + 1,
+		Hashes: inclusionPath(index, l.leaves),
+	})
+	return nil
+}
+
+func (l *TransparencyLog) append(entry []byte) (uint64, error) {
+	if l.path != "" {
+		file, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return 0, fmt.Errorf("Error opening transparency log: %v\n", err)
+		}
+		_, err = fmt.Fprintln(file, hex.EncodeToString(entry))
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return 0, fmt.Errorf("Error writing transparency log: %v\n", err)
+		}
+	}
+
+	l.entries = append(l.entries, entry)
+	l.leaves = append(l.leaves, leafHash(entry))
+	return uint64(len(l.leaves) - 1), nil
+}
+
+// Size returns the number of entries in the log.
+func (l *TransparencyLog) Size() uint64 {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	return uint64(len(l.leaves))
+}
+
+// Entry returns the entry at the given index.
+func (l *TransparencyLog) Entry(index uint64) ([]byte, error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if index >= uint64(len(l.entries)) {
+		return nil, fmt.Errorf("Entry %d not in log of size %d\n", index, len(l.entries))
+	}
+	return l.entries[index], nil
+}
+
+// TreeHead returns the tree head of the first size entries.
+func (l *TransparencyLog) TreeHead(size uint64) (*TreeHead, error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if size > uint64(len(l.leaves)) {
+		return nil, fmt.Errorf("Tree size %d greater than log size %d\n", size, len(l.leaves))
+	}
+	return &TreeHead{
+		Size: size,
+		Root: merkleRoot(l.leaves[:size]),
+	}, nil
+}
+
+// SignTreeHead creates the signed tree head of the whole log, issued by the log workload.
+func (l *TransparencyLog) SignTreeHead(issuer *IDClaim, key crypto.Signer) (*Token, error) {
+	head, err := l.TreeHead(l.Size())
+	if err != nil {
+		return nil, err
+	}
+
+	alg, err := keyAlgorithm(key.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	payload := &Payload{
+		Ver: 1,
+		Alg: alg,
+		Iat: time.Now().Round(0).Unix(),
+		Iss: issuer,
+		Sth: head,
+	}
+	return signRoot(payload, key)
+}
+
+// InclusionProof returns the proof of inclusion of entry index in the tree of the given size.
+func (l *TransparencyLog) InclusionProof(index, size uint64) (*InclusionProof, error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if size > uint64(len(l.leaves)) || index >= size {
+		return nil, fmt.Errorf("Entry %d not in tree of size %d\n", index, size)
+	}
+	return &InclusionProof{
+		Leaf:   l.entries[index],
+		Index:  index,
+		Size:   size,
+		Hashes: inclusionPath(index, l.leaves[:size]),
+	}, nil
+}
+
+// ConsistencyProof returns the proof that the tree of size first is a prefix of the tree of size second.
+func (l *TransparencyLog) ConsistencyProof(first, second uint64) ([][]byte, error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if first == 0 || first > second || second > uint64(len(l.leaves)) {
+		return nil, fmt.Errorf("Invalid tree sizes %d and %d\n", first, second)
+	}
+	return consistencyPath(first, l.leaves[:second], true), nil
+}
+
+// VerifyTreeHead checks that the signed tree head was issued by the given log workload.
+// The issuer LSVID is validated against the bundle source (WithBundleSource), that is required.
+func VerifyTreeHead(sth *Token, issuer string, opts ...ValidateOption) (*TreeHead, error) {
+	config := &validateConfig{}
+	for _, opt := range opts {
+		opt.apply(config)
+	}
+
+	if sth == nil || sth.Payload == nil || sth.Payload.Sth == nil || sth.Nested != nil || len(sth.Joined) > 0 {
+		return nil, fmt.Errorf("Invalid signed tree head\n")
+	}
+	if err := verifyIssuedToken(sth, issuer, config); err != nil {
+		return nil, fmt.Errorf("Signed tree head validation failed: %v\n", err)
+	}
+	return sth.Payload.Sth, nil
+}
+
+// VerifyInclusion checks the proof of inclusion of its leaf in the tree head.
+func (h *TreeHead) VerifyInclusion(proof *InclusionProof) error {
+	if proof.Size != h.Size || proof.Index >= proof.Size {
+		return fmt.Errorf("Inclusion proof for tree size %d, not %d\n", proof.Size, h.Size)
+	}
+	root, err := rootFromPath(proof.Index, proof.Size, leafHash(proof.Leaf), proof.Hashes)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(root, h.Root) {
+		return fmt.Errorf("Inclusion proof does not match tree head\n")
+	}
+	return nil
+}
+
+// VerifyConsistency checks that the tree head old is a prefix of the tree head h.
+func (h *TreeHead) VerifyConsistency(old *TreeHead, proof [][]byte) error {
+	if old.Size == 0 || old.Size > h.Size {
+		return fmt.Errorf("Invalid tree sizes %d and %d\n", old.Size, h.Size)
+	}
+	if old.Size == h.Size {
+		if len(proof) != 0 || !bytes.Equal(old.Root, h.Root) {
+			return fmt.Errorf("Tree heads of size %d differ\n", h.Size)
+		}
+		return nil
+	}
+
+	oldRoot, newRoot, err := rootsFromConsistency(old.Size, h.Size, proof, true, old.Root)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(oldRoot, old.Root) || !bytes.Equal(newRoot, h.Root) {
+		return fmt.Errorf("Consistency proof does not match tree heads\n")
+	}
+	return nil
+}
+
+// VerifyInclusions checks that the inclusion proofs carried by the LSVID refer to
+// hops of its chain, and that they verify against the trusted tree head. Proofs
+// issued for older trees must be refreshed (InclusionProof) to the head size.
+func VerifyInclusions(lsvid *LSVID, head *TreeHead) error {
+	if len(lsvid.Inclusions) == 0 {
+		return fmt.Errorf("LSVID has no inclusion proofs\n")
+	}
+
+	digests := make(map[string]bool)
+	err := Walk(lsvid.Token, func(token *Token, _ int) error {
+		digest, err := tokenDigest(token)
+		if err != nil {
+			return err
+		}
+		digests[string(digest)] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, proof := range lsvid.Inclusions {
+		if !digests[string(proof.Leaf)] {
+			return fmt.Errorf("Inclusion proof of entry %d does not refer to a hop\n", proof.Index)
+		}
+		if err := head.VerifyInclusion(proof); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Merkle tree hashes, as defined in RFC 6962, Section 2.1.
+
+func leafHash(entry []byte) []byte {
+	h := hash256.New()
+	h.Write([]byte{0})
+	h.Write(entry)
+	return h.Sum(nil)
+}
+
+func nodeHash(left, right []byte) []byte {
+	h := hash256.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// splitSize returns the largest power of two smaller than n.
+func splitSize(n uint64) uint64 {
+	k := uint64(1)
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+func merkleRoot(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		h := hash256.Sum256(nil)
+		return h[:]
+	case 1:
+		return leaves[0]
+	}
+	k := splitSize(uint64(len(leaves)))
+	return nodeHash(merkleRoot(leaves[:k]), merkleRoot(leaves[k:]))
+}
+
+func inclusionPath(index uint64, leaves [][]byte) [][]byte {
+	n := uint64(len(leaves))
+	if n <= 1 {
+		return nil
+	}
+	k := splitSize(n)
+	if index < k {
+		return append(inclusionPath(index, leaves[:k]), merkleRoot(leaves[k:]))
+	}
+	return append(inclusionPath(index-k, leaves[k:]), merkleRoot(leaves[:k]))
+}
+
+func consistencyPath(m uint64, leaves [][]byte, complete bool) [][]byte {
+	n := uint64(len(leaves))
+	if m == n {
+		if complete {
+			return nil
+		}
+		return [][]byte{merkleRoot(leaves)}
+	}
+	k := splitSize(n)
+	if m <= k {
+		return append(consistencyPath(m, leaves[:k], complete), merkleRoot(leaves[k:]))
+	}
+	return append(consistencyPath(m-k, leaves[k:], false), merkleRoot(leaves[:k]))
+}
+
+// rootFromPath computes the tree root from a leaf hash and its inclusion path.
+func rootFromPath(index, size uint64, leaf []byte, path [][]byte) ([]byte, error) {
+	if size == 1 {
+		if len(path) != 0 {
+			return nil, fmt.Errorf("Invalid inclusion proof length\n")
+		}
+		return leaf, nil
+	}
+	if len(path) == 0 {
+		return nil, fmt.Errorf("Invalid inclusion proof length\n")
+	}
+	k := splitSize(size)
+	sibling, rest := path[len(path)-1], path[:len(path)-1]
+	if index < k {
+		left, err := rootFromPath(index, k, leaf, rest)
+		if err != nil {
+			return nil, err
+		}
+		return nodeHash(left, sibling), nil
+	}
+	right, err := rootFromPath(index-k, size-k, leaf, rest)
+	if err != nil {
+		return nil, err
+	}
+	return nodeHash(sibling, right), nil
+}
+
+// rootsFromConsistency computes the old and new tree roots from a consistency proof.
+func rootsFromConsistency(m, n uint64, proof [][]byte, complete bool, oldRoot []byte) ([]byte, []byte, error) {
+	if m == n {
+		if complete {
+			if len(proof) != 0 {
+				return nil, nil, fmt.Errorf("Invalid consistency proof length\n")
+			}
+			return oldRoot, oldRoot, nil
+		}
+		if len(proof) != 1 {
+			return nil, nil, fmt.Errorf("Invalid consistency proof length\n")
+		}
+		return proof[0], proof[0], nil
+	}
+	if len(proof) == 0 {
+		return nil, nil, fmt.Errorf("Invalid consistency proof length\n")
+	}
+	k := splitSize(n)
+	sibling, rest := proof[len(proof)-1], proof[:len(proof)-1]
+	if m <= k {
+		oldHash, newHash, err := rootsFromConsistency(m, k, rest, complete, oldRoot)
+		if err != nil {
+			return nil, nil, err
+		}
+		return oldHash, nodeHash(newHash, sibling), nil
+	}
+	oldHash, newHash, err := rootsFromConsistency(m-k, n-k, rest, false, oldRoot)
+	if err != nil {
+		return nil, nil, err
+	}
+	return nodeHash(sibling, oldHash), nodeHash(sibling, newHash), nil
+}
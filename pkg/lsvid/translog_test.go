@@ -0,0 +1,213 @@
+package lsvid
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/hex"
+	"path/filepath"
+	"testing"
+
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+)
+
+// RFC 6962 test vectors, as used by Certificate Transparency: the leaves and
+// the tree roots of the first 1 to 8 leaves.
+var testLogEntries = []string{"", "00", "10", "2021", "3031", "40414243", "5051525354555657", "606162636465666768696a6b6c6d6e6f"}
+
+var testLogRoots = []string{
+	"6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+	"fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+	"aeb6bcfe274b70a14fb067a5e5578264db0fa9b51af5e0ba159158f329e06e77",
+	"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+	"4e3bbb1f7b478dcfe71fb631631519a3bca12c9aefca1612bfce4c13a86264d4",
+	"76e67dadbcdf1e10e1b74ddc608abd2f98dfb16fbce75277b5232a127f2087ef",
+	"ddb89be403809e325750d3d263cd78929c2942b7942a34b77e122c9594a74c8c",
+	"5dc9da79a70659a9ad559cb701ded9a2ab9d823aad2f4960cfe370eff4604328",
+}
+
+func newTestLog(t *testing.T, path string) *TransparencyLog {
+	tlog, err := OpenTransparencyLog(path)
+	if err != nil {
+		t.Fatalf("OpenTransparencyLog: %v", err)
+	}
+	for _, e := range testLogEntries {
+		entry, err := hex.DecodeString(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tlog.Append(entry); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	return tlog
+}
+
+func TestTransparencyLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transparency.log")
+	tlog := newTestLog(t, path)
+	for size, want := range testLogRoots {
+		head, err := tlog.TreeHead(uint64(size + 1))
+		if err != nil {
+			t.Fatalf("TreeHead: %v", err)
+		}
+		if root := hex.EncodeToString(head.Root); root != want {
+			t.Errorf("got root %s of size %d, want %s", root, size+1, want)
+		}
+	}
+	if _, err := tlog.TreeHead(9); err == nil {
+		t.Error("tree head beyond log size")
+	}
+
+	// the entries are persisted
+	reopened, err := OpenTransparencyLog(path)
+	if err != nil {
+		t.Fatalf("OpenTransparencyLog: %v", err)
+	}
+	if reopened.Size() != 8 {
+		t.Fatalf("got %d entries after reopen, want 8", reopened.Size())
+	}
+	head, err := reopened.TreeHead(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(head.Root) != testLogRoots[7] {
+		t.Error("root changed after reopen")
+	}
+	if entry, err := reopened.Entry(3); err != nil || hex.EncodeToString(entry) != testLogEntries[3] {
+		t.Errorf("Entry: %x, %v", entry, err)
+	}
+}
+
+func TestLogProofs(t *testing.T) {
+	tlog := newTestLog(t, "")
+	for size := uint64(1); size <= 8; size++ {
+		head, err := tlog.TreeHead(size)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for index := uint64(0); index < size; index++ {
+			proof, err := tlog.InclusionProof(index, size)
+			if err != nil {
+				t.Fatalf("InclusionProof(%d, %d): %v", index, size, err)
+			}
+			if err := head.VerifyInclusion(proof); err != nil {
+				t.Errorf("inclusion of %d in %d: %v", index, size, err)
+			}
+			tampered := *proof
+			tampered.Leaf = append([]byte{0xff}, proof.Leaf...)
+			if err := head.VerifyInclusion(&tampered); err == nil {
+				t.Errorf("inclusion of tampered leaf %d in %d verified", index, size)
+			}
+			if len(proof.Hashes) > 0 {
+				tampered = *proof
+				tampered.Hashes = append([][]byte{bytes.Repeat([]byte{0xff}, 32)}, proof.Hashes[1:]...)
+				if err := head.VerifyInclusion(&tampered); err == nil {
+					t.Errorf("tampered inclusion path of %d in %d verified", index, size)
+				}
+			}
+		}
+
+		for old := uint64(1); old <= size; old++ {
+			oldHead, err := tlog.TreeHead(old)
+			if err != nil {
+				t.Fatal(err)
+			}
+			proof, err := tlog.ConsistencyProof(old, size)
+			if err != nil {
+				t.Fatalf("ConsistencyProof(%d, %d): %v", old, size, err)
+			}
+			if err := head.VerifyConsistency(oldHead, proof); err != nil {
+				t.Errorf("consistency of %d and %d: %v", old, size, err)
+			}
+			forged := &TreeHead{Size: old, Root: bytes.Repeat([]byte{0xff}, 32)}
+			if err := head.VerifyConsistency(forged, proof); err == nil {
+				t.Errorf("consistency of forged tree %d and %d verified", old, size)
+			}
+		}
+	}
+
+	if _, err := tlog.ConsistencyProof(5, 4); err == nil {
+		t.Error("consistency proof of a shrinking tree")
+	}
+}
+
+func TestSignedTreeHead(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	asserting := ca.newSVID(t, "/asserting-wl")
+	middle := ca.newSVID(t, "/middle-tier")
+	target := ca.newSVID(t, "/target-wl")
+	trustBundle := newTestTrustBundle(t, ca)
+
+	// the asserting workload logs its hop, and the middle tier extends it
+	tlog := newTestLog(t, "")
+	lsvid := ca.newChain(t, []*x509svid.SVID{asserting, middle}, nil)
+	if err := tlog.AppendToken(lsvid); err != nil {
+		t.Fatalf("AppendToken: %v", err)
+	}
+	lsvid = extend(t, lsvid, ca.newPayload(t, middle, target.ID.String()), middle)
+	if len(lsvid.Inclusions) != 1 || lsvid.Inclusions[0].Index != 8 {
+		t.Fatalf("unexpected inclusions %+v", lsvid.Inclusions)
+	}
+
+	sth, err := tlog.SignTreeHead(&IDClaim{CN: asserting.ID.String(), ID: ca.newLSVID(t, asserting)}, asserting.PrivateKey)
+	if err != nil {
+		t.Fatalf("SignTreeHead: %v", err)
+	}
+	head, err := VerifyTreeHead(sth, asserting.ID.String(), WithBundleSource(trustBundle))
+	if err != nil {
+		t.Fatalf("VerifyTreeHead: %v", err)
+	}
+	if err := VerifyInclusions(lsvid, head); err != nil {
+		t.Errorf("VerifyInclusions: %v", err)
+	}
+
+	// tree heads are only trusted from the log workload
+	if _, err := VerifyTreeHead(sth, asserting.ID.String()); err == nil {
+		t.Error("tree head verified without bundle source")
+	}
+	if _, err := VerifyTreeHead(sth, middle.ID.String(), WithBundleSource(trustBundle)); err == nil {
+		t.Error("tree head verified for another issuer")
+	}
+	attacker := newTestCA(t, "example.org")
+	impostor := attacker.newSVID(t, "/asserting-wl")
+	forged, err := tlog.SignTreeHead(&IDClaim{CN: impostor.ID.String(), ID: attacker.newLSVID(t, impostor)}, impostor.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyTreeHead(forged, asserting.ID.String(), WithBundleSource(trustBundle)); err == nil {
+		t.Error("tree head with self-made issuer LSVID verified")
+	}
+	sth.Payload.Sth.Size--
+	if _, err := VerifyTreeHead(sth, asserting.ID.String(), WithBundleSource(trustBundle)); err == nil {
+		t.Error("tampered tree head verified")
+	}
+
+	// proofs must refer to a hop of the chain
+	other := ca.newChain(t, []*x509svid.SVID{asserting, target}, nil)
+	other.Inclusions = lsvid.Inclusions
+	if err := VerifyInclusions(other, head); err == nil {
+		t.Error("inclusion proof of another LSVID verified")
+	}
+	if err := VerifyInclusions(ca.newChain(t, []*x509svid.SVID{asserting, target}, nil), head); err == nil {
+		t.Error("LSVID without inclusion proofs verified")
+	}
+}
+
+// The tree head alg follows the key of the log workload.
+func TestSignTreeHeadAlg(t *testing.T) {
+	tlog := newTestLog(t, filepath.Join(t.TempDir(), "transparency.log"))
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sth, err := tlog.SignTreeHead(&IDClaim{CN: "spiffe://example.org/asserting-wl"}, key)
+	if err != nil {
+		t.Fatalf("SignTreeHead: %v", err)
+	}
+	if sth.Payload.Alg != "ES384" {
+		t.Errorf("got alg %s for P-384 key, want ES384", sth.Payload.Alg)
+	}
+}
@@ -0,0 +1,195 @@
+package lsvid
+
+import (
+	"crypto"
+	"fmt"
+	"sync"
+
+	"github.com/spiffe/go-spiffe/v2/bundle/spiffebundle"
+	"github.com/spiffe/go-spiffe/v2/bundle/x509bundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+)
+
+// TrustDomainPolicy authorizes the trust domains of an LSVID chain.
+// The path holds the trust domain of each issuer, from the root to the outer most hop.
+type TrustDomainPolicy func(path []spiffeid.TrustDomain) error
+
+// AllowTrustDomains allows chains whose hops come only from the given trust domains, in any order.
+func AllowTrustDomains(tds ...spiffeid.TrustDomain) TrustDomainPolicy {
+	return func(path []spiffeid.TrustDomain) error {
+		for _, td := range path {
+			if indexOfTrustDomain(tds, td) < 0 {
+				return fmt.Errorf("trust domain %q is not allowed", td)
+			}
+		}
+		return nil
+	}
+}
+
+// RequireTrustDomainOrder allows chains whose hops come only from the given trust
+// domains and follow their order: once a hop from a trust domain is found, no later
+// hop can come from a trust domain listed before it.
+// E.g.: with example.org and partner.org, a chain can move from example.org
+// to partner.org, but never back.
+func RequireTrustDomainOrder(tds ...spiffeid.TrustDomain) TrustDomainPolicy {
+	return func(path []spiffeid.TrustDomain) error {
+		last := 0
+		for _, td := range path {
+			i := indexOfTrustDomain(tds, td)
+			switch {
+			case i < 0:
+				return fmt.Errorf("trust domain %q is not allowed", td)
+			case i < last:
+				return fmt.Errorf("trust domain %q not allowed after %q", td, tds[last])
+			}
+			last = i
+		}
+		return nil
+	}
+}
+
+func indexOfTrustDomain(tds []spiffeid.TrustDomain, td spiffeid.TrustDomain) int {
+	for i := range tds {
+		if tds[i] == td {
+			return i
+		}
+	}
+	return -1
+}
+
+// BundleSet is a set of trust bundles, keyed by trust domain.
+// It implements BundleSource and can hold the roots of federated trust domains.
+// It also implements VersionedSource, its version changing on every update.
+type BundleSet struct {
+	mtx     sync.RWMutex
+	bundles map[spiffeid.TrustDomain]*TrustBundle
+	version uint64
+}
+
+// NewBundleSet creates a new set initialized with the given bundles.
+func NewBundleSet(bundles ...*TrustBundle) *BundleSet {
+	s := &BundleSet{
+		bundles: make(map[spiffeid.TrustDomain]*TrustBundle),
+	}
+	for _, bundle := range bundles {
+		s.bundles[bundle.TrustDomain()] = bundle
+	}
+	return s
+}
+
+// Add adds a new bundle into the set. If a bundle already exists for the
+// trust domain, the existing bundle is replaced.
+func (s *BundleSet) Add(bundle *TrustBundle) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.bundles[bundle.TrustDomain()] = bundle
+	s.version++
+}
+
+// Update rotates the bundle of the trust domain of next, as in TrustBundle.Update.
+func (s *BundleSet) Update(next *Token) error {
+	if next == nil || next.Payload == nil || next.Payload.Iss == nil {
+		return fmt.Errorf("Invalid bundle LSVID\n")
+	}
+	td, err := spiffeid.TrustDomainFromString(next.Payload.Iss.CN)
+	if err != nil {
+		return fmt.Errorf("Invalid bundle trust domain: %v\n", err)
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	current, ok := s.bundles[td]
+	if !ok {
+		return fmt.Errorf("No trust bundle for trust domain %q\n", td)
+	}
+	updated, err := current.Update(next)
+	if err != nil {
+		return err
+	}
+	s.bundles[td] = updated
+	s.version++
+	return nil
+}
+
+// Remove removes the bundle for the given trust domain.
+func (s *BundleSet) Remove(td spiffeid.TrustDomain) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	delete(s.bundles, td)
+	s.version++
+}
+
+// Version implements VersionedSource.
+func (s *BundleSet) Version() uint64 {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	return s.version
+}
+
+// Has returns true if there is a bundle for the given trust domain.
+func (s *BundleSet) Has(td spiffeid.TrustDomain) bool {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	_, ok := s.bundles[td]
+	return ok
+}
+
+// GetTrustBundleForTrustDomain implements BundleSource.
+func (s *BundleSet) GetTrustBundleForTrustDomain(td spiffeid.TrustDomain) (*TrustBundle, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	bundle, ok := s.bundles[td]
+	if !ok {
+		return nil, fmt.Errorf("No trust bundle for trust domain %q\n", td)
+	}
+	return bundle, nil
+}
+
+// SPIFFEBundleSource adapts a spiffebundle.Source (e.g.: a spiffebundle.Set or a
+// workloadapi.BundleSource) into a BundleSource.
+func SPIFFEBundleSource(source spiffebundle.Source) BundleSource {
+	return spiffeBundleSource{source: source}
+}
+
+type spiffeBundleSource struct {
+	source spiffebundle.Source
+}
+
+func (s spiffeBundleSource) GetTrustBundleForTrustDomain(td spiffeid.TrustDomain) (*TrustBundle, error) {
+	bundle, err := s.source.GetBundleForTrustDomain(td)
+	if err != nil {
+		return nil, err
+	}
+	return TrustBundleFromSPIFFEBundle(bundle), nil
+}
+
+// X509BundleSource adapts an x509bundle.Source (e.g.: the source used to verify
+// X509-SVIDs) into a BundleSource holding its X.509 authorities.
+func X509BundleSource(source x509bundle.Source) BundleSource {
+	return x509BundleSource{source: source}
+}
+
+type x509BundleSource struct {
+	source x509bundle.Source
+}
+
+func (s x509BundleSource) GetTrustBundleForTrustDomain(td spiffeid.TrustDomain) (*TrustBundle, error) {
+	bundle, err := s.source.GetX509BundleForTrustDomain(td)
+	if err != nil {
+		return nil, err
+	}
+	var authorities []crypto.PublicKey
+	for _, cert := range bundle.X509Authorities() {
+		authorities = append(authorities, cert.PublicKey)
+	}
+	return &TrustBundle{
+		trustDomain: bundle.TrustDomain(),
+		authorities: authorities,
+	}, nil
+}
@@ -0,0 +1,104 @@
+package lsvid
+
+import (
+	"testing"
+
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+)
+
+func TestTrustDomainPolicies(t *testing.T) {
+	example := spiffeid.RequireTrustDomainFromString("example.org")
+	partner := spiffeid.RequireTrustDomainFromString("partner.org")
+	other := spiffeid.RequireTrustDomainFromString("other.org")
+
+	for _, tt := range []struct {
+		name   string
+		policy TrustDomainPolicy
+		path   []spiffeid.TrustDomain
+		valid  bool
+	}{
+		{"allowed", AllowTrustDomains(example, partner), []spiffeid.TrustDomain{partner, example, partner}, true},
+		{"not allowed", AllowTrustDomains(example, partner), []spiffeid.TrustDomain{example, other}, false},
+		{"in order", RequireTrustDomainOrder(example, partner), []spiffeid.TrustDomain{example, example, partner, partner}, true},
+		{"skipping", RequireTrustDomainOrder(example, partner, other), []spiffeid.TrustDomain{example, other}, true},
+		{"back", RequireTrustDomainOrder(example, partner), []spiffeid.TrustDomain{example, partner, example}, false},
+		{"reversed", RequireTrustDomainOrder(example, partner), []spiffeid.TrustDomain{partner, example}, false},
+		{"unknown", RequireTrustDomainOrder(example, partner), []spiffeid.TrustDomain{example, other}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy(tt.path)
+			if tt.valid && err != nil {
+				t.Errorf("path %v rejected: %v", tt.path, err)
+			}
+			if !tt.valid && err == nil {
+				t.Errorf("path %v accepted", tt.path)
+			}
+		})
+	}
+}
+
+func TestValidateAcrossTrustDomains(t *testing.T) {
+	exampleCA := newTestCA(t, "example.org")
+	partnerCA := newTestCA(t, "partner.org")
+	asserting := exampleCA.newSVID(t, "/asserting-wl")
+	gateway := partnerCA.newSVID(t, "/gateway")
+	target := partnerCA.newSVID(t, "/target-wl")
+
+	// example.org -> partner.org -> partner.org
+	root := &LSVID{Token: exampleCA.newLSVID(t, asserting)}
+	lsvid := extend(t, root, exampleCA.newPayload(t, asserting, gateway.ID.String()), asserting)
+	lsvid = extend(t, lsvid, partnerCA.newPayload(t, gateway, target.ID.String()), gateway)
+
+	exampleBundle := newTestTrustBundle(t, exampleCA)
+	partnerBundle := newTestTrustBundle(t, partnerCA)
+	bundles := NewBundleSet(exampleBundle, partnerBundle)
+	if !bundles.Has(partnerCA.td) {
+		t.Fatal("partner.org bundle not in set")
+	}
+
+	valid, err := Validate(lsvid.Token,
+		WithBundleSource(bundles),
+		WithTrustDomainPolicy(RequireTrustDomainOrder(exampleCA.td, partnerCA.td)))
+	if !valid {
+		t.Fatalf("cross trust domain LSVID rejected: %v", err)
+	}
+
+	if valid, _ := Validate(lsvid.Token,
+		WithBundleSource(bundles),
+		WithTrustDomainPolicy(RequireTrustDomainOrder(partnerCA.td, exampleCA.td))); valid {
+		t.Error("LSVID accepted moving back to partner.org")
+	}
+	if valid, _ := Validate(lsvid.Token,
+		WithBundleSource(bundles),
+		WithTrustDomainPolicy(AllowTrustDomains(exampleCA.td))); valid {
+		t.Error("LSVID accepted with hops from a trust domain not allowed")
+	}
+
+	// the bundle of each issuer trust domain is required
+	bundles.Remove(partnerCA.td)
+	if valid, _ := Validate(lsvid.Token, WithBundleSource(bundles)); valid {
+		t.Error("LSVID accepted without the partner.org bundle")
+	}
+	bundles.Add(newTestTrustBundle(t, newTestCA(t, "partner.org")))
+	if valid, _ := Validate(lsvid.Token, WithBundleSource(bundles)); valid {
+		t.Error("LSVID accepted with another partner.org authority")
+	}
+
+	// bundles rotate through the set
+	bundles.Add(partnerBundle)
+	next, err := BundleFromX509Bundle(partnerCA.bundle(), 2, 0, partnerCA.key)
+	if err != nil {
+		t.Fatalf("BundleFromX509Bundle: %v", err)
+	}
+	if err := bundles.Update(next); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if bundle, err := bundles.GetTrustBundleForTrustDomain(partnerCA.td); err != nil || bundle.SequenceNumber() != 2 {
+		t.Errorf("partner.org bundle not rotated: %v", err)
+	}
+	if err := bundles.Update(next); err == nil {
+		t.Error("bundle rotated twice to the same sequence number")
+	}
+}
+
+// newTestTrustBundle returns the trust bundle of the CA trust domain.
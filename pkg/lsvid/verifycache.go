@@ -0,0 +1,174 @@
+package lsvid
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+)
+
+// Default lifetime of the SharedVerificationCache entries.
+const defaultVerificationCacheTTL = 5 * time.Minute
+
+// Maximum number of verified tokens kept by a VerificationCache.
+const maxVerificationCacheEntries = 4096
+
+var (
+	sharedVerificationCacheOnce sync.Once
+	sharedVerificationCache     *VerificationCache
+)
+
+// VerificationCache keeps the nested tokens (verified prefixes of the chains) verified
+// by Validate, keyed by their digest and by the scope of the trust sources they were
+// verified with. Check the package documentation.
+type VerificationCache struct {
+	ttl time.Duration
+
+	mtx     sync.Mutex
+	entries map[string]*verifiedPrefix
+}
+
+type verifiedPrefix struct {
+	tdPaths [][]spiffeid.TrustDomain
+	opaque  []int // redacted hops, relative to the prefix token
+	expires time.Time
+}
+
+// NewVerificationCache creates a cache keeping the verified tokens for ttl, or until the
+// deadline of their constraints (Cst), if earlier.
+func NewVerificationCache(ttl time.Duration) *VerificationCache {
+	return &VerificationCache{
+		ttl:     ttl,
+		entries: make(map[string]*verifiedPrefix),
+	}
+}
+
+// SharedVerificationCache returns the cache shared by the handlers of the process,
+// keeping the verified tokens for 5 minutes.
+func SharedVerificationCache() *VerificationCache {
+	sharedVerificationCacheOnce.Do(func() {
+		sharedVerificationCache = NewVerificationCache(defaultVerificationCacheTTL)
+	})
+	return sharedVerificationCache
+}
+
+// validateHop validates the token, unless it is a verified prefix.
+func (c *VerificationCache) validateHop(lsvid *Token, hop int, config *validateConfig, opaque *[]int) ([][]spiffeid.TrustDomain, bool, error) {
+	scope, ok := trustScope(config)
+	if !ok {
+		return validateHopUncached(lsvid, hop, config, opaque)
+	}
+	digest, err := tokenDigest(lsvid)
+	if err != nil {
+		return nil, false, err
+	}
+	key := scope + hex.EncodeToString(digest)
+
+	if prefix, ok := c.get(key); ok {
+		for _, idx := range prefix.opaque {
+			*opaque = append(*opaque, hop+idx)
+		}
+		return copyTDPaths(prefix.tdPaths), true, nil
+	}
+
+	first := len(*opaque)
+	tdPaths, valid, err := validateHopUncached(lsvid, hop, config, opaque)
+	if err != nil || !valid {
+		return tdPaths, valid, err
+	}
+
+	prefix := &verifiedPrefix{
+		tdPaths: copyTDPaths(tdPaths),
+		expires: time.Now().Add(c.ttl),
+	}
+	for _, idx := range (*opaque)[first:] {
+		prefix.opaque = append(prefix.opaque, idx-hop)
+	}
+	Walk(lsvid, func(token *Token, _ int) error {
+		if token.Payload != nil && token.Payload.Cst != nil && token.Payload.Cst.Exp != 0 {
+			if deadline := time.Unix(token.Payload.Cst.Exp, 0); deadline.Before(prefix.expires) {
+				prefix.expires = deadline
+			}
+		}
+		return nil
+	})
+	c.put(key, prefix)
+
+	return tdPaths, true, nil
+}
+
+func (c *VerificationCache) get(key string) (*verifiedPrefix, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	prefix, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(prefix.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return prefix, true
+}
+
+func (c *VerificationCache) put(key string, prefix *verifiedPrefix) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if len(c.entries) >= maxVerificationCacheEntries {
+		now := time.Now()
+		for k, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxVerificationCacheEntries {
+			return
+		}
+	}
+	c.entries[key] = prefix
+}
+
+// VersionedSource is implemented by the trust sources updated in place (e.g.: BundleSet).
+// The version changes on every update, so the tokens verified before are verified again.
+type VersionedSource interface {
+	Version() uint64
+}
+
+// Purge drops the verified tokens, e.g.: when trust sources without version are updated.
+func (c *VerificationCache) Purge() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.entries = make(map[string]*verifiedPrefix)
+}
+
+// trustScope identifies the trust sources of the validation, that the cached tokens
+// were verified with: the scope given with the cache, and the version of the
+// sources. Validations without scope are not cached.
+func trustScope(config *validateConfig) (string, bool) {
+	if config.verifyScope == "" {
+		return "", false
+	}
+	scope := fmt.Sprintf("%q;td-policy=%t;", config.verifyScope, config.tdPolicy != nil)
+	for _, source := range []interface{}{config.bundleSource, config.jwtBundleSource, config.keyResolver} {
+		if versioned, ok := source.(VersionedSource); ok {
+			scope += fmt.Sprintf("%T@%d;", source, versioned.Version())
+		} else {
+			scope += "-;"
+		}
+	}
+	return scope, true
+}
+
+// copyTDPaths copies the trust domain paths, that are reversed by checkChainPolicies.
+func copyTDPaths(tdPaths [][]spiffeid.TrustDomain) [][]spiffeid.TrustDomain {
+	paths := make([][]spiffeid.TrustDomain, 0, len(tdPaths))
+	for _, path := range tdPaths {
+		paths = append(paths, append([]spiffeid.TrustDomain(nil), path...))
+	}
+	return paths
+}
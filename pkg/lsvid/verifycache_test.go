@@ -0,0 +1,274 @@
+package lsvid
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+)
+
+// countingSource counts the trust bundle lookups, made when a token is verified.
+type countingSource struct {
+	source  BundleSource
+	lookups atomic.Int32
+}
+
+func (s *countingSource) GetTrustBundleForTrustDomain(td spiffeid.TrustDomain) (*TrustBundle, error) {
+	s.lookups.Add(1)
+	return s.source.GetTrustBundleForTrustDomain(td)
+}
+
+func TestVerificationCacheHit(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	svids := []*x509svid.SVID{ca.newSVID(t, "/a"), ca.newSVID(t, "/b"), ca.newSVID(t, "/c")}
+	lsvid := ca.newChain(t, svids, nil)
+	source := &countingSource{source: newTestTrustBundle(t, ca)}
+	cache := NewVerificationCache(time.Minute)
+
+	if valid, err := Validate(lsvid.Token, WithBundleSource(source), WithVerificationCache(cache, "test")); !valid {
+		t.Fatalf("LSVID rejected: %v", err)
+	}
+	if source.lookups.Load() == 0 {
+		t.Fatal("LSVID not verified")
+	}
+
+	// The chain, then the chain extended by the next tier, are not verified again
+	source.lookups.Store(0)
+	if valid, err := Validate(lsvid.Token, WithBundleSource(source), WithVerificationCache(cache, "test")); !valid {
+		t.Fatalf("cached LSVID rejected: %v", err)
+	}
+	if n := source.lookups.Load(); n != 0 {
+		t.Errorf("cached LSVID verified again (%d lookups)", n)
+	}
+
+	d := ca.newSVID(t, "/d")
+	extended := extend(t, lsvid, ca.newPayload(t, svids[2], d.ID.String()), svids[2])
+	if valid, err := Validate(extended.Token, WithBundleSource(source), WithVerificationCache(cache, "test")); !valid {
+		t.Fatalf("extended LSVID rejected: %v", err)
+	}
+	// Only the issuer LSVID of the new hop is verified
+	if n := source.lookups.Load(); n != 1 {
+		t.Errorf("got %d lookups for the new hop, want 1", n)
+	}
+
+	// Tampered chains have another digest
+	extended.Token.Nested.Signature[len(extended.Token.Nested.Signature)-1] ^= 0xff
+	if valid, _ := Validate(extended.Token, WithBundleSource(source), WithVerificationCache(cache, "test")); valid {
+		t.Error("tampered LSVID accepted")
+	}
+}
+
+// A token verified under a trust config is verified again under another.
+func TestVerificationCacheTrustScope(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	svids := []*x509svid.SVID{ca.newSVID(t, "/a"), ca.newSVID(t, "/b"), ca.newSVID(t, "/c")}
+	lsvid := ca.newChain(t, svids, nil)
+	cache := NewVerificationCache(time.Minute)
+
+	// Verified with the keys embedded in the chain, without bundle source
+	if valid, err := Validate(lsvid.Token, WithVerificationCache(cache, "embedded")); !valid {
+		t.Fatalf("LSVID rejected: %v", err)
+	}
+	if valid, err := Validate(lsvid.Token, WithBundleSource(newTestTrustBundle(t, ca)), WithVerificationCache(cache, "bundle")); !valid {
+		t.Fatalf("LSVID rejected with bundle source: %v", err)
+	}
+
+	other := newTestCA(t, "example.org")
+	if valid, _ := Validate(lsvid.Token, WithBundleSource(newTestTrustBundle(t, other)), WithVerificationCache(cache, "other")); valid {
+		t.Error("LSVID of another CA accepted from the cache")
+	}
+
+	// Chains of a self-made issuer are cached without bundle source only
+	forged := other.newChain(t, []*x509svid.SVID{other.newSVID(t, "/a"), other.newSVID(t, "/b")}, nil)
+	if valid, err := Validate(forged.Token, WithVerificationCache(cache, "embedded")); !valid {
+		t.Fatalf("LSVID rejected without bundle source: %v", err)
+	}
+	if valid, _ := Validate(forged.Token, WithBundleSource(newTestTrustBundle(t, ca)), WithVerificationCache(cache, "bundle")); valid {
+		t.Error("forged LSVID accepted from the cache")
+	}
+
+	// Without scope, tokens are not cached
+	cache = NewVerificationCache(time.Minute)
+	if valid, err := Validate(lsvid.Token, WithVerificationCache(cache, "")); !valid {
+		t.Fatalf("LSVID rejected: %v", err)
+	}
+	if len(cache.entries) != 0 {
+		t.Errorf("got %d cached tokens without scope, want 0", len(cache.entries))
+	}
+}
+
+// Tokens are verified again when the bundle set is updated in place, or the cache purged.
+func TestVerificationCacheBundleUpdate(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	svids := []*x509svid.SVID{ca.newSVID(t, "/a"), ca.newSVID(t, "/b"), ca.newSVID(t, "/c")}
+	lsvid := ca.newChain(t, svids, nil)
+	bundles := NewBundleSet(newTestTrustBundle(t, ca))
+	source := &countingSource{source: bundles}
+	cache := NewVerificationCache(time.Minute)
+
+	checkValidate(t, lsvid.Token, true, WithBundleSource(bundles), WithVerificationCache(cache, "test"))
+
+	// the trust domain moves to another CA
+	other := newTestCA(t, "example.org")
+	bundles.Remove(ca.td)
+	bundles.Add(newTestTrustBundle(t, other))
+	checkValidate(t, lsvid.Token, false, WithBundleSource(bundles), WithVerificationCache(cache, "test"))
+
+	// sources without version are verified again once the cache is purged
+	bundles.Add(newTestTrustBundle(t, ca))
+	checkValidate(t, lsvid.Token, true, WithBundleSource(source), WithVerificationCache(cache, "test"))
+	source.lookups.Store(0)
+	checkValidate(t, lsvid.Token, true, WithBundleSource(source), WithVerificationCache(cache, "test"))
+	if n := source.lookups.Load(); n != 0 {
+		t.Errorf("cached LSVID verified again (%d lookups)", n)
+	}
+	cache.Purge()
+	checkValidate(t, lsvid.Token, true, WithBundleSource(source), WithVerificationCache(cache, "test"))
+	if source.lookups.Load() == 0 {
+		t.Error("LSVID not verified after purge")
+	}
+}
+
+func TestVerificationCacheExpiry(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	svids := []*x509svid.SVID{ca.newSVID(t, "/a"), ca.newSVID(t, "/b"), ca.newSVID(t, "/c")}
+	exp := time.Now().Add(30 * time.Second).Unix()
+	lsvid := ca.newChain(t, svids, func(hop int, payload *Payload) {
+		if hop == 0 {
+			payload.Cst = &Constraints{Exp: exp}
+		}
+	})
+	cache := NewVerificationCache(time.Hour)
+	if valid, err := Validate(lsvid.Token, WithVerificationCache(cache, "test")); !valid {
+		t.Fatalf("LSVID rejected: %v", err)
+	}
+
+	// The hop of /a and the outer hop are constrained, the root is kept for the cache TTL
+	if len(cache.entries) != 3 {
+		t.Fatalf("got %d cached tokens, want 3", len(cache.entries))
+	}
+	var constrained int
+	for key, prefix := range cache.entries {
+		if !prefix.expires.Equal(time.Unix(exp, 0)) {
+			if ttl := time.Until(prefix.expires); ttl <= 0 || ttl > time.Hour {
+				t.Errorf("got expiry in %s, want 1h", ttl)
+			}
+			continue
+		}
+		constrained++
+		// past the deadline, the token is not cached any more
+		prefix.expires = time.Now().Add(-time.Second)
+		if _, ok := cache.get(key); ok {
+			t.Error("expired token found")
+		}
+	}
+	if constrained != 2 {
+		t.Errorf("got %d tokens expiring at the constraints deadline, want 2", constrained)
+	}
+	if len(cache.entries) != 1 {
+		t.Errorf("got %d cached tokens after expiry, want 1", len(cache.entries))
+	}
+
+	// Without constraints, tokens are kept for the cache TTL
+	lsvid = ca.newChain(t, svids, nil)
+	cache = NewVerificationCache(time.Minute)
+	if valid, err := Validate(lsvid.Token, WithVerificationCache(cache, "test")); !valid {
+		t.Fatalf("LSVID rejected: %v", err)
+	}
+	for _, prefix := range cache.entries {
+		if ttl := time.Until(prefix.expires); ttl <= 0 || ttl > time.Minute {
+			t.Errorf("got expiry in %s, want 1m", ttl)
+		}
+	}
+}
+
+// The redacted hops of a cached token are reported relative to the validated chain.
+func TestVerificationCacheRedactedHops(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	svids := []*x509svid.SVID{ca.newSVID(t, "/a"), ca.newSVID(t, "/b"), ca.newSVID(t, "/c"), ca.newSVID(t, "/d")}
+	lsvid := ca.newChain(t, svids, func(_ int, payload *Payload) {
+		payload.Ver = VerHashLinked
+	})
+	if err := Redact(lsvid, 1); err != nil {
+		t.Fatalf("Redact: %v", err)
+	}
+	var opaque []int
+	policy := WithRedactionPolicy(func(hops []int) error {
+		opaque = append([]int(nil), hops...)
+		return nil
+	})
+	cache := NewVerificationCache(time.Minute)
+
+	if valid, err := Validate(lsvid.Token, policy, WithVerificationCache(cache, "test")); !valid {
+		t.Fatalf("redacted LSVID rejected: %v", err)
+	}
+	if len(opaque) != 1 || opaque[0] != 1 {
+		t.Fatalf("got redacted hops %v, want [1]", opaque)
+	}
+
+	// Extended twice, the redacted hop is found 2 hops deeper from the cache
+	e := ca.newSVID(t, "/e")
+	f := ca.newSVID(t, "/f")
+	payload := ca.newPayload(t, svids[3], e.ID.String())
+	payload.Ver = VerHashLinked
+	extended := extend(t, lsvid, payload, svids[3])
+	payload = ca.newPayload(t, e, f.ID.String())
+	payload.Ver = VerHashLinked
+	extended = extend(t, extended, payload, e)
+
+	for _, c := range []*VerificationCache{cache, NewVerificationCache(time.Minute)} {
+		opaque = nil
+		if valid, err := Validate(extended.Token, policy, WithVerificationCache(c, "test")); !valid {
+			t.Fatalf("extended LSVID rejected: %v", err)
+		}
+		if len(opaque) != 1 || opaque[0] != 3 {
+			t.Errorf("got redacted hops %v, want [3]", opaque)
+		}
+	}
+}
+
+func TestTrustScope(t *testing.T) {
+	ca := newTestCA(t, "example.org")
+	bundles := NewBundleSet(newTestTrustBundle(t, ca))
+
+	scope := func(config *validateConfig) string {
+		s, ok := trustScope(config)
+		if !ok {
+			t.Fatalf("config %+v not scoped", config)
+		}
+		return s
+	}
+	base := scope(&validateConfig{bundleSource: bundles, verifyScope: "test"})
+	if base != scope(&validateConfig{bundleSource: bundles, verifyScope: "test", verifyCache: NewVerificationCache(0)}) {
+		t.Error("same trust sources scoped differently")
+	}
+	for _, config := range []*validateConfig{
+		{bundleSource: bundles, verifyScope: "other"},
+		{verifyScope: "test"},
+		{bundleSource: bundles, verifyScope: "test", tdPolicy: func([]spiffeid.TrustDomain) error { return nil }},
+	} {
+		if scope(config) == base {
+			t.Errorf("config %+v scoped as the base config", config)
+		}
+	}
+
+	// Each update of the set changes the scope
+	scopes := map[string]bool{base: true}
+	for _, update := range []func(){
+		func() { bundles.Add(newTestTrustBundle(t, newTestCA(t, "other.org"))) },
+		func() { bundles.Remove(spiffeid.RequireTrustDomainFromString("other.org")) },
+	} {
+		update()
+		s := scope(&validateConfig{bundleSource: bundles, verifyScope: "test"})
+		if scopes[s] {
+			t.Errorf("scope %s unchanged after update", s)
+		}
+		scopes[s] = true
+	}
+
+	if _, ok := trustScope(&validateConfig{bundleSource: bundles}); ok {
+		t.Error("config without scope scoped")
+	}
+}
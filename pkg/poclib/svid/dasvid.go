@@ -1152,6 +1152,7 @@ func NewSchnorrencode(claimset map[string]interface{}, oldmain string, key kyber
 }
 
 // generate a new ecdsa signed encoded assertion
+// key may be any ECDSA crypto.Signer (e.g.: an lsvid.SignerClient)
 func NewECDSAencode(claimset map[string]interface{}, oldmain string, key crypto.Signer) (string, error) {
 	defer timeTrack(time.Now(), "newencode")
 
@@ -1162,7 +1163,7 @@ func NewECDSAencode(claimset map[string]interface{}, oldmain string, key crypto.
 	// If no oldmain, generates a simple assertion
 	if oldmain == "" {
 		hash := hash256.Sum256([]byte(payload))
-		s, err := ecdsa.SignASN1(rand.Reader, key.(*ecdsa.PrivateKey), hash[:])
+		s, err := key.Sign(rand.Reader, hash[:], crypto.SHA256)
 		if err != nil {
 			fmt.Printf("Error signing: %s\n", err)
 			return "", err
@@ -1177,7 +1178,7 @@ func NewECDSAencode(claimset map[string]interface{}, oldmain string, key crypto.
 
 	//  Otherwise, append assertion to previous content (oldmain) and sign it
 	hash := hash256.Sum256([]byte(payload + "." + oldmain))
-	s, err := ecdsa.SignASN1(rand.Reader, key.(*ecdsa.PrivateKey), hash[:])
+	s, err := key.Sign(rand.Reader, hash[:], crypto.SHA256)
 	if err != nil {
 		fmt.Printf("Error signing: %s\n", err)
 		return "", err
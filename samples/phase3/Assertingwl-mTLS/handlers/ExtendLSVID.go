@@ -145,6 +145,13 @@ func ExtendLSVIDHandler(w http.ResponseWriter, r *http.Request) {
 			},
 			Dpa:	fmt.Sprintf("%v", oauthissuer),
 			Dpr:	fmt.Sprintf("%v", tokenclaims["sub"]),	
+			// delegated scope, that later hops can only narrow
+			Scp:	oauthScope(tokenclaims),
+			// status index, to allow the revocation of the extension
+			Sts:	assertingStatusList().Allocate(),
+		}
+		if err := assertingStatusList().Save(statusListPath); err != nil {
+			log.Fatalf("Error saving status list: %v\n", err)
 		}
 
 		
@@ -162,6 +169,19 @@ func ExtendLSVIDHandler(w http.ResponseWriter, r *http.Request) {
 			log.Fatal("Error extending LSVID: %v\n", err)
 		} 
 
+		// Record the extension in the transparency log, carrying its inclusion proof
+		decExtended, err := lsvid.Decode(extendedLSVID)
+		if err != nil {
+			log.Fatalf("Unable to decode LSVID %v\n", err)
+		}
+		if err := assertingTransparencyLog().AppendToken(decExtended); err != nil {
+			log.Fatalf("Error logging LSVID: %v\n", err)
+		}
+		extendedLSVID, err = lsvid.Encode(decExtended)
+		if err != nil {
+			log.Fatalf("Error encoding LSVID: %v\n", err)
+		}
+
 		log.Printf("Extended LSVID: ", fmt.Sprintf("%s",extendedLSVID))
 
 
@@ -194,3 +214,11 @@ func ExtendLSVIDHandler(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Data:%v",Data)
 		json.NewEncoder(w).Encode(Data)
 }
+
+// Retrieve OAuth token scopes, either from "scope" or "scp" claim
+func oauthScope(tokenclaims map[string]interface{}) []string {
+	if scope, ok := tokenclaims["scope"]; ok {
+		return lsvid.ParseScope(scope)
+	}
+	return lsvid.ParseScope(tokenclaims["scp"])
+}
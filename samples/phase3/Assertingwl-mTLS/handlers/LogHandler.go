@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	// LSVID pkg
+	lsvid "github.com/hpe-usp-spire/signed-assertions/lsvid"
+)
+
+// Transparency log of the DA-SVIDs and LSVID hops issued by the asserting workload
+const transparencyLogPath = "./data/transparency.log"
+
+var (
+	transparencyLogOnce sync.Once
+	transparencyLog     *lsvid.TransparencyLog
+)
+
+func assertingTransparencyLog() *lsvid.TransparencyLog {
+	transparencyLogOnce.Do(func() {
+		tlog, err := lsvid.OpenTransparencyLog(transparencyLogPath)
+		if err != nil {
+			log.Fatalf("Error opening transparency log: %v\n", err)
+		}
+		transparencyLog = tlog
+	})
+	return transparencyLog
+}
+
+// Returns the signed tree head of the transparency log.
+// Output: encoded signed tree head LSVID
+func LogTreeHeadHandler(w http.ResponseWriter, r *http.Request) {
+	defer timeTrack(time.Now(), "Log tree head endpoint")
+
+	issuer, key := assertingIssuer()
+	sth, err := assertingTransparencyLog().SignTreeHead(issuer, key)
+	if err != nil {
+		log.Printf("Error signing tree head: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	encSTH, err := lsvid.Encode(&lsvid.LSVID{Token: sth})
+	if err != nil {
+		log.Printf("Error encoding tree head: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Fprint(w, encSTH)
+}
+
+// Returns the inclusion proof of a log entry.
+// Inputs: Index of the entry, tree Size
+// Output: inclusion proof
+func LogProofHandler(w http.ResponseWriter, r *http.Request) {
+	defer timeTrack(time.Now(), "Log proof endpoint")
+
+	index, err1 := strconv.ParseUint(r.FormValue("Index"), 10, 64)
+	size, err2 := strconv.ParseUint(r.FormValue("Size"), 10, 64)
+	if err1 != nil || err2 != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	proof, err := assertingTransparencyLog().InclusionProof(index, size)
+	if err != nil {
+		log.Printf("Error generating inclusion proof: %v", err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(proof)
+}
+
+// Returns the consistency proof between two tree heads.
+// Inputs: First and Second tree sizes
+// Output: consistency proof hashes
+func LogConsistencyHandler(w http.ResponseWriter, r *http.Request) {
+	defer timeTrack(time.Now(), "Log consistency endpoint")
+
+	first, err1 := strconv.ParseUint(r.FormValue("First"), 10, 64)
+	second, err2 := strconv.ParseUint(r.FormValue("Second"), 10, 64)
+	if err1 != nil || err2 != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	proof, err := assertingTransparencyLog().ConsistencyProof(first, second)
+	if err != nil {
+		log.Printf("Error generating consistency proof: %v", err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(proof)
+}
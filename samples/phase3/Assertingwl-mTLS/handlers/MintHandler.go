@@ -171,6 +171,11 @@ func MintHandler(w http.ResponseWriter, r *http.Request) {
 				}
 			}
 
+			// Record the DA-SVID in the transparency log
+			if _, err := assertingTransparencyLog().Append([]byte(token)); err != nil {
+				log.Fatalf("Error logging DA-SVID: %v\n", err)
+			}
+
 			// Data to be returned in API
 			Data = models.PocData{
 				OauthSigValidation:    sigresult,
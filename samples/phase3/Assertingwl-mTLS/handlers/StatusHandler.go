@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hpe-usp-spire/signed-assertions/phase3/Assertingwl-mTLS/local"
+	"github.com/hpe-usp-spire/signed-assertions/phase3/Assertingwl-mTLS/models"
+	dasvid "github.com/hpe-usp-spire/signed-assertions/poclib/svid"
+	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
+
+	// LSVID pkg
+	lsvid "github.com/hpe-usp-spire/signed-assertions/lsvid"
+)
+
+// Status list state file, and how long verifiers may cache the list
+const (
+	statusListPath = "./data/status.list"
+	statusListTTL  = 5 * time.Minute
+)
+
+var (
+	statusListOnce sync.Once
+	statusList     *lsvid.StatusList
+)
+
+// Status list of the LSVID hops issued by the asserting workload
+func assertingStatusList() *lsvid.StatusList {
+	statusListOnce.Do(func() {
+		uri := "https://" + local.Options.AssertingWLIP + "/status"
+		list, err := lsvid.LoadStatusList(statusListPath, uri, statusListTTL)
+		if err != nil {
+			log.Fatalf("Error loading status list: %v\n", err)
+		}
+		statusList = list
+	})
+	return statusList
+}
+
+// Publishes the signed status list of the issued LSVID hops.
+// Output: encoded status list LSVID
+func StatusHandler(w http.ResponseWriter, r *http.Request) {
+	defer timeTrack(time.Now(), "Status endpoint")
+
+	issuer, key := assertingIssuer()
+	token, err := assertingStatusList().Sign(issuer, key)
+	if err != nil {
+		log.Printf("Error signing status list: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	encList, err := lsvid.Encode(&lsvid.LSVID{Token: token})
+	if err != nil {
+		log.Printf("Error encoding status list: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Fprint(w, encList)
+}
+
+// Revokes issued LSVID hops. Restricted to POST requests of the admin workload (ADMIN_ID option).
+// Inputs (form): Subject (revokes all hops delegated by the subject) or LSVID (revokes its last hop)
+// Output: revoked status indexes
+func RevokeHandler(w http.ResponseWriter, r *http.Request) {
+	defer timeTrack(time.Now(), "Revoke endpoint")
+
+	local.InitGlobals()
+
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		log.Printf("Revocation requires an mTLS client certificate")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	clientspiffeid, err := x509svid.IDFromCert(r.TLS.PeerCertificates[0])
+	if err != nil || local.Options.AdminID == "" || clientspiffeid.String() != local.Options.AdminID {
+		log.Printf("Revocation not authorized for %v", clientspiffeid)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	assertingID := dasvid.FetchX509SVID().ID.String()
+
+	var revoked []int
+	if subject := r.FormValue("Subject"); subject != "" {
+		// Look for the hops issued to the subject in the cache file
+		file, err := os.Open("./data/dasvid.data")
+		if err != nil {
+			log.Printf("Error opening cache file: %v", err)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			var contents models.FileContents
+			if err := json.Unmarshal(scanner.Bytes(), &contents); err != nil {
+				continue
+			}
+			// DA-SVIDs are also in the cache file, skip them
+			decLSVID, err := lsvid.Decode(contents.DASVIDToken)
+			if err != nil {
+				continue
+			}
+			if idx, ok := issuedStatus(decLSVID.Token, assertingID); ok && decLSVID.Token.Payload.Dpr == subject {
+				revoked = append(revoked, idx)
+			}
+		}
+	} else {
+		decLSVID, err := lsvid.Decode(r.FormValue("LSVID"))
+		if err != nil {
+			log.Printf("Unable to decode LSVID %v\n", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		idx, ok := issuedStatus(decLSVID.Token, assertingID)
+		if !ok {
+			log.Printf("LSVID not issued by %s", assertingID)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		revoked = append(revoked, idx)
+	}
+
+	list := assertingStatusList()
+	for _, idx := range revoked {
+		if err := list.Revoke(idx); err != nil {
+			log.Printf("Error revoking %d: %v", idx, err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		log.Printf("Revoked status index: %d", idx)
+	}
+	if err := list.Save(statusListPath); err != nil {
+		log.Fatalf("Error saving status list: %v\n", err)
+	}
+
+	json.NewEncoder(w).Encode(revoked)
+}
+
+// Issuer claim and key of the tokens signed by the asserting workload
+func assertingIssuer() (*lsvid.IDClaim, crypto.Signer) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	assertingSVID := dasvid.FetchX509SVID()
+
+	assertingLSVID, err := lsvid.FetchLSVID(ctx, local.Options.SocketPath)
+	if err != nil {
+		log.Fatalf("Error fetching LSVID: %v\n", err)
+	}
+	decAsserting, err := lsvid.Decode(assertingLSVID)
+	if err != nil {
+		log.Fatalf("Unable to decode LSVID %v\n", err)
+	}
+
+	return &lsvid.IDClaim{
+		CN: assertingSVID.ID.String(),
+		ID: decAsserting.Token,
+	}, assertingSVID.PrivateKey
+}
+
+// Status index of a hop issued by the asserting workload
+func issuedStatus(token *lsvid.Token, assertingID string) (int, bool) {
+	if token == nil || token.Payload == nil || token.Payload.Sts == nil || token.Payload.Iss == nil || token.Payload.Iss.CN != assertingID {
+		return 0, false
+	}
+	return token.Payload.Sts.Idx, true
+}
@@ -19,6 +19,13 @@ func AssertingWLRouter(ctx context.Context) (*mux.Router, error) {
 	s.HandleFunc("/ecdsaassertion", handlers.ECDSAAssertionHandler).Methods("GET")
 	// New LSVID endpoint: Receives an LSVID and an OAuth and returns extended LSVID with oauth delegation
 	s.HandleFunc("/extendlsvid", handlers.ExtendLSVIDHandler).Methods("GET")
+	// LSVID status list, and admin revocation of the issued LSVID hops
+	s.HandleFunc("/status", handlers.StatusHandler).Methods("GET")
+	s.HandleFunc("/revoke", handlers.RevokeHandler).Methods("POST")
+	// Transparency log of the issued DA-SVIDs and LSVID hops
+	s.HandleFunc("/log/sth", handlers.LogTreeHeadHandler).Methods("GET")
+	s.HandleFunc("/log/proof", handlers.LogProofHandler).Methods("GET")
+	s.HandleFunc("/log/consistency", handlers.LogConsistencyHandler).Methods("GET")
 
 
 	s.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -21,6 +21,7 @@ const (
 	ENV_ISSUER          string = "ISSUER"
 	ENV_HOST_IP         string = "HOSTIP"
 	ENV_TARGET_WL_IP    string = "TARGETWLIP"
+	ENV_ADMIN_ID        string = "ADMIN_ID"
 )
 
 // global int constants related to
@@ -43,6 +43,7 @@ func InitGlobals(opts *options.Options) {
 	opts.Issuer =			env.String(constants.ENV_ISSUER, Options.Issuer)
 	opts.HostIP =			env.String(constants.ENV_HOST_IP, Options.HostIP)
 	opts.TargetWLIP =		env.String(constants.ENV_TARGET_WL_IP, Options.TargetWLIP)
+	opts.AdminID =			env.String(constants.ENV_ADMIN_ID, Options.AdminID)
 
 	log.Printf("api init options: %+v", opts)
 }
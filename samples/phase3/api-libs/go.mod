@@ -4,6 +4,7 @@ go 1.20
 
 require (
 	github.com/gorilla/mux v1.8.0
+	github.com/hpe-usp-spire/signed-assertions/lsvid v0.0.0-00010101000000-000000000000
 	github.com/hpe-usp-spire/signed-assertions/poclib v0.0.0-00010101000000-000000000000
 	github.com/smartystreets/goconvey v1.8.0
 	github.com/spiffe/go-spiffe/v2 v2.1.4
@@ -281,6 +282,8 @@ require (
 
 replace github.com/hpe-usp-spire/signed-assertions/poclib => ../../poclib
 
+replace github.com/hpe-usp-spire/signed-assertions/lsvid => ../../../pkg/lsvid
+
 require (
 	github.com/Microsoft/go-winio v0.6.0 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
@@ -16,6 +16,7 @@ type Options struct {
 	Issuer        string `json:"ISSUER" yaml:"ISSUER" mapstructure:"ISSUER" default:""`
 	HostIP        string `json:"HOSTIP" yaml:"HOSTIP" mapstructure:"HOSTIP" default:""`
 	TargetWLIP    string `json:"TARGETWLIP" yaml:"TARGETWLIP" mapstructure:"TARGETWLIP" default:"8444"`
+	AdminID       string `json:"ADMIN_ID" yaml:"ADMIN_ID" mapstructure:"ADMIN_ID" default:""`
 }
 
 // NewOptions returns a ptr to a new options object
@@ -0,0 +1,21 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/hpe-usp-spire/signed-assertions/phase3/api-libs/models"
+
+	// LSVID pkg
+	lsvid "github.com/hpe-usp-spire/signed-assertions/lsvid"
+)
+
+// Returns the LSVID received in the request body, to verify the request signature
+// (lsvid.HTTPSignatureVerifier) and trace (lsvid.TraceMiddleware)
+func ReceivedLSVID(r *http.Request) (*lsvid.LSVID, error) {
+	var rcvSVID models.Contents
+	if err := json.NewDecoder(r.Body).Decode(&rcvSVID); err != nil {
+		return nil, err
+	}
+	return lsvid.Decode(rcvSVID.DASVIDToken)
+}
@@ -6,6 +6,7 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -13,7 +14,6 @@ import (
 	"time"
 
 	lsvid "github.com/hpe-usp-spire/signed-assertions/lsvid"
-	"github.com/hpe-usp-spire/signed-assertions/phase3/api-libs/utils"
 	"github.com/hpe-usp-spire/signed-assertions/phase3/m-tier/local"
 	dasvid "github.com/hpe-usp-spire/signed-assertions/poclib/svid"
 	"github.com/spiffe/go-spiffe/v2/spiffeid"
@@ -26,6 +26,9 @@ import (
 
 var temp models.Contents
 
+// Scope of the LSVIDs verified with their embedded keys, in the shared verification cache
+const verifyScope = "m-tier"
+
 
 func timeTrack(start time.Time, name string) {
 	elapsed := time.Since(start)
@@ -45,28 +48,24 @@ func timeTrack(start time.Time, name string) {
 
 func DepositHandler(w http.ResponseWriter, r *http.Request) {
 
-	defer utils.TimeTrack(time.Now(), "Deposit Handler")
+	// Hop timing travels with the LSVID (Rcv/Fwd claims), in place of bench.data
+	received := time.Now()
  
 	var tempbalance models.Balancetemp
-	var rcvSVID models.Contents
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	////////// DECODE LSVID /////////////
-	// Get LSVID from subject
-	json.NewDecoder(r.Body).Decode(&rcvSVID)
-	log.Print("Received LSVID: ", rcvSVID.DASVIDToken)
-
-	// Decode LSVID from b64
-	decReceivedLSVID, err := lsvid.Decode(rcvSVID.DASVIDToken)
-	if err != nil {
-		log.Fatalf("Error decoding LSVID: %v\n", err)
+	// Get LSVID from subject, decoded by the signature and trace middlewares
+	decReceivedLSVID, ok := lsvid.LSVIDFromContext(r.Context())
+	if !ok {
+		log.Fatalf("LSVID not extracted from the request\n")
 	}
 	log.Print("Decoded LSVID: ", decReceivedLSVID)
 	
 	////////// VALIDATE LSVID ////////////
-	checkLSVID, err := lsvid.Validate(decReceivedLSVID.Token)
+	checkLSVID, err := lsvid.Validate(decReceivedLSVID.Token, lsvid.WithVerificationCache(lsvid.SharedVerificationCache(), verifyScope))
 	if err != nil {
 		log.Fatalf("Error validating LSVID : %v\n", err)
 	}
@@ -85,6 +84,11 @@ func DepositHandler(w http.ResponseWriter, r *http.Request) {
 	 log.Fatalf("Bearer does not match issuer value: %v\n", err)
 	}
 
+	// Verify that the received hop was issued for this deposit
+	if err := lsvid.VerifyRequestBinding(decReceivedLSVID.Token, r, lsvid.ComponentMethod, lsvid.ComponentPath, lsvid.ComponentQueryParam+"deposit"); err != nil {
+		log.Fatalf("Error validating request binding: %v\n", err)
+	}
+
 	////////// EXTEND LSVID ////////////
 	// Fetch subject workload data
 	subjectSVID	:= dasvid.FetchX509SVID()
@@ -120,6 +124,17 @@ func DepositHandler(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Error retrieving client SPIFFE-ID from mTLS connection %v", err)
 	}
 
+	// Bind the hop to the deposit operation, so it can not be replayed with another amount
+	endpoint := "https://"+os.Getenv("TARGETWLIP")+"/deposit?DASVID="+r.FormValue("DASVID")+"&deposit="+r.FormValue("deposit")
+	request, err := http.NewRequest(http.MethodPost, endpoint, nil)
+	if err != nil {
+		log.Fatalf("Error creating request: %v", err)
+	}
+	reqBinding, err := lsvid.BindRequest(request, lsvid.ComponentMethod, lsvid.ComponentPath, lsvid.ComponentQueryParam+"deposit")
+	if err != nil {
+		log.Fatalf("Error binding request: %v\n", err)
+	}
+
 	extendedPayload := &lsvid.Payload{
 		Ver:	1,
 		Alg:	"ES256",
@@ -131,8 +146,15 @@ func DepositHandler(w http.ResponseWriter, r *http.Request) {
 		Aud:	&lsvid.IDClaim{
 			CN:	targetClientId.String(),
 		},
+		Req:	reqBinding,
 	}
 
+	// Correlate the hop with the received trace, verified by the trace middleware
+	parentTrace, _ := lsvid.TraceFromContext(r.Context())
+	if _, err := lsvid.InjectTrace(extendedPayload, request, parentTrace); err != nil {
+		log.Fatalf("Error setting trace context: %v\n", err)
+	}
+	lsvid.SetHopTiming(extendedPayload, received)
 	extendedLSVID, err := lsvid.Extend(decReceivedLSVID, extendedPayload, subjectKey)
 	if err != nil {
 		log.Fatal("Error extending LSVID: %v\n", err)
@@ -167,8 +189,22 @@ func DepositHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Make call to middle tier, asking for user funds.
-	endpoint := "https://"+os.Getenv("TARGETWLIP")+"/deposit?DASVID="+r.FormValue("DASVID")+"&deposit="+r.FormValue("deposit")
-	response, err := client.Post(endpoint, "application/json", bytes.NewBuffer(json_data))
+	// The request bound to the hop, with its traceparent header, carries the extended LSVID,
+	// and is signed (RFC 9421) with the key of the extended hop issuer
+	request.Body = ioutil.NopCloser(bytes.NewReader(json_data))
+	request.ContentLength = int64(len(json_data))
+	request.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(json_data)), nil
+	}
+	request.Header.Set("Content-Type", "application/json")
+	decExtended, err := lsvid.Decode(extendedLSVID)
+	if err != nil {
+		log.Fatalf("Unable to decode LSVID %v\n", err)
+	}
+	if err := lsvid.SignRequest(request, decExtended, subjectKey); err != nil {
+		log.Fatalf("Error signing request: %v\n", err)
+	}
+	response, err := client.Do(request)
 	if err != nil {
 		log.Fatalf("Error connecting to %q: %v", os.Getenv("TARGETWLIP"), err)
 	}
@@ -49,7 +49,7 @@ func GetBalanceHandler(w http.ResponseWriter, r *http.Request) {
 	log.Print("Decoded LSVID: ", decReceivedLSVID)
 	
 	////////// VALIDATE LSVID ////////////
-	checkLSVID, err := lsvid.Validate(decReceivedLSVID.Token)
+	checkLSVID, err := lsvid.Validate(decReceivedLSVID.Token, lsvid.WithVerificationCache(lsvid.SharedVerificationCache(), verifyScope))
 	if err != nil {
 		log.Fatalf("Error validating LSVID : %v\n", err)
 	}
@@ -3,8 +3,11 @@ package router
 import (
 	"context"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
+	lsvid "github.com/hpe-usp-spire/signed-assertions/lsvid"
+	"github.com/hpe-usp-spire/signed-assertions/phase3/api-libs/utils"
 
 	"github.com/hpe-usp-spire/signed-assertions/phase3/m-tier/handlers"
 )
@@ -13,7 +16,11 @@ func MiddleTierRouter(ctx context.Context) (*mux.Router, error) {
 	s := mux.NewRouter()
 
 	s.HandleFunc("/get_balance", handlers.GetBalanceHandler).Methods("POST")
-	s.HandleFunc("/deposit", handlers.DepositHandler).Methods("POST")
+	// Deposits must be signed (RFC 9421) by the issuer of the received LSVID
+	sigVerifier := lsvid.NewHTTPSignatureVerifier(5 * time.Minute)
+	// and carry the trace context (traceparent) signed in the received hop
+	traced := lsvid.TraceMiddleware(utils.ReceivedLSVID, http.HandlerFunc(handlers.DepositHandler))
+	s.Handle("/deposit", sigVerifier.Middleware(utils.ReceivedLSVID, traced)).Methods("POST")
 
 	s.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNotFound)
@@ -14,6 +14,7 @@ import (
 	"os"
 
 	// "html/template"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -25,7 +26,6 @@ import (
 	// "bufio"
 
 	lsvid "github.com/hpe-usp-spire/signed-assertions/lsvid"
-	"github.com/hpe-usp-spire/signed-assertions/phase3/api-libs/utils"
 	"github.com/hpe-usp-spire/signed-assertions/phase3/subject_workload/local"
 	"github.com/hpe-usp-spire/signed-assertions/phase3/subject_workload/models"
 	dasvid "github.com/hpe-usp-spire/signed-assertions/poclib/svid"
@@ -37,7 +37,8 @@ import (
 
 func DepositHandler(w http.ResponseWriter, r *http.Request) {
 
-	defer utils.TimeTrack(time.Now(), "Deposit Handler")
+	// Hop timing travels with the LSVID (Rcv/Fwd claims), in place of bench.data
+	received := time.Now()
  
 	var tempbalance models.Balancetemp
 	var rcvSVID models.Contents
@@ -115,6 +116,17 @@ func DepositHandler(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Error retrieving client SPIFFE-ID from mTLS connection %v", err)
 	}
 
+	// Bind the hop to the deposit operation, so it can not be replayed with another amount
+	endpoint := "https://"+os.Getenv("MIDDLETIERIP")+"/deposit?DASVID="+os.Getenv("DASVIDToken")+"&deposit="+r.FormValue("deposit")
+	request, err := http.NewRequest(http.MethodPost, endpoint, nil)
+	if err != nil {
+		log.Fatalf("Error creating request: %v", err)
+	}
+	reqBinding, err := lsvid.BindRequest(request, lsvid.ComponentMethod, lsvid.ComponentPath, lsvid.ComponentQueryParam+"deposit")
+	if err != nil {
+		log.Fatalf("Error binding request: %v\n", err)
+	}
+
 	extendedPayload := &lsvid.Payload{
 		Ver:	1,
 		Alg:	"ES256",
@@ -126,8 +138,15 @@ func DepositHandler(w http.ResponseWriter, r *http.Request) {
 		Aud:	&lsvid.IDClaim{
 			CN:	mtClientId.String(),
 		},
+		Req:	reqBinding,
 	}
 
+	// Correlate the hop with the trace of the received request, if any
+	parentTrace, _ := lsvid.TraceFromRequest(r)
+	if _, err := lsvid.InjectTrace(extendedPayload, request, parentTrace); err != nil {
+		log.Fatalf("Error setting trace context: %v\n", err)
+	}
+	lsvid.SetHopTiming(extendedPayload, received)
 	extendedLSVID, err := lsvid.Extend(decReceivedLSVID, extendedPayload, subjectKey)
 	if err != nil {
 		log.Fatal("Error extending LSVID: %v\n", err)
@@ -162,8 +181,22 @@ func DepositHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Make call to middle tier, asking for user funds.
-	endpoint := "https://"+os.Getenv("MIDDLETIERIP")+"/deposit?DASVID="+os.Getenv("DASVIDToken")+"&deposit="+r.FormValue("deposit")
-	response, err := client.Post(endpoint, "application/json", bytes.NewBuffer(json_data))
+	// The request bound to the hop, with its traceparent header, carries the extended LSVID,
+	// and is signed (RFC 9421) with the key of the extended hop issuer
+	request.Body = ioutil.NopCloser(bytes.NewReader(json_data))
+	request.ContentLength = int64(len(json_data))
+	request.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(json_data)), nil
+	}
+	request.Header.Set("Content-Type", "application/json")
+	decExtended, err := lsvid.Decode(extendedLSVID)
+	if err != nil {
+		log.Fatalf("Unable to decode LSVID %v\n", err)
+	}
+	if err := lsvid.SignRequest(request, decExtended, subjectKey); err != nil {
+		log.Fatalf("Error signing request: %v\n", err)
+	}
+	response, err := client.Do(request)
 	if err != nil {
 		log.Fatalf("Error connecting to %q: %v", os.Getenv("MIDDLETIERIP"), err)
 	}
@@ -12,7 +12,6 @@ import (
 	"os"
 	"time"
 
-	"github.com/hpe-usp-spire/signed-assertions/phase3/api-libs/utils"
 	"github.com/spiffe/go-spiffe/v2/svid/x509svid"
 
 	"github.com/hpe-usp-spire/signed-assertions/phase3/target-wl/models"
@@ -23,20 +22,21 @@ import (
 
 
 func DepositHandler(w http.ResponseWriter, r *http.Request) {
-	defer utils.TimeTrack(time.Now(), "DepositHandler")
+	received := time.Now()
 
 	var tempbalance models.Balancetemp
-	var rcvSVID models.Contents
 
-	json.NewDecoder(r.Body).Decode(&rcvSVID)
-	log.Print("Received LSVID: ", rcvSVID.DASVIDToken)
-
-	decLSVID, err := lsvid.Decode(rcvSVID.DASVIDToken)
-	if err != nil {
-		log.Fatalf("Error decoding LSVID: %v\n", err)
+	// Decoded by the signature and trace middlewares
+	decLSVID, ok := lsvid.LSVIDFromContext(r.Context())
+	if !ok {
+		log.Fatalf("LSVID not extracted from the request\n")
 	}
 
-	checkLSVID, err := lsvid.Validate(decLSVID.Token)
+	// Revoked hops are rejected
+	checkLSVID, err := lsvid.Validate(decLSVID.Token,
+		lsvid.WithBundleSource(targetTrustBundles()),
+		lsvid.WithStatusListCache(targetStatusLists()),
+		lsvid.WithVerificationCache(lsvid.SharedVerificationCache(), verifyScope))
 	if err != nil {
 		log.Fatalf("Error validating LSVID: %v\n", err)
 	}
@@ -57,6 +57,24 @@ func DepositHandler(w http.ResponseWriter, r *http.Request) {
 	if (clientspiffeid.String() != decLSVID.Token.Payload.Iss.CN) {
 	 log.Fatalf("Bearer does not match issuer value: %v\n", err)
 	}
+
+	// Verify that the LSVID was issued for this deposit
+	if err := lsvid.VerifyRequestBinding(decLSVID.Token, r, lsvid.ComponentMethod, lsvid.ComponentPath, lsvid.ComponentQueryParam+"deposit"); err != nil {
+		log.Fatalf("Error validating request binding: %v\n", err)
+	}
+
+	// Path latency, from the hop timing claims
+	pathLatency, err := lsvid.Latency(decLSVID.Token, received)
+	if err != nil {
+		log.Printf("Unable to compute path latency: %v\n", err)
+	} else {
+		log.Printf("Path latency:\n%s", pathLatency)
+	}
+
+	// Export the validated path as OpenTelemetry spans
+	if err := targetSpanExporter().ExportChain(decLSVID.Token); err != nil {
+		log.Printf("Error exporting LSVID spans: %v\n", err)
+	}
 	
 	//TODO - declaração de ctx?
 	//TODO - create X509 source blablabla
@@ -167,4 +185,4 @@ func DepositHandler(w http.ResponseWriter, r *http.Request) {
 // 	}
 
 // 	return introspectrsp
-// }
\ No newline at end of file
+// }
@@ -34,7 +34,11 @@ func GetBalanceHandler(w http.ResponseWriter, r *http.Request) {
 		log.Fatalf("Error decoding LSVID: %v\n", err)
 	}
 
-	checkLSVID, err := lsvid.Validate(decLSVID.Token)
+	// Revoked hops are rejected
+	checkLSVID, err := lsvid.Validate(decLSVID.Token,
+		lsvid.WithBundleSource(targetTrustBundles()),
+		lsvid.WithStatusListCache(targetStatusLists()),
+		lsvid.WithVerificationCache(lsvid.SharedVerificationCache(), verifyScope))
 	if err != nil {
 		log.Fatalf("Error validating LSVID: %v\n", err)
 	}
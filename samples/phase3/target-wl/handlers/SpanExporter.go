@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"log"
+	"sync"
+
+	// LSVID pkg
+	lsvid "github.com/hpe-usp-spire/signed-assertions/lsvid"
+)
+
+// OTLP JSON spans of the validated LSVID chains, to be read by an OpenTelemetry collector
+const spansPath = "./data/lsvid-spans.json"
+
+var (
+	spanExporterOnce sync.Once
+	spanExporter     *lsvid.OTLPExporter
+)
+
+func targetSpanExporter() *lsvid.OTLPExporter {
+	spanExporterOnce.Do(func() {
+		exporter, err := lsvid.NewOTLPFileExporter(spansPath, "target-wl")
+		if err != nil {
+			log.Fatalf("Error opening span exporter: %v\n", err)
+		}
+		spanExporter = exporter
+	})
+	return spanExporter
+}
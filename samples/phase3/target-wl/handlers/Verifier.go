@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/spiffe/go-spiffe/v2/bundle/spiffebundle"
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/spiffetls/tlsconfig"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+
+	"github.com/hpe-usp-spire/signed-assertions/phase3/target-wl/local"
+
+	// LSVID pkg
+	lsvid "github.com/hpe-usp-spire/signed-assertions/lsvid"
+)
+
+// How long the status lists without their own TTL are cached
+const statusListTTL = 5 * time.Minute
+
+// Scope of the LSVIDs verified with the trust bundles, in the shared verification cache
+const verifyScope = "target-wl"
+
+var (
+	verifierOnce sync.Once
+	trustBundles *lsvid.BundleSet
+	statusLists  *lsvid.StatusListCache
+)
+
+// Trust bundle of the LSVID roots and status lists of the LSVID hops, from the
+// Workload API. The status lists are fetched over mTLS from the trust domain workloads.
+func initVerifier() {
+	verifierOnce.Do(func() {
+		// The source is kept open, to fetch the status lists with the current SVID
+		source, err := workloadapi.NewX509Source(context.Background(), workloadapi.WithClientOptions(workloadapi.WithAddr(local.Options.SocketPath)))
+		if err != nil {
+			log.Fatalf("Unable to create X509Source: %v\n", err)
+		}
+
+		td := spiffeid.RequireTrustDomainFromString(local.Options.TrustDomain)
+		bundle, err := source.GetX509BundleForTrustDomain(td)
+		if err != nil {
+			log.Fatalf("Unable to get trust bundle: %v\n", err)
+		}
+		trustBundles = lsvid.NewBundleSet(lsvid.TrustBundleFromSPIFFEBundle(spiffebundle.FromX509Bundle(bundle)))
+
+		client := &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: tlsconfig.MTLSClientConfig(source, source, tlsconfig.AuthorizeMemberOf(td)),
+			},
+		}
+		statusLists = lsvid.NewStatusListCache(client, statusListTTL)
+	})
+}
+
+func targetTrustBundles() *lsvid.BundleSet {
+	initVerifier()
+	return trustBundles
+}
+
+func targetStatusLists() *lsvid.StatusListCache {
+	initVerifier()
+	return statusLists
+}